@@ -0,0 +1,124 @@
+// Package apierror описывает ошибки в ответах API. Ошибка передаётся в формате JSON:
+// текст в ключе error (как было всегда), машиночитаемый код в ключе code и,
+// для ошибок проверки запроса, сообщения об отдельных полях в details.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Коды ошибок. По коду клиент различает ошибки, не разбирая текст
+const (
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeDBError          = "db_error"
+	CodeUpstreamError    = "upstream_error"
+	CodeInternal         = "internal_error"
+)
+
+// Error — ошибка API с HTTP-статусом Status. Details содержит сообщения
+// об ошибках отдельных полей запроса
+type Error struct {
+	Status  int               `json:"-"`
+	Code    string            `json:"code"`
+	Message string            `json:"error"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New() создаёт ошибку с HTTP-статусом status и кодом, соответствующим статусу
+func New(status int, msg string) *Error {
+	return &Error{Status: status, Code: codeOf(status), Message: msg}
+}
+
+// Validation() возвращает ошибку проверки запроса (400)
+func Validation(msg string) *Error {
+	return New(http.StatusBadRequest, msg)
+}
+
+// Field() возвращает ошибку проверки поля field запроса (400)
+func Field(field, msg string) *Error {
+	return Validation(msg).For(field)
+}
+
+// For() относит ошибку к полю field запроса и возвращает её же
+func (e *Error) For(field string) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+	e.Details[field] = e.Message
+	return e
+}
+
+// NotFound() возвращает ошибку 404
+func NotFound(msg string) *Error {
+	return New(http.StatusNotFound, msg)
+}
+
+// DB() возвращает ошибку хранилища (500)
+func DB(err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeDBError, Message: fmt.Sprintf("ошибка работы с БД: %v", err)}
+}
+
+// codeOf() возвращает код ошибки для HTTP-статуса
+func codeOf(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeValidationFailed
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusBadGateway:
+		return CodeUpstreamError
+	}
+	return CodeInternal
+}
+
+// JSON() отправляет data в формате JSON с HTTP-статусом status
+func JSON(w http.ResponseWriter, status int, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(New(status, fmt.Sprintf("ошибка сериализации: %v", err)))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Write() отправляет ошибку err с её HTTP-статусом. Ошибка, не являющаяся *Error,
+// отправляется как внутренняя ошибка сервера
+func Write(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = New(http.StatusInternalServerError, err.Error())
+	}
+	JSON(w, e.Status, e)
+}
+
+// MethodNotAllowed() отвечает ошибкой 405 и перечисляет допустимые методы в заголовке Allow
+func MethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	Write(w, New(http.StatusMethodNotAllowed, "метод не поддерживается"))
+}
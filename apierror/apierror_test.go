@@ -0,0 +1,61 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, fmt.Errorf("задача: %w", Field("title", "не указан заголовок задачи")))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/json; charset=UTF-8", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"code":"validation_failed","error":"не указан заголовок задачи",
+		"details":{"title":"не указан заголовок задачи"}}`, rec.Body.String())
+}
+
+func TestWriteUnknownError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, errors.New("сбой"))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var e Error
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e))
+	assert.Equal(t, CodeInternal, e.Code)
+	assert.Equal(t, "сбой", e.Message)
+}
+
+func TestCodes(t *testing.T) {
+	for status, code := range map[int]string{
+		http.StatusBadRequest:          CodeValidationFailed,
+		http.StatusUnauthorized:        CodeUnauthorized,
+		http.StatusForbidden:           CodeForbidden,
+		http.StatusNotFound:            CodeNotFound,
+		http.StatusMethodNotAllowed:    CodeMethodNotAllowed,
+		http.StatusConflict:            CodeConflict,
+		http.StatusTooManyRequests:     CodeTooManyRequests,
+		http.StatusBadGateway:          CodeUpstreamError,
+		http.StatusInternalServerError: CodeInternal,
+	} {
+		assert.Equal(t, code, New(status, "ошибка").Code, status)
+	}
+	assert.Equal(t, CodeDBError, DB(errors.New("database is locked")).Code)
+	assert.Equal(t, http.StatusInternalServerError, DB(errors.New("database is locked")).Status)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	MethodNotAllowed(rec, http.MethodGet, http.MethodPost)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, POST", rec.Header().Get("Allow"))
+	assert.Contains(t, rec.Body.String(), `"code":"method_not_allowed"`)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"final_project/database"
+)
+
+// APITokenPrefix — начало всех API-токенов. По нему токен отличается от JWT
+// и находится сканерами секретов в репозиториях
+const APITokenPrefix = "sch_"
+
+// Области действия API-токенов: read разрешает только чтение,
+// write — также изменение данных
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// apiTokenTouchInterval — не чаще этого в хранилище записывается время
+// последнего использования API-токена
+const apiTokenTouchInterval = time.Minute
+
+// ErrUnknownScope возвращается при создании API-токена с неизвестной областью действия
+var ErrUnknownScope = errors.New("неизвестная область действия токена")
+
+type apiTokenKey struct{}
+
+// APITokenFromContext() возвращает API-токен, которым аутентифицирован запрос.
+// Если запрос аутентифицирован иначе, ok = false
+func APITokenFromContext(ctx context.Context) (database.APIToken, bool) {
+	token, ok := ctx.Value(apiTokenKey{}).(database.APIToken)
+	return token, ok
+}
+
+// CreateAPIToken() создаёт пользователю API-токен с именем name и областями scopes
+// (по умолчанию read и write). Сам токен возвращается только здесь, хранится его хеш
+func CreateAPIToken(user database.User, name string, scopes []string) (string, database.APIToken, error) {
+	if len(scopes) == 0 {
+		scopes = []string{ScopeRead, ScopeWrite}
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return "", database.APIToken{}, fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+		}
+	}
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", database.APIToken{}, fmt.Errorf("не удалось создать API-токен: %v", err)
+	}
+	secret := APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token := database.APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		Hash:      hashAPIToken(secret),
+		Prefix:    secret[:len(APITokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	id, err := users.CreateAPIToken(token)
+	if err != nil {
+		return "", database.APIToken{}, err
+	}
+	token.ID = id
+	return secret, token, nil
+}
+
+// hashAPIToken() возвращает хеш, под которым хранится API-токен. Токен случайный
+// и длинный, поэтому медленный хеш вроде bcrypt не нужен
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateAPIToken() проверяет API-токен и возвращает пользователя, которому он выдан
+func validateAPIToken(secret string) (database.User, database.APIToken, error) {
+	token, err := users.GetAPITokenByHash(hashAPIToken(secret))
+	if errors.Is(err, database.ErrAPITokenNotFound) {
+		return database.User{}, token, fmt.Errorf("%w: API-токен не найден", ErrInvalidToken)
+	}
+	if err != nil {
+		return database.User{}, token, err
+	}
+	user, err := users.GetUser(token.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return database.User{}, token, fmt.Errorf("%w: пользователь удалён", ErrInvalidToken)
+	}
+	if err != nil {
+		return database.User{}, token, err
+	}
+
+	if now := time.Now(); now.Sub(token.LastUsedAt) >= apiTokenTouchInterval {
+		if err := users.TouchAPIToken(token.ID, now); err != nil {
+			log.Println("не удалось обновить время использования API-токена: ", err)
+		}
+		token.LastUsedAt = now
+	}
+	return user, token, nil
+}
+
+// bearerToken() возвращает токен из заголовка Authorization: Bearer
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// scopeAllows() проверяет, разрешает ли API-токен с областями scopes запрос методом method
+func scopeAllows(scopes []string, method string) bool {
+	if slices.Contains(scopes, ScopeWrite) {
+		return true
+	}
+	return slices.Contains(readMethods, method) && slices.Contains(scopes, ScopeRead)
+}
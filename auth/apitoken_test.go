@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"final_project/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIToken(t *testing.T) {
+	mem := useUsers(t)
+	id, err := mem.CreateUser(database.User{Login: "ivan"})
+	require.NoError(t, err)
+	ivan, err := mem.GetUser(id)
+	require.NoError(t, err)
+
+	readOnly, stored, err := CreateAPIToken(ivan, "CI", []string{ScopeRead, ScopeRead})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(readOnly, APITokenPrefix))
+	assert.True(t, strings.HasPrefix(readOnly, stored.Prefix))
+	assert.Equal(t, []string{ScopeRead}, stored.Scopes)
+	assert.NotContains(t, stored.Hash, readOnly, "токен хранится только в виде хеша")
+	full, _, err := CreateAPIToken(ivan, "скрипт", nil)
+	require.NoError(t, err)
+	_, _, err = CreateAPIToken(ivan, "admin", []string{"admin"})
+	assert.ErrorIs(t, err, ErrUnknownScope)
+
+	do := func(method, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/task", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		Auth(whoami)(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, readOnly)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ivan", rec.Body.String())
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, readOnly).Code, "токен только для чтения")
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, full).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, APITokenPrefix+"unknown").Code)
+
+	// Время использования запоминается
+	tokens, err := mem.ListAPITokens(id)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.False(t, tokens[0].LastUsedAt.IsZero())
+
+	// Заголовок Bearer принимает и access-токен JWT
+	pair, err := IssueTokens(ivan)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, pair.Token).Code)
+
+	// Ленты принимают API-токен в параметре запроса
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks.ics?token="+readOnly, nil)
+	rec = httptest.NewRecorder()
+	FeedAuth(whoami)(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Отозванный токен недействителен
+	require.NoError(t, mem.DeleteAPIToken(id, stored.ID))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, readOnly).Code)
+}
+
+func TestDAVAuthAPIToken(t *testing.T) {
+	mem := useUsers(t)
+	id, err := mem.CreateUser(database.User{Login: "ivan"})
+	require.NoError(t, err)
+	ivan, err := mem.GetUser(id)
+	require.NoError(t, err)
+	token, _, err := CreateAPIToken(ivan, "vdirsyncer", []string{ScopeRead})
+	require.NoError(t, err)
+	h := DAVAuth(http.HandlerFunc(whoami))
+
+	for _, tc := range []struct {
+		method, login string
+		code          int
+	}{
+		{"PROPFIND", "ivan", http.StatusOK},
+		{"REPORT", "ivan", http.StatusOK},
+		{http.MethodPut, "ivan", http.StatusForbidden},
+		{"PROPFIND", "admin", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, "/dav/", nil)
+		req.SetBasicAuth(tc.login, token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, tc.code, rec.Code, "%s %s", tc.method, tc.login)
+	}
+}
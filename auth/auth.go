@@ -1,67 +1,326 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/golang-jwt/jwt"
+	"final_project/apierror"
+	"final_project/database"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-// validateToken(tokenString) проверяет правильность переданного JWT-токена
-// и возвращает результат проверки вместе с возможной ошибкой.
-func validateToken(tokenString string) (bool, error) {
-	storedPassword := os.Getenv("TODO_PASSWORD")
+// users — хранилище учётных записей, отозванных токенов и ключей подписи,
+// по которому проверяются токены и пароли
+var users database.AccountStore
 
-	// Парсим токен и проверяем его с использованием секретного ключа
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(storedPassword), nil
-	})
+// SetUsers() задаёт хранилище учётных записей для проверки аутентификации
+// и сбрасывает ключи подписи, загруженные из прежнего хранилища
+func SetUsers(u database.AccountStore) {
+	users = u
+	resetKeys()
+}
+
+// DefaultUser — пользователь, от имени которого выполняются запросы,
+// если пароль TODO_PASSWORD не задан и аутентификация отключена
+var DefaultUser = database.User{ID: database.DefaultUserID, Login: "admin", Admin: true, Role: database.RoleEditor}
+
+type userKey struct{}
+
+// WithUser() возвращает контекст с аутентифицированным пользователем
+func WithUser(ctx context.Context, user database.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext() возвращает пользователя, от имени которого выполняется запрос.
+// Если пользователь в контексте не задан, возвращается DefaultUser
+func UserFromContext(ctx context.Context) database.User {
+	if user, ok := ctx.Value(userKey{}).(database.User); ok {
+		return user
+	}
+	return DefaultUser
+}
 
+// HashPassword() возвращает хеш bcrypt пароля для хранения в БД
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		// Возвращаем ошибку, если токен невалиден или его невозможно распарсить
-		return false, err
+		return "", fmt.Errorf("не удалось вычислить хеш пароля: %v", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword() проверяет пароль пользователя. Под пользователем
+// без пароля войти нельзя
+func CheckPassword(user database.User, password string) bool {
+	if user.PasswordHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// Причины неудачных попыток входа в журнале
+const (
+	ReasonBadCredentials = "bad_credentials"
+	ReasonRateLimited    = "rate_limited"
+)
+
+// ErrBadCredentials возвращается при неверном логине или пароле
+var ErrBadCredentials = errors.New("неверный логин или пароль")
+
+// dummyHash() возвращает хеш, с которым сверяется пароль неизвестного пользователя
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("нет такого пользователя"), bcrypt.DefaultCost)
+	return string(hash)
+})
+
+// SignIn() проверяет логин и пароль пользователя, входящего с адреса ip, с учётом
+// ограничения неудачных попыток и записывает попытку в журнал. Пустой логин означает
+// администратора DefaultUserID. Возвращает ErrBadCredentials или *ThrottleError.
+// Если у пользователя включён второй фактор, вместе с пользователем возвращается
+// ErrSecondFactorRequired и вход завершает SecondFactor()
+func SignIn(login, password, ip string) (database.User, error) {
+	return signIn(login, password, ip, true)
+}
+
+// signIn() работает как SignIn(). Клиенты CalDAV передают пароль с каждым
+// запросом, поэтому для них logSuccess = false и в журнал попадают только неудачи
+func signIn(login, password, ip string, logSuccess bool) (database.User, error) {
+	attempt := database.SignInAttempt{Login: truncate(login, 64), IP: truncate(ip, 64), Time: time.Now()}
+	if err := limiter.Allow(ip); err != nil {
+		attempt.Reason = ReasonRateLimited
+		logAttempt(attempt)
+		return database.User{}, err
+	}
+
+	var (
+		user database.User
+		err  error
+	)
+	if login == "" {
+		user, err = users.GetUser(database.DefaultUserID)
+	} else {
+		user, err = users.GetUserByLogin(login)
+	}
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		return database.User{}, err
+	}
+
+	// Пароль неизвестного пользователя и пользователя без пароля тоже сверяется
+	// с хешем, чтобы время ответа не выдавало, существует ли логин
+	hash := user.PasswordHash
+	if err != nil || hash == "" {
+		hash = dummyHash()
+	}
+	match := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	if err != nil || user.PasswordHash == "" || !match {
+		limiter.Failure(ip)
+		attempt.Reason = ReasonBadCredentials
+		logAttempt(attempt)
+		return database.User{}, ErrBadCredentials
+	}
+
+	// Счётчик неудач сбрасывается только после проверки второго фактора,
+	// чтобы подбор кода ограничивался так же, как подбор пароля
+	if user.TOTPEnabled {
+		return user, ErrSecondFactorRequired
 	}
 
-	// Проверяем валидность токена и его тип (jwt.MapClaims)
-	if _, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return true, nil
+	limiter.Success(ip)
+	if logSuccess {
+		attempt.Login, attempt.Success = user.Login, true
+		logAttempt(attempt)
+	}
+	return user, nil
+}
+
+// logAttempt() записывает попытку входа в журнал. Ошибка записи не мешает входу
+func logAttempt(attempt database.SignInAttempt) {
+	if err := users.LogSignIn(attempt); err != nil {
+		log.Println("не удалось записать попытку входа: ", err)
+	}
+}
+
+// truncate() обрезает строку до n символов, чтобы она поместилась в столбец журнала
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// BootstrapAdmin() задаёт администратору DefaultUserID пароль TODO_PASSWORD,
+// если пароль ему ещё не задан. Так после обновления администратор входит
+// с прежним паролем, а задачи, добавленные до появления учётных записей, остаются у него.
+// Дальше пароль меняется через /api/user/password, и TODO_PASSWORD его не перезаписывает:
+// если они расходятся, в журнал пишется предупреждение
+func BootstrapAdmin(password string) error {
+	if password == "" {
+		return nil
+	}
+	admin, err := users.GetUser(database.DefaultUserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+	if admin.PasswordHash != "" {
+		if !CheckPassword(admin, password) {
+			log.Println("TODO_PASSWORD не совпадает с паролем администратора и не применяется: " +
+				"пароль меняется запросом /api/user/password")
+		}
+		return nil
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return users.SetPassword(admin.ID, hash)
+}
 
-	// Возвращаем ошибку, если токен недействителен
-	return false, fmt.Errorf("недействительный токен")
+// validateToken(tokenString) проверяет access-токен и возвращает пользователя,
+// которому он выдан.
+func validateToken(tokenString string) (database.User, error) {
+	user, _, err := parseToken(tokenString, tokenAccess)
+	return user, err
 }
 
 // Auth(next) создает middleware для проверки аутентификации перед обработкой запроса.
 // Если токен недействителен или отсутствует, возвращается ошибка 401.
 func Auth(next http.HandlerFunc) http.HandlerFunc {
+	return authenticate(next, false)
+}
+
+// FeedAuth(next) работает как Auth, но принимает токен и из параметра запроса token.
+// Используется для лент, на которые подписываются календари, не умеющие передавать cookie.
+func FeedAuth(next http.HandlerFunc) http.HandlerFunc {
+	return authenticate(next, true)
+}
+
+func authenticate(next http.HandlerFunc, allowQuery bool) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Получаем секретный ключ из переменных окружения
 		pass := os.Getenv("TODO_PASSWORD")
 		if len(pass) > 0 {
-			var jwtString string
-			// Пытаемся получить токен из cookie
-			cookie, err := r.Cookie("token")
-			if err == nil {
-				jwtString = cookie.Value
+			// Токен берётся из заголовка Authorization: Bearer, затем из cookie
+			jwtString := bearerToken(r)
+			session := jwtString == ""
+			if session {
+				if cookie, err := r.Cookie(TokenCookie); err == nil {
+					jwtString = cookie.Value
+				}
+			}
+			if jwtString == "" && allowQuery {
+				jwtString = r.URL.Query().Get("token")
+				session = jwtString == ""
 			}
 
-			// Проверяем валидность токена
-			valid, err := validateToken(jwtString)
-			if err != nil {
-				valid = false
-				fmt.Println("Не валидный токен: ", err)
+			// Проверяем валидность токена. Сессия веб-интерфейса продлевается
+			// по refresh-токену из cookie
+			ctx, err := authenticateToken(r.Context(), jwtString)
+			if err != nil && session {
+				if renewed, renewErr := renewSession(w, r); renewErr == nil {
+					ctx, err = renewed, nil
+				}
 			}
 
 			// Если токен недействителен, возвращаем ошибку аутентификации 401
-			if !valid {
+			if err != nil {
 				// Логирование ошибки аутентификации
 				fmt.Println("Ошибка аутентификации: ", err)
-				http.Error(w, "Аутентификация требуется", http.StatusUnauthorized)
+				apierror.Write(w, apierror.New(http.StatusUnauthorized, "Аутентификация требуется"))
+				return
+			}
+			if !tokenAllows(ctx, r.Method) {
+				apierror.Write(w, apierror.New(http.StatusForbidden, "API-токен не позволяет изменять данные"))
 				return
 			}
+			r = r.WithContext(ctx)
 		}
 		// Если токен валиден, передаем управление следующему обработчику
 		next(w, r)
 	})
 }
+
+// authenticateToken() проверяет API-токен или access-токен JWT и возвращает контекст
+// с пользователем, а для API-токена — и с самим токеном
+func authenticateToken(ctx context.Context, tokenString string) (context.Context, error) {
+	if strings.HasPrefix(tokenString, APITokenPrefix) {
+		user, token, err := validateAPIToken(tokenString)
+		if err != nil {
+			return ctx, err
+		}
+		return context.WithValue(WithUser(ctx, user), apiTokenKey{}, token), nil
+	}
+	user, err := validateToken(tokenString)
+	if err != nil {
+		return ctx, err
+	}
+	return WithUser(ctx, user), nil
+}
+
+// tokenAllows() проверяет, разрешает ли API-токен из контекста запрос методом method.
+// Запросы, аутентифицированные иначе, не ограничиваются
+func tokenAllows(ctx context.Context, method string) bool {
+	token, ok := APITokenFromContext(ctx)
+	return !ok || scopeAllows(token.Scopes, method)
+}
+
+// DAVAuth(next) создает middleware для CalDAV. Клиенты CalDAV не умеют получать JWT,
+// поэтому кроме токена в заголовке Authorization: Bearer или cookie принимается
+// Basic-аутентификация с логином и паролем пользователя. Вместо пароля можно
+// передать API-токен пользователя, а пользователям с двухфакторной аутентификацией
+// это необходимо: код второго фактора в Basic не передать. При ошибке клиенту предлагается Basic-аутентификация.
+func DAVAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pass := os.Getenv("TODO_PASSWORD")
+		if len(pass) > 0 {
+			var (
+				ctx = r.Context()
+				err error
+			)
+			if login, password, ok := r.BasicAuth(); ok && strings.HasPrefix(password, APITokenPrefix) {
+				ctx, err = authenticateToken(ctx, password)
+				if err == nil && UserFromContext(ctx).Login != login {
+					err = fmt.Errorf("%w: токен другого пользователя", ErrInvalidToken)
+				}
+			} else if ok {
+				var user database.User
+				user, err = signIn(login, password, ClientIP(r), false)
+				ctx = WithUser(ctx, user)
+			} else {
+				token := bearerToken(r)
+				if cookie, cookieErr := r.Cookie(TokenCookie); token == "" && cookieErr == nil {
+					token = cookie.Value
+				}
+				ctx, err = authenticateToken(ctx, token)
+			}
+
+			var throttled *ThrottleError
+			if errors.As(err, &throttled) {
+				SetRetryAfter(w, throttled.RetryAfter)
+				apierror.Write(w, apierror.New(http.StatusTooManyRequests, throttled.Error()))
+				return
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="scheduler"`)
+				apierror.Write(w, apierror.New(http.StatusUnauthorized, "Аутентификация требуется"))
+				return
+			}
+			if !tokenAllows(ctx, r.Method) {
+				apierror.Write(w, apierror.New(http.StatusForbidden, "API-токен не позволяет изменять данные"))
+				return
+			}
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPassword = "secret-password"
+
+// useUsers() подменяет хранилище учётных записей хранилищем в памяти
+// и включает аутентификацию.
+func useUsers(t *testing.T) *database.MemoryStore {
+	t.Helper()
+	mem := database.NewMemoryStore()
+	prev := users
+	SetUsers(mem)
+	t.Cleanup(func() { SetUsers(prev) })
+	t.Setenv("TODO_PASSWORD", testPassword)
+	return mem
+}
+
+// signClaims() подписывает ключом key токен с указанными утверждениями.
+func signClaims(t *testing.T, claims jwt.MapClaims, key *signingKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	require.NoError(t, err)
+	return signed
+}
+
+// whoami — обработчик, возвращающий логин пользователя из контекста.
+func whoami(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(UserFromContext(r.Context()).Login))
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	mem := useUsers(t)
+
+	require.NoError(t, BootstrapAdmin(testPassword))
+	admin, err := mem.GetUser(database.DefaultUserID)
+	require.NoError(t, err)
+	assert.True(t, CheckPassword(admin, testPassword))
+
+	// Пароль, сменённый администратором, не перезаписывается при следующем запуске
+	hash, err := HashPassword("changed-password")
+	require.NoError(t, err)
+	require.NoError(t, mem.SetPassword(admin.ID, hash))
+	require.NoError(t, BootstrapAdmin(testPassword))
+	admin, err = mem.GetUser(database.DefaultUserID)
+	require.NoError(t, err)
+	assert.True(t, CheckPassword(admin, "changed-password"))
+	assert.False(t, CheckPassword(admin, testPassword))
+	assert.False(t, CheckPassword(database.User{}, ""), "под пользователем без пароля войти нельзя")
+}
+
+func TestAuthToken(t *testing.T) {
+	mem := useUsers(t)
+	id, err := mem.CreateUser(database.User{Login: "ivan"})
+	require.NoError(t, err)
+	ivan, err := mem.GetUser(id)
+	require.NoError(t, err)
+
+	tokens, err := IssueTokens(ivan)
+	require.NoError(t, err)
+	key, err := activeKey()
+	require.NoError(t, err)
+	forged := &signingKey{kid: key.kid, method: jwt.SigningMethodHS256, private: []byte(testPassword)}
+	valid := jwt.MapClaims{"sub": "2", "typ": "access", "ver": 0, "jti": "x", "exp": time.Now().Add(time.Hour).Unix()}
+	with := func(key string, value any) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		login string
+	}{
+		{"выданный токен", tokens.Token, "ivan"},
+		{"все утверждения", signClaims(t, valid, key), "ivan"},
+		{"refresh-токен", tokens.RefreshToken, ""},
+		{"чужая подпись", signClaims(t, valid, forged), ""},
+		{"истёк", signClaims(t, with("exp", time.Now().Add(-time.Minute).Unix()), key), ""},
+		{"без срока действия", signClaims(t, with("exp", nil), key), ""},
+		{"без идентификатора", signClaims(t, with("jti", nil), key), ""},
+		{"старая версия", signClaims(t, with("ver", -1), key), ""},
+		{"токен без учётной записи", signClaims(t, jwt.MapClaims{"hash": "abc"}, key), ""},
+		{"пустой токен", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+			req.AddCookie(&http.Cookie{Name: "token", Value: tc.token})
+			rec := httptest.NewRecorder()
+			Auth(whoami)(rec, req)
+			if tc.login == "" {
+				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+				return
+			}
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tc.login, rec.Body.String())
+		})
+	}
+
+	// Токен удалённого пользователя недействителен
+	require.NoError(t, mem.DeleteUser(id))
+	_, err = validateToken(tokens.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	mem := useUsers(t)
+	id, err := mem.CreateUser(database.User{Login: "ivan"})
+	require.NoError(t, err)
+	ivan, err := mem.GetUser(id)
+	require.NoError(t, err)
+
+	// Часы подменяются, чтобы проверить истечение access-токена
+	now := time.Now()
+	jwt.TimeFunc = func() time.Time { return now }
+	t.Cleanup(func() { jwt.TimeFunc = time.Now })
+
+	tokens, err := IssueTokens(ivan)
+	require.NoError(t, err)
+	assert.Equal(t, int(DefaultAccessTTL.Seconds()), tokens.ExpiresIn)
+	_, err = validateToken(tokens.Token)
+	require.NoError(t, err)
+
+	now = now.Add(DefaultAccessTTL + time.Second)
+	_, err = validateToken(tokens.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "access-токен истёк")
+
+	// Refresh-токен действует дольше и обменивается на новую пару один раз
+	_, err = Refresh(tokens.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "access-токен не обменивается")
+	refreshed, err := Refresh(tokens.RefreshToken)
+	require.NoError(t, err)
+	_, err = validateToken(refreshed.Token)
+	require.NoError(t, err)
+	_, err = Refresh(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken, "refresh-токен уже использован")
+
+	// Отозванный токен недействителен
+	require.NoError(t, Revoke(refreshed.Token))
+	_, err = validateToken(refreshed.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	require.NoError(t, Revoke("мусор"))
+
+	// Смена пароля отзывает все токены пользователя
+	require.NoError(t, mem.SetPassword(id, "hash"))
+	_, err = Refresh(refreshed.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	t.Setenv("TODO_TOKEN_TTL", "2h")
+	tokens, err = IssueTokens(ivan)
+	require.NoError(t, err)
+	assert.Equal(t, 7200, tokens.ExpiresIn)
+}
+
+func TestSessionRenewal(t *testing.T) {
+	mem := useUsers(t)
+	id, err := mem.CreateUser(database.User{Login: "ivan"})
+	require.NoError(t, err)
+	ivan, err := mem.GetUser(id)
+	require.NoError(t, err)
+
+	now := time.Now()
+	jwt.TimeFunc = func() time.Time { return now }
+	t.Cleanup(func() { jwt.TimeFunc = time.Now })
+	tokens, err := IssueTokens(ivan)
+	require.NoError(t, err)
+	now = now.Add(DefaultAccessTTL + time.Second)
+
+	request := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		Auth(whoami)(rec, req)
+		return rec
+	}
+	expired := &http.Cookie{Name: TokenCookie, Value: tokens.Token}
+	refresh := &http.Cookie{Name: RefreshCookie, Value: tokens.RefreshToken}
+
+	// Истёкший access-токен из cookie заменяется новым по refresh-токену
+	assert.Equal(t, http.StatusUnauthorized, request(expired).Code)
+	rec := request(expired, refresh)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ivan", rec.Body.String())
+	cookies := map[string]string{}
+	for _, c := range rec.Result().Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	_, err = validateToken(cookies[TokenCookie])
+	require.NoError(t, err)
+	assert.NotEqual(t, tokens.RefreshToken, cookies[RefreshCookie])
+
+	// Параллельный запрос с тем же refresh-токеном получает ту же пару,
+	// а без cookie token сессия тоже продлевается
+	rec = request(refresh)
+	require.Equal(t, http.StatusOK, rec.Code)
+	for _, c := range rec.Result().Cookies() {
+		assert.Equal(t, cookies[c.Name], c.Value, c.Name)
+	}
+
+	// Токен из заголовка не продлевается
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	req.AddCookie(&http.Cookie{Name: RefreshCookie, Value: cookies[RefreshCookie]})
+	rec = httptest.NewRecorder()
+	Auth(whoami)(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestDAVAuth(t *testing.T) {
+	mem := useUsers(t)
+	hash, err := HashPassword("ivan-password")
+	require.NoError(t, err)
+	_, err = mem.CreateUser(database.User{Login: "ivan", PasswordHash: hash})
+	require.NoError(t, err)
+	h := DAVAuth(http.HandlerFunc(whoami))
+
+	req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+	req.SetBasicAuth("ivan", "ivan-password")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ivan", rec.Body.String())
+
+	req = httptest.NewRequest("PROPFIND", "/dav/", nil)
+	req.SetBasicAuth("ivan", testPassword)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Basic realm="scheduler"`, rec.Header().Get("WWW-Authenticate"))
+}
+
+func TestAuthDisabled(t *testing.T) {
+	useUsers(t)
+	t.Setenv("TODO_PASSWORD", "")
+
+	rec := httptest.NewRecorder()
+	Auth(whoami)(rec, httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, DefaultUser.Login, rec.Body.String())
+}
@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Алгоритмы подписи токенов. Алгоритм новых ключей задаётся переменной
+// окружения TODO_JWT_ALG, по умолчанию HS256
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+const (
+	// hmacKeySize — длина секрета HS256 в байтах
+	hmacKeySize = 32
+	// rsaKeyBits — длина ключей RS256
+	rsaKeyBits = 2048
+	// keyReloadInterval — как часто ключи перечитываются из хранилища, чтобы
+	// сервер узнавал о ключах, добавленных и выведенных из оборота командой keys
+	keyReloadInterval = time.Minute
+	// keyRetryInterval — не чаще этого ключи перечитываются из-за токена
+	// с неизвестным kid, например подписанного ключом другого экземпляра сервера
+	keyRetryInterval = 5 * time.Second
+)
+
+// ErrNoSigningKey возвращается, если ключи подписи не удалось загрузить
+var ErrNoSigningKey = errors.New("нет ключа подписи токенов")
+
+// signingKey — ключ подписи, готовый к использованию
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// keyring — ключи подписи токенов: active подписывает новые токены,
+// verify проверяют выданные ранее по kid из заголовка токена
+type keyring struct {
+	active   *signingKey
+	verify   map[string]*signingKey
+	loadedAt time.Time
+}
+
+var (
+	keysMu sync.Mutex
+	keys   *keyring
+)
+
+// LoadKeys() загружает ключи подписи токенов. Если ключ не задан в TODO_JWT_KEY_FILE
+// и в хранилище нет действующих ключей, создаётся и сохраняется новый ключ
+// алгоритма TODO_JWT_ALG. Возвращает kid ключа, которым подписываются токены
+func LoadKeys() (string, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	ring, err := loadKeys()
+	if err != nil {
+		return "", err
+	}
+	keys = ring
+	return ring.active.kid, nil
+}
+
+// RotateKey() создаёт новый ключ алгоритма alg (по умолчанию TODO_JWT_ALG), которым
+// подписываются новые токены. Прежние ключи продолжают проверять выданные ими токены,
+// пока их не выведут из оборота RetireKey(). Возвращает kid нового ключа
+func RotateKey(alg string) (string, error) {
+	if alg == "" {
+		alg = signingAlgorithm()
+	}
+	key, err := generateSigningKey(alg)
+	if err != nil {
+		return "", err
+	}
+	if err := users.AddSigningKey(key); err != nil {
+		return "", err
+	}
+	resetKeys()
+	return key.KID, nil
+}
+
+// RetireKey() выводит ключ из оборота: выданные им токены больше не принимаются.
+// Если это был ключ, которым подписываются токены, его место занимает следующий
+// по новизне действующий ключ, а если таких нет — при загрузке создаётся новый
+func RetireKey(kid string) error {
+	if err := users.RetireSigningKey(kid); err != nil {
+		return err
+	}
+	resetKeys()
+	return nil
+}
+
+func resetKeys() {
+	keysMu.Lock()
+	keys = nil
+	keysMu.Unlock()
+}
+
+// currentKeys() возвращает загруженные ключи, перечитывая их раз в keyReloadInterval,
+// а если force — раз в keyRetryInterval. Если перечитать ключи не удалось,
+// используются загруженные ранее
+func currentKeys(force bool) (*keyring, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	if keys != nil {
+		age := time.Since(keys.loadedAt)
+		if age < keyRetryInterval || !force && age < keyReloadInterval {
+			return keys, nil
+		}
+	}
+	ring, err := loadKeys()
+	if err != nil {
+		if keys != nil {
+			log.Println("не удалось перечитать ключи подписи: ", err)
+			keys.loadedAt = time.Now()
+			return keys, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrNoSigningKey, err)
+	}
+	keys = ring
+	return keys, nil
+}
+
+// activeKey() возвращает ключ, которым подписываются новые токены
+func activeKey() (*signingKey, error) {
+	ring, err := currentKeys(false)
+	if err != nil {
+		return nil, err
+	}
+	return ring.active, nil
+}
+
+// verificationKey() возвращает действующий ключ с идентификатором kid
+func verificationKey(kid string) (*signingKey, error) {
+	ring, err := currentKeys(false)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := ring.verify[kid]; ok {
+		return key, nil
+	}
+	if ring, err = currentKeys(true); err != nil {
+		return nil, err
+	}
+	if key, ok := ring.verify[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+}
+
+// loadKeys() читает действующие ключи из хранилища и TODO_JWT_KEY_FILE. Вызывается под keysMu
+func loadKeys() (*keyring, error) {
+	stored, err := users.SigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	ring := &keyring{verify: make(map[string]*signingKey), loadedAt: time.Now()}
+	for _, k := range stored {
+		if !k.RetiredAt.IsZero() {
+			continue
+		}
+		key, err := parseStoredKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("ключ %s: %w", k.KID, err)
+		}
+		ring.verify[key.kid] = key
+		if ring.active == nil {
+			ring.active = key
+		}
+	}
+
+	configured, err := configuredKeys()
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range configured {
+		ring.verify[key.kid] = key
+		if i == 0 {
+			ring.active = key
+		}
+	}
+
+	if ring.active == nil {
+		k, err := generateSigningKey(signingAlgorithm())
+		if err != nil {
+			return nil, err
+		}
+		if err := users.AddSigningKey(k); err != nil {
+			return nil, err
+		}
+		key, err := parseStoredKey(k)
+		if err != nil {
+			return nil, err
+		}
+		ring.verify[key.kid] = key
+		ring.active = key
+	}
+	return ring, nil
+}
+
+// signingAlgorithm() возвращает алгоритм новых ключей подписи
+func signingAlgorithm() string {
+	if alg := os.Getenv("TODO_JWT_ALG"); alg != "" {
+		return alg
+	}
+	return AlgHS256
+}
+
+// configuredKeys() читает ключи из файлов, перечисленных через запятую в TODO_JWT_KEY_FILE.
+// Первый ключ подписывает токены, остальные только проверяют их, что позволяет сменить
+// ключ, не отзывая выданные токены. Файл содержит закрытый ключ Ed25519 или RSA в PEM
+// либо секрет HS256 не короче hmacKeySize байт. kid вычисляется по отпечатку ключа
+func configuredKeys() ([]*signingKey, error) {
+	var keys []*signingKey
+	for _, path := range strings.Split(os.Getenv("TODO_JWT_KEY_FILE"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать ключ подписи: %v", err)
+		}
+
+		var (
+			alg         string
+			private     interface{}
+			fingerprint []byte
+		)
+		if block, _ := pem.Decode(data); block != nil {
+			if alg, private, err = parsePrivateKey(block); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if fingerprint, err = x509.MarshalPKIXPublicKey(publicKey(private)); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		} else {
+			secret := []byte(strings.TrimSpace(string(data)))
+			if len(secret) < hmacKeySize {
+				return nil, fmt.Errorf("%s: секрет HS256 должен быть не короче %d байт", path, hmacKeySize)
+			}
+			alg, private, fingerprint = AlgHS256, secret, secret
+		}
+
+		sum := sha256.Sum256(fingerprint)
+		key, err := newSigningKey("file-"+hex.EncodeToString(sum[:8]), alg, private)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// generateSigningKey() создаёт ключ подписи алгоритма alg со случайным kid
+func generateSigningKey(alg string) (database.SigningKey, error) {
+	kid, err := newTokenID()
+	if err != nil {
+		return database.SigningKey{}, err
+	}
+	key := database.SigningKey{KID: kid, Algorithm: alg, CreatedAt: time.Now()}
+
+	var private interface{}
+	switch alg {
+	case AlgHS256:
+		secret := make([]byte, hmacKeySize)
+		if _, err := rand.Read(secret); err != nil {
+			return key, fmt.Errorf("не удалось создать ключ подписи: %v", err)
+		}
+		key.Data = base64.StdEncoding.EncodeToString(secret)
+		return key, nil
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return key, fmt.Errorf("неподдерживаемый алгоритм подписи %q, допустимы %s, %s и %s", alg, AlgHS256, AlgEdDSA, AlgRS256)
+	}
+	if err != nil {
+		return key, fmt.Errorf("не удалось создать ключ подписи: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return key, fmt.Errorf("не удалось сохранить ключ подписи: %v", err)
+	}
+	key.Data = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return key, nil
+}
+
+// parseStoredKey() разбирает ключ подписи из хранилища
+func parseStoredKey(k database.SigningKey) (*signingKey, error) {
+	if k.Algorithm == AlgHS256 {
+		secret, err := base64.StdEncoding.DecodeString(k.Data)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный секрет HS256: %v", err)
+		}
+		return newSigningKey(k.KID, k.Algorithm, secret)
+	}
+
+	block, _ := pem.Decode([]byte(k.Data))
+	if block == nil {
+		return nil, fmt.Errorf("ключ %s не в формате PEM", k.Algorithm)
+	}
+	alg, private, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	if alg != k.Algorithm {
+		return nil, fmt.Errorf("ключ %s сохранён как %s", alg, k.Algorithm)
+	}
+	return newSigningKey(k.KID, alg, private)
+}
+
+// parsePrivateKey() разбирает закрытый ключ Ed25519 или RSA и определяет его алгоритм
+func parsePrivateKey(block *pem.Block) (string, interface{}, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", nil, fmt.Errorf("некорректный ключ RSA: %v", err)
+		}
+		return AlgRS256, key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("некорректный закрытый ключ: %v", err)
+	}
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		return AlgEdDSA, key, nil
+	case *rsa.PrivateKey:
+		return AlgRS256, key, nil
+	}
+	return "", nil, fmt.Errorf("неподдерживаемый тип ключа %T", key)
+}
+
+// newSigningKey() подбирает к закрытому ключу метод подписи и открытый ключ для проверки
+func newSigningKey(kid, alg string, private interface{}) (*signingKey, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("неподдерживаемый алгоритм подписи %q", alg)
+	}
+	return &signingKey{kid: kid, method: method, private: private, public: publicKey(private)}, nil
+}
+
+// publicKey() возвращает ключ, которым проверяется подпись закрытым ключом private.
+// Для HS256 это тот же секрет
+func publicKey(private interface{}) interface{} {
+	switch k := private.(type) {
+	case ed25519.PrivateKey:
+		return k.Public().(ed25519.PublicKey)
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	}
+	return private
+}
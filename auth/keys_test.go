@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenHeader() возвращает заголовок токена без проверки подписи.
+func tokenHeader(t *testing.T, tokenString string) map[string]interface{} {
+	t.Helper()
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	require.NoError(t, err)
+	return token.Header
+}
+
+// writePEM() сохраняет закрытый ключ в PEM-файл во временном каталоге теста.
+func writePEM(t *testing.T, name string, private interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func TestLoadKeysPersistsGeneratedKey(t *testing.T) {
+	mem := useUsers(t)
+
+	kid, err := LoadKeys()
+	require.NoError(t, err)
+	stored, err := mem.SigningKeys()
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, kid, stored[0].KID)
+	assert.Equal(t, AlgHS256, stored[0].Algorithm)
+
+	// Сохранённый ключ используется повторно, например после перезапуска
+	SetUsers(mem)
+	again, err := LoadKeys()
+	require.NoError(t, err)
+	assert.Equal(t, kid, again)
+
+	tokens, err := IssueTokens(DefaultUser)
+	require.NoError(t, err)
+	assert.Equal(t, kid, tokenHeader(t, tokens.Token)["kid"])
+}
+
+func TestKeyAlgorithms(t *testing.T) {
+	for _, alg := range []string{AlgHS256, AlgEdDSA, AlgRS256} {
+		t.Run(alg, func(t *testing.T) {
+			useUsers(t)
+			t.Setenv("TODO_JWT_ALG", alg)
+
+			tokens, err := IssueTokens(DefaultUser)
+			require.NoError(t, err)
+			assert.Equal(t, alg, tokenHeader(t, tokens.Token)["alg"])
+			_, err = validateToken(tokens.Token)
+			assert.NoError(t, err)
+		})
+	}
+
+	useUsers(t)
+	t.Setenv("TODO_JWT_ALG", "none")
+	_, err := LoadKeys()
+	assert.Error(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	useUsers(t)
+	oldKID, err := LoadKeys()
+	require.NoError(t, err)
+	old, err := IssueTokens(DefaultUser)
+	require.NoError(t, err)
+
+	newKID, err := RotateKey(AlgEdDSA)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKID, newKID)
+	fresh, err := IssueTokens(DefaultUser)
+	require.NoError(t, err)
+	assert.Equal(t, newKID, tokenHeader(t, fresh.Token)["kid"])
+	assert.Equal(t, AlgEdDSA, tokenHeader(t, fresh.Token)["alg"])
+
+	// Токены, подписанные прежним ключом, действуют до вывода ключа из оборота
+	_, err = validateToken(old.Token)
+	require.NoError(t, err)
+	require.NoError(t, RetireKey(oldKID))
+	_, err = validateToken(old.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = validateToken(fresh.Token)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, RetireKey("nobody"), database.ErrKeyNotFound)
+
+	// После вывода из оборота последнего ключа создаётся новый
+	require.NoError(t, RetireKey(newKID))
+	_, err = validateToken(fresh.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	kid, err := LoadKeys()
+	require.NoError(t, err)
+	assert.NotContains(t, []string{oldKID, newKID}, kid)
+}
+
+func TestConfiguredKeys(t *testing.T) {
+	mem := useUsers(t)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edPath := writePEM(t, "ed25519.pem", edKey)
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte(strings.Repeat("s", hmacKeySize)+"\n"), 0o600))
+
+	t.Setenv("TODO_JWT_KEY_FILE", secretPath)
+	SetUsers(mem)
+	secretKID, err := LoadKeys()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secretKID, "file-"))
+	old, err := IssueTokens(DefaultUser)
+	require.NoError(t, err)
+	stored, err := mem.SigningKeys()
+	require.NoError(t, err)
+	assert.Empty(t, stored, "ключи из файлов не сохраняются в БД")
+
+	// Новый ключ подписывает токены, прежний файл только проверяет их
+	t.Setenv("TODO_JWT_KEY_FILE", edPath+", "+secretPath)
+	SetUsers(mem)
+	edKID, err := LoadKeys()
+	require.NoError(t, err)
+	assert.NotEqual(t, secretKID, edKID)
+	fresh, err := IssueTokens(DefaultUser)
+	require.NoError(t, err)
+	assert.Equal(t, AlgEdDSA, tokenHeader(t, fresh.Token)["alg"])
+	_, err = validateToken(old.Token)
+	assert.NoError(t, err)
+
+	t.Setenv("TODO_JWT_KEY_FILE", edPath)
+	SetUsers(mem)
+	_, err = validateToken(old.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = validateToken(fresh.Token)
+	assert.NoError(t, err)
+
+	short := filepath.Join(t.TempDir(), "short")
+	require.NoError(t, os.WriteFile(short, []byte("short"), 0o600))
+	t.Setenv("TODO_JWT_KEY_FILE", short)
+	SetUsers(mem)
+	_, err = LoadKeys()
+	assert.Error(t, err, "короткий секрет HS256 не принимается")
+}
+
+func TestKeyAlgorithmConfusion(t *testing.T) {
+	useUsers(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	require.NoError(t, err)
+	t.Setenv("TODO_JWT_KEY_FILE", writePEM(t, "rsa.pem", rsaKey))
+	kid, err := LoadKeys()
+	require.NoError(t, err)
+
+	// Открытый ключ RSA, использованный как секрет HS256, не подходит
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	forged := &signingKey{kid: kid, method: jwt.SigningMethodHS256, private: public}
+	claims := jwt.MapClaims{"sub": "1", "typ": "access", "ver": 0, "jti": "x", "exp": jwt.TimeFunc().Unix() + 60}
+	_, err = validateToken(signClaims(t, claims, forged))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	key, err := activeKey()
+	require.NoError(t, err)
+	_, err = validateToken(signClaims(t, claims, key))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignInLimits задаёт ограничения неудачных попыток входа.
+//
+// После FreeAttempts неудачных попыток с одного адреса каждая следующая попытка
+// возможна только через задержку, которая начинается с BaseDelay и удваивается
+// до MaxDelay. После LockoutAttempts неудачных попыток адрес блокируется
+// на LockoutDuration. Неудачи забываются, если с адреса не было попыток
+// дольше Window, и сбрасываются успешным входом.
+//
+// GlobalFailures ограничивает число неудачных попыток со всех адресов за
+// GlobalWindow: так перебор с множества адресов тоже замедляется. Адреса,
+// с которых за последний Window был успешный вход, общий счётчик не ограничивает,
+// чтобы перебор не закрыл вход пользователям.
+type SignInLimits struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAttempts int
+	LockoutDuration time.Duration
+	Window          time.Duration
+	GlobalFailures  int
+	GlobalWindow    time.Duration
+}
+
+// DefaultSignInLimits — ограничения попыток входа по умолчанию
+var DefaultSignInLimits = SignInLimits{
+	FreeAttempts:    5,
+	BaseDelay:       time.Second,
+	MaxDelay:        5 * time.Minute,
+	LockoutAttempts: 20,
+	LockoutDuration: 30 * time.Minute,
+	Window:          time.Hour,
+	GlobalFailures:  100,
+	GlobalWindow:    time.Minute,
+}
+
+// ErrTooManyAttempts возвращается, если попытки входа временно ограничены
+var ErrTooManyAttempts = errors.New("слишком много неудачных попыток входа, повторите позже")
+
+// ThrottleError сообщает, через сколько можно повторить попытку входа
+type ThrottleError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%v (через %v)", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// ipFailures — неудачные попытки входа с одного адреса
+type ipFailures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// SignInLimiter учитывает неудачные попытки входа в памяти процесса
+type SignInLimiter struct {
+	mu        sync.Mutex
+	limits    SignInLimits
+	now       func() time.Time
+	ips       map[string]*ipFailures
+	global    []time.Time
+	trusted   map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewSignInLimiter() создаёт счётчик попыток входа с ограничениями limits
+func NewSignInLimiter(limits SignInLimits) *SignInLimiter {
+	return &SignInLimiter{limits: limits, now: time.Now, ips: make(map[string]*ipFailures), trusted: make(map[string]time.Time)}
+}
+
+// limiter — счётчик попыток входа через /api/signin и CalDAV
+var limiter = NewSignInLimiter(DefaultSignInLimits)
+
+// SetSignInLimiter() задаёт счётчик попыток входа
+func SetSignInLimiter(l *SignInLimiter) {
+	limiter = l
+}
+
+// Allow() проверяет, можно ли сейчас попытаться войти с адреса ip.
+// Если нельзя, возвращает *ThrottleError
+func (l *SignInLimiter) Allow(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	wait := time.Duration(0)
+	if f, ok := l.ips[ip]; ok && f.blockedUntil.After(now) {
+		wait = f.blockedUntil.Sub(now)
+	}
+	l.trimGlobal(now)
+	if len(l.global) >= l.limits.GlobalFailures && !l.isTrusted(ip, now) {
+		if w := l.global[0].Add(l.limits.GlobalWindow).Sub(now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return &ThrottleError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Failure() учитывает неудачную попытку входа с адреса ip
+func (l *SignInLimiter) Failure(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	f, ok := l.ips[ip]
+	if !ok || now.Sub(f.last) > l.limits.Window {
+		f = &ipFailures{}
+		l.ips[ip] = f
+	}
+	f.count++
+	f.last = now
+
+	switch {
+	case f.count >= l.limits.LockoutAttempts:
+		f.blockedUntil = now.Add(l.limits.LockoutDuration)
+	case f.count >= l.limits.FreeAttempts:
+		delay := l.limits.BaseDelay
+		for i := l.limits.FreeAttempts; i < f.count && delay < l.limits.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > l.limits.MaxDelay {
+			delay = l.limits.MaxDelay
+		}
+		f.blockedUntil = now.Add(delay)
+	}
+
+	l.trimGlobal(now)
+	l.global = append(l.global, now)
+}
+
+// Success() сбрасывает неудачные попытки адреса ip после успешного входа
+// и на Window освобождает адрес от общего ограничения
+func (l *SignInLimiter) Success(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	delete(l.ips, ip)
+	l.trusted[ip] = now
+}
+
+// isTrusted() проверяет, был ли с адреса ip успешный вход за последний Window.
+// Вызывается под l.mu
+func (l *SignInLimiter) isTrusted(ip string, now time.Time) bool {
+	last, ok := l.trusted[ip]
+	return ok && now.Sub(last) <= l.limits.Window
+}
+
+// trimGlobal() забывает неудачные попытки старше GlobalWindow. Вызывается под l.mu
+func (l *SignInLimiter) trimGlobal(now time.Time) {
+	i := 0
+	for i < len(l.global) && now.Sub(l.global[i]) >= l.limits.GlobalWindow {
+		i++
+	}
+	l.global = l.global[i:]
+}
+
+// sweep() раз в Window удаляет адреса, неудачи и успешные входы которых уже забыты.
+// Вызывается под l.mu
+func (l *SignInLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.limits.Window {
+		return
+	}
+	l.lastSweep = now
+	for ip, f := range l.ips {
+		if now.Sub(f.last) > l.limits.Window && !f.blockedUntil.After(now) {
+			delete(l.ips, ip)
+		}
+	}
+	for ip, last := range l.trusted {
+		if now.Sub(last) > l.limits.Window {
+			delete(l.trusted, ip)
+		}
+	}
+}
+
+// ClientIP() возвращает адрес клиента, по которому ограничиваются попытки входа.
+// За обратным прокси (TODO_TRUST_PROXY=true) берётся последний адрес из
+// X-Forwarded-For — его добавил прокси, остальные мог подставить сам клиент
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TODO_TRUST_PROXY") == "true" {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			parts := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SetRetryAfter() сообщает клиенту в заголовке Retry-After, через сколько секунд
+// повторить запрос
+func SetRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"final_project/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useLimiter() подменяет счётчик попыток входа счётчиком с часами, которые
+// переводит тест через возвращаемый указатель.
+func useLimiter(t *testing.T, limits SignInLimits) (*SignInLimiter, *time.Time) {
+	t.Helper()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewSignInLimiter(limits)
+	l.now = func() time.Time { return now }
+	prev := limiter
+	SetSignInLimiter(l)
+	t.Cleanup(func() { SetSignInLimiter(prev) })
+	return l, &now
+}
+
+// retryAfter() возвращает задержку из ошибки Allow() или 0, если попытка разрешена.
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var throttled *ThrottleError
+	require.True(t, errors.As(err, &throttled), err)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	return throttled.RetryAfter
+}
+
+func TestSignInLimiterBackoff(t *testing.T) {
+	l, now := useLimiter(t, DefaultSignInLimits)
+	const ip = "192.0.2.1"
+
+	for i := 1; i < DefaultSignInLimits.FreeAttempts; i++ {
+		require.NoError(t, l.Allow(ip), "попытка %d", i)
+		l.Failure(ip)
+	}
+	require.NoError(t, l.Allow(ip))
+
+	// Задержка удваивается с каждой неудачей сверх бесплатных
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		l.Failure(ip)
+		assert.Equal(t, delay, retryAfter(t, l.Allow(ip)))
+		assert.NoError(t, l.Allow("198.51.100.1"), "другие адреса не ограничиваются")
+		*now = now.Add(delay)
+		require.NoError(t, l.Allow(ip))
+	}
+
+	// Задержка не превышает MaxDelay, а после LockoutAttempts адрес блокируется
+	for i := DefaultSignInLimits.FreeAttempts + 4; i < DefaultSignInLimits.LockoutAttempts; i++ {
+		l.Failure(ip)
+		assert.LessOrEqual(t, retryAfter(t, l.Allow(ip)), DefaultSignInLimits.MaxDelay)
+		*now = now.Add(DefaultSignInLimits.MaxDelay)
+	}
+	l.Failure(ip)
+	assert.Equal(t, DefaultSignInLimits.LockoutDuration, retryAfter(t, l.Allow(ip)))
+	*now = now.Add(DefaultSignInLimits.LockoutDuration)
+	require.NoError(t, l.Allow(ip))
+
+	// Успешный вход сбрасывает счётчик
+	l.Success(ip)
+	l.Failure(ip)
+	assert.NoError(t, l.Allow(ip))
+}
+
+func TestSignInLimiterWindow(t *testing.T) {
+	l, now := useLimiter(t, DefaultSignInLimits)
+	const ip = "192.0.2.1"
+
+	for i := 1; i < DefaultSignInLimits.FreeAttempts; i++ {
+		l.Failure(ip)
+	}
+	// Неудачи забываются, если попыток не было дольше Window
+	*now = now.Add(DefaultSignInLimits.Window + time.Second)
+	l.Failure(ip)
+	assert.NoError(t, l.Allow(ip))
+	assert.Len(t, l.ips, 1)
+}
+
+func TestSignInLimiterGlobal(t *testing.T) {
+	limits := DefaultSignInLimits
+	limits.GlobalFailures = 3
+	l, now := useLimiter(t, limits)
+
+	// Перебор с разных адресов ограничивается общим счётчиком
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		require.NoError(t, l.Allow(ip))
+		l.Failure(ip)
+	}
+	assert.Equal(t, limits.GlobalWindow, retryAfter(t, l.Allow("192.0.2.4")))
+	*now = now.Add(limits.GlobalWindow)
+	assert.NoError(t, l.Allow("192.0.2.4"))
+
+	// Адрес с недавним успешным входом общий счётчик не ограничивает
+	const known = "198.51.100.1"
+	l.Success(known)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		l.Failure(ip)
+	}
+	assert.Error(t, l.Allow("192.0.2.4"))
+	assert.NoError(t, l.Allow(known))
+	*now = now.Add(limits.Window + time.Second)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		l.Failure(ip)
+	}
+	assert.Error(t, l.Allow(known), "успешный вход забывается через Window")
+}
+
+func TestSignIn(t *testing.T) {
+	mem := useUsers(t)
+	_, now := useLimiter(t, DefaultSignInLimits)
+	hash, err := HashPassword("ivan-password")
+	require.NoError(t, err)
+	_, err = mem.CreateUser(database.User{Login: "ivan", PasswordHash: hash})
+	require.NoError(t, err)
+	const ip = "192.0.2.1"
+
+	user, err := SignIn("ivan", "ivan-password", ip)
+	require.NoError(t, err)
+	assert.Equal(t, "ivan", user.Login)
+	_, err = SignIn("", "", ip)
+	assert.ErrorIs(t, err, ErrBadCredentials, "у администратора нет пароля")
+	_, err = SignIn("nobody", "ivan-password", ip)
+	assert.ErrorIs(t, err, ErrBadCredentials)
+
+	// Неудачи считаются по адресу независимо от логина и ограничивают
+	// попытки даже с верным паролем. Две неудачи уже были
+	for i := 2; i < DefaultSignInLimits.FreeAttempts; i++ {
+		_, err = SignIn("ivan", "wrong", ip)
+		assert.ErrorIs(t, err, ErrBadCredentials)
+	}
+	_, err = SignIn("ivan", "ivan-password", ip)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	*now = now.Add(time.Second)
+	_, err = SignIn("ivan", "ivan-password", ip)
+	require.NoError(t, err)
+
+	attempts, err := mem.ListSignIns(100)
+	require.NoError(t, err)
+	require.Len(t, attempts, 8)
+	assert.True(t, attempts[0].Success)
+	assert.Equal(t, ReasonRateLimited, attempts[1].Reason)
+	assert.Equal(t, ReasonBadCredentials, attempts[2].Reason)
+	assert.Equal(t, "nobody", attempts[5].Login)
+	assert.Equal(t, ip, attempts[5].IP)
+	assert.Equal(t, "", attempts[6].Login)
+	assert.Equal(t, "ivan", attempts[7].Login)
+	assert.True(t, attempts[7].Success)
+	assert.Empty(t, attempts[7].Reason)
+}
+
+func TestDAVAuthThrottle(t *testing.T) {
+	mem := useUsers(t)
+	useLimiter(t, DefaultSignInLimits)
+	hash, err := HashPassword("ivan-password")
+	require.NoError(t, err)
+	_, err = mem.CreateUser(database.User{Login: "ivan", PasswordHash: hash})
+	require.NoError(t, err)
+	h := DAVAuth(http.HandlerFunc(whoami))
+
+	propfind := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+		req.SetBasicAuth("ivan", password)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, propfind("ivan-password").Code)
+	for i := 0; i < DefaultSignInLimits.FreeAttempts; i++ {
+		require.Equal(t, http.StatusUnauthorized, propfind("wrong").Code)
+	}
+	rec := propfind("ivan-password")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// Успешные запросы CalDAV в журнал не записываются
+	attempts, err := mem.ListSignIns(100)
+	require.NoError(t, err)
+	assert.Len(t, attempts, DefaultSignInLimits.FreeAttempts+1)
+	for _, a := range attempts {
+		assert.False(t, a.Success)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/signin", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+	req.Header.Add("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	assert.Equal(t, "192.0.2.1", ClientIP(req), "без доверенного прокси заголовок игнорируется")
+
+	t.Setenv("TODO_TRUST_PROXY", "true")
+	assert.Equal(t, "198.51.100.7", ClientIP(req))
+}
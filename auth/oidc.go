@@ -0,0 +1,517 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// oidcLoginTTL — время на вход у поставщика OpenID Connect
+	oidcLoginTTL = 10 * time.Minute
+	// oidcTimeout — ограничение времени запросов к поставщику
+	oidcTimeout = 10 * time.Second
+)
+
+// ReasonOIDCFailed — причина неудачной попытки входа через OpenID Connect в журнале
+const ReasonOIDCFailed = "oidc_failed"
+
+var (
+	// ErrOIDCDisabled возвращается, если вход через OpenID Connect не настроен
+	ErrOIDCDisabled = errors.New("вход через OpenID Connect не настроен")
+	// ErrOIDCFailed возвращается, если поставщик отказал во входе или его ответ
+	// не прошёл проверку
+	ErrOIDCFailed = errors.New("не удалось войти через OpenID Connect")
+)
+
+// OIDCConfig — параметры клиента OpenID Connect, зарегистрированного у поставщика
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// OIDCConfigFromEnv() читает параметры клиента из переменных окружения
+// TODO_OIDC_ISSUER, TODO_OIDC_CLIENT_ID, TODO_OIDC_CLIENT_SECRET и TODO_OIDC_REDIRECT_URL
+func OIDCConfigFromEnv() OIDCConfig {
+	return OIDCConfig{
+		Issuer:       strings.TrimSuffix(os.Getenv("TODO_OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("TODO_OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("TODO_OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("TODO_OIDC_REDIRECT_URL"),
+	}
+}
+
+// oidcMetadata — нужная часть документа обнаружения поставщика
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin — начатый вход, ожидающий возвращения пользователя от поставщика
+type oidcLogin struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+// OIDCProvider — поставщик OpenID Connect. Вход выполняется по коду авторизации
+// с PKCE, адреса поставщика и ключи подписи ID-токенов берутся из документа
+// обнаружения при первом входе
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	meta     *oidcMetadata
+	keys     map[string]interface{}
+	keysTime time.Time
+	pending  map[string]oidcLogin
+}
+
+// NewOIDCProvider() создаёт поставщика с параметрами cfg
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: oidcTimeout},
+		pending: make(map[string]oidcLogin),
+	}
+}
+
+// oidc — поставщик, через которого входят пользователи, или nil
+var oidc *OIDCProvider
+
+// SetOIDC() задаёт поставщика OpenID Connect, nil отключает вход через него
+func SetOIDC(p *OIDCProvider) {
+	oidc = p
+}
+
+// OIDC() возвращает поставщика OpenID Connect или nil, если вход через него не настроен
+func OIDC() *OIDCProvider {
+	return oidc
+}
+
+// AuthURL() начинает вход и возвращает адрес страницы входа поставщика
+// вместе с параметром state, который нужно связать с браузером пользователя
+func (p *OIDCProvider) AuthURL(ctx context.Context) (authURL, state string, err error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	login := oidcLogin{expires: jwt.TimeFunc().Add(oidcLoginTTL)}
+	for _, v := range []*string{&state, &login.verifier, &login.nonce} {
+		if *v, err = randomString(32); err != nil {
+			return "", "", err
+		}
+	}
+	challenge := sha256.Sum256([]byte(login.verifier))
+
+	p.mu.Lock()
+	for s, l := range p.pending {
+		if jwt.TimeFunc().After(l.expires) {
+			delete(p.pending, s)
+		}
+	}
+	p.pending[state] = login
+	p.mu.Unlock()
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", "openid profile email")
+	q.Set("state", state)
+	q.Set("nonce", login.nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), state, nil
+}
+
+// Exchange() завершает вход: обменивает код авторизации на токены и возвращает
+// утверждения проверенного ID-токена. Каждый state принимается один раз
+func (p *OIDCProvider) Exchange(ctx context.Context, code, state string) (jwt.MapClaims, error) {
+	p.mu.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || jwt.TimeFunc().After(login.expires) {
+		return nil, fmt.Errorf("%w: вход не начат или время входа истекло", ErrOIDCFailed)
+	}
+
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", login.verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var resp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.fetchJSON(req, &resp); err != nil && resp.Error == "" {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrOIDCFailed, resp.Error, resp.ErrorDescription)
+	}
+	return p.verifyIDToken(ctx, resp.IDToken, login.nonce)
+}
+
+// verifyIDToken() проверяет подпись ID-токена ключом поставщика, издателя,
+// получателя, срок действия и nonce начатого входа
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("неподдерживаемый алгоритм подписи %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: ID-токен: %v", ErrOIDCFailed, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("%w: недействительный ID-токен", ErrOIDCFailed)
+	}
+
+	switch {
+	case !claims.VerifyIssuer(p.cfg.Issuer, true):
+		return nil, fmt.Errorf("%w: ID-токен выдан другим поставщиком", ErrOIDCFailed)
+	case !claims.VerifyAudience(p.cfg.ClientID, true):
+		return nil, fmt.Errorf("%w: ID-токен выдан другому клиенту", ErrOIDCFailed)
+	case !claims.VerifyExpiresAt(jwt.TimeFunc().Unix(), true):
+		return nil, fmt.Errorf("%w: в ID-токене не указан срок действия", ErrOIDCFailed)
+	}
+	// Если получателей несколько, azp должен указывать на этот клиент
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: ID-токен выдан другому клиенту", ErrOIDCFailed)
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("%w: nonce ID-токена не совпадает", ErrOIDCFailed)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("%w: в ID-токене не указан субъект", ErrOIDCFailed)
+	}
+	return claims, nil
+}
+
+// metadata() возвращает документ обнаружения поставщика, загружая его при первом вызове
+func (p *OIDCProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	meta := p.meta
+	p.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	meta = &oidcMetadata{}
+	if err := p.fetchJSON(req, meta); err != nil {
+		return nil, err
+	}
+	// Документ обнаружения должен принадлежать настроенному поставщику (OpenID Connect Discovery, 4.3)
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: документ обнаружения выдан поставщиком %q", ErrOIDCFailed, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: в документе обнаружения нет нужных адресов", ErrOIDCFailed)
+	}
+
+	p.mu.Lock()
+	p.meta = meta
+	p.mu.Unlock()
+	return meta, nil
+}
+
+// key() возвращает открытый ключ поставщика с идентификатором kid. Ключи
+// перечитываются, если kid неизвестен, но не чаще keyRetryInterval
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	keys, loaded := p.keys, p.keysTime
+	p.mu.Unlock()
+
+	if k, ok := lookupKey(keys, kid); ok {
+		return k, nil
+	}
+	if !loaded.IsZero() && time.Since(loaded) < keyRetryInterval {
+		return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+	}
+
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err = p.fetchKeys(ctx, meta.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.keys, p.keysTime = keys, time.Now()
+	p.mu.Unlock()
+
+	if k, ok := lookupKey(keys, kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("неизвестный ключ подписи %q", kid)
+}
+
+// lookupKey() находит ключ по kid. Токен без kid подходит, только если ключ один
+func lookupKey(keys map[string]interface{}, kid string) (interface{}, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k, true
+		}
+	}
+	k, ok := keys[kid]
+	return k, ok
+}
+
+// jsonWebKey — открытый ключ RSA или EC из набора JWKS (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys() загружает ключи подписи поставщика. Ключи шифрования
+// и ключи неподдерживаемых типов пропускаются
+func (p *OIDCProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.fetchJSON(req, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("некорректная экспонента RSA")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("неподдерживаемая кривая %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("точка не лежит на кривой")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип ключа %s", k.Kty)
+}
+
+// fetchJSON() выполняет запрос к поставщику и разбирает ответ в v. Ответ
+// с ошибкой тоже разбирается, чтобы вызывающий получил её описание
+func (p *OIDCProvider) fetchJSON(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	jsonErr := json.Unmarshal(body, v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s ответил %s", ErrOIDCFailed, req.URL.Host, resp.Status)
+	}
+	if jsonErr != nil {
+		return fmt.Errorf("%w: некорректный ответ %s: %v", ErrOIDCFailed, req.URL.Host, jsonErr)
+	}
+	return nil
+}
+
+// randomString() возвращает случайную строку из n байт в кодировке base64url
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("не удалось получить случайные данные: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// OIDCSignIn() завершает вход через поставщика OpenID Connect с адреса ip
+// и записывает попытку в журнал. Субъект ID-токена связывается с пользователем
+// при первом входе: для него создаётся пользователь без пароля с логином
+// из preferred_username или email. Как и SignIn(), для пользователя
+// со вторым фактором возвращает ErrSecondFactorRequired
+func OIDCSignIn(ctx context.Context, code, state, ip string) (database.User, error) {
+	if oidc == nil {
+		return database.User{}, ErrOIDCDisabled
+	}
+	attempt := database.SignInAttempt{IP: truncate(ip, 64), Time: time.Now()}
+	claims, err := oidc.Exchange(ctx, code, state)
+	if err != nil {
+		attempt.Reason = ReasonOIDCFailed
+		logAttempt(attempt)
+		return database.User{}, err
+	}
+	user, err := oidcUser(oidc.cfg.Issuer, claims)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.TOTPEnabled {
+		return user, ErrSecondFactorRequired
+	}
+
+	attempt.Login, attempt.Success = user.Login, true
+	logAttempt(attempt)
+	return user, nil
+}
+
+// oidcUser() возвращает пользователя, связанного с субъектом ID-токена,
+// и создаёт его при первом входе
+func oidcUser(issuer string, claims jwt.MapClaims) (database.User, error) {
+	sub := claims["sub"].(string)
+	user, err := users.GetUserByIdentity(issuer, sub)
+	if !errors.Is(err, database.ErrUserNotFound) {
+		return user, err
+	}
+
+	login := oidcLoginName(claims)
+	var id int64
+	for i := 1; ; i++ {
+		candidate := login
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", login, i)
+		}
+		id, err = users.CreateUser(database.User{Login: candidate})
+		if !errors.Is(err, database.ErrUserExists) || i == 100 {
+			break
+		}
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	err = users.LinkIdentity(issuer, sub, id)
+	if errors.Is(err, database.ErrIdentityExists) {
+		// Пользователя уже создал одновременный вход того же субъекта
+		if err := users.DeleteUser(id); err != nil {
+			return database.User{}, err
+		}
+		return users.GetUserByIdentity(issuer, sub)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	return users.GetUser(id)
+}
+
+// oidcLoginName() составляет логин нового пользователя из preferred_username
+// или email, оставляя символы, допустимые в логине
+func oidcLoginName(claims jwt.MapClaims) string {
+	for _, claim := range []string{"preferred_username", "email"} {
+		v, _ := claims[claim].(string)
+		login := strings.Map(func(r rune) rune {
+			if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._@-", r)) {
+				return r
+			}
+			return -1
+		}, v)
+		if len(login) > 60 {
+			login = login[:60]
+		}
+		if len(login) >= 3 {
+			return login
+		}
+	}
+	return "oidc-user"
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"final_project/auth/oidctest"
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useOIDC() запускает поставщика OpenID Connect и настраивает вход через него.
+func useOIDC(t *testing.T) *oidctest.Provider {
+	t.Helper()
+	idp := oidctest.NewProvider("scheduler", "client-secret")
+	t.Cleanup(idp.Close)
+	SetOIDC(NewOIDCProvider(OIDCConfig{
+		Issuer:       idp.Issuer(),
+		ClientID:     "scheduler",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://scheduler.example/api/oidc/callback",
+	}))
+	t.Cleanup(func() { SetOIDC(nil) })
+	return idp
+}
+
+// oidcCallback() проходит вход у поставщика и возвращает код авторизации и state.
+func oidcCallback(t *testing.T, idp *oidctest.Provider) (code, state string) {
+	t.Helper()
+	authURL, state, err := OIDC().AuthURL(context.Background())
+	require.NoError(t, err)
+	callback, err := idp.Login(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "scheduler.example", callback.Host)
+	assert.Equal(t, state, callback.Query().Get("state"))
+	return callback.Query().Get("code"), state
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	mem := useUsers(t)
+	idp := useOIDC(t)
+
+	code, state := oidcCallback(t, idp)
+	user, err := OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "ivan", user.Login)
+	assert.Empty(t, user.PasswordHash, "пользователь без пароля входит только через поставщика")
+
+	// Повторный вход того же субъекта попадает к тому же пользователю
+	code, state = oidcCallback(t, idp)
+	again, err := OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, again.ID)
+
+	// Другой субъект с занятым логином получает новый логин
+	idp.Claims = jwt.MapClaims{"sub": "user-2", "preferred_username": "ivan"}
+	code, state = oidcCallback(t, idp)
+	other, err := OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "ivan-2", other.Login)
+
+	linked, err := mem.GetUserByIdentity(idp.Issuer(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, linked.ID)
+	attempts, err := mem.ListSignIns(10)
+	require.NoError(t, err)
+	require.Len(t, attempts, 3)
+	assert.True(t, attempts[0].Success)
+	assert.Equal(t, "ivan-2", attempts[0].Login)
+}
+
+func TestOIDCSignInRejects(t *testing.T) {
+	mem := useUsers(t)
+	idp := useOIDC(t)
+
+	for name, mutate := range map[string]func(jwt.MapClaims){
+		"чужой издатель":     func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+		"чужой клиент":       func(c jwt.MapClaims) { c["aud"] = "other" },
+		"чужой azp":          func(c jwt.MapClaims) { c["aud"] = []string{"scheduler", "other"}; c["azp"] = "other" },
+		"истёкший токен":     func(c jwt.MapClaims) { c["exp"] = jwt.TimeFunc().Add(-time.Minute).Unix() },
+		"без срока действия": func(c jwt.MapClaims) { delete(c, "exp") },
+		"другой nonce":       func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+		"без субъекта":       func(c jwt.MapClaims) { delete(c, "sub") },
+	} {
+		idp.Mutate = mutate
+		code, state := oidcCallback(t, idp)
+		_, err := OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+		assert.ErrorIs(t, err, ErrOIDCFailed, name)
+	}
+	idp.Mutate = nil
+
+	// Код, выданный для другого входа, не проходит проверку PKCE
+	code, _ := oidcCallback(t, idp)
+	_, state := oidcCallback(t, idp)
+	_, err := OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	assert.ErrorIs(t, err, ErrOIDCFailed)
+
+	_, err = mem.GetUserByLogin("ivan")
+	assert.ErrorIs(t, err, database.ErrUserNotFound, "при неудачных входах пользователи не создаются")
+	attempts, err := mem.ListSignIns(100)
+	require.NoError(t, err)
+	require.Len(t, attempts, 8)
+	for _, a := range attempts {
+		assert.Equal(t, ReasonOIDCFailed, a.Reason)
+	}
+
+	// state должен быть выдан этим сервером и принимается один раз
+	code, state = oidcCallback(t, idp)
+	_, err = OIDCSignIn(context.Background(), code, "forged", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrOIDCFailed)
+	_, err = OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	require.NoError(t, err)
+	_, err = OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	assert.ErrorIs(t, err, ErrOIDCFailed)
+
+	SetOIDC(nil)
+	_, err = OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	assert.ErrorIs(t, err, ErrOIDCDisabled)
+}
+
+func TestOIDCSecondFactor(t *testing.T) {
+	mem := useUsers(t)
+	idp := useOIDC(t)
+	now := useClock(t, time.Now())
+	ivan, _ := enrolTOTP(t, mem, "ivan", now)
+	require.NoError(t, mem.LinkIdentity(idp.Issuer(), "user-1", ivan.ID))
+
+	code, state := oidcCallback(t, idp)
+	user, err := OIDCSignIn(context.Background(), code, state, "10.0.0.1")
+	assert.ErrorIs(t, err, ErrSecondFactorRequired)
+	assert.Equal(t, ivan.ID, user.ID)
+}
+
+func TestOIDCLoginName(t *testing.T) {
+	for _, tc := range []struct {
+		claims jwt.MapClaims
+		login  string
+	}{
+		{jwt.MapClaims{"preferred_username": "ivan.petrov"}, "ivan.petrov"},
+		{jwt.MapClaims{"preferred_username": "иван", "email": "ivan@example.com"}, "ivan@example.com"},
+		{jwt.MapClaims{"preferred_username": "Ivan Petrov!"}, "IvanPetrov"},
+		{jwt.MapClaims{}, "oidc-user"},
+	} {
+		assert.Equal(t, tc.login, oidcLoginName(tc.claims))
+	}
+}
@@ -0,0 +1,235 @@
+// Package oidctest содержит поставщика OpenID Connect для тестов входа через него.
+// Поставщик работает в том же процессе, выдаёт код авторизации без страницы входа
+// и проверяет PKCE при обмене кода на токены.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeyID — kid ключа, которым поставщик подписывает ID-токены
+const KeyID = "test-key"
+
+// Provider — поставщик OpenID Connect. Claims задают утверждения пользователя,
+// который входит следующим, Mutate позволяет испортить ID-токен перед подписью
+type Provider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu     sync.Mutex
+	Claims jwt.MapClaims
+	Mutate func(claims jwt.MapClaims)
+	key    *rsa.PrivateKey
+	codes  map[string]grant
+}
+
+// grant — выданный код авторизации
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      jwt.MapClaims
+}
+
+// NewProvider() запускает поставщика с зарегистрированным клиентом clientID.
+// Поставщика нужно остановить методом Close()
+func NewProvider(clientID, clientSecret string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Claims:       jwt.MapClaims{"sub": "user-1", "preferred_username": "ivan"},
+		key:          key,
+		codes:        make(map[string]grant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer() возвращает идентификатор поставщика
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// Login() проходит вход у поставщика по адресу authURL, выданному клиентом,
+// и возвращает адрес, на который поставщик вернул пользователя
+func (p *Provider) Login(authURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("поставщик ответил %s", resp.Status)
+	}
+	return resp.Location()
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": KeyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+// authorize() сразу возвращает пользователя клиенту с кодом авторизации,
+// как если бы он вошёл у поставщика
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "некорректный запрос авторизации", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+
+	p.mu.Lock()
+	claims := jwt.MapClaims{}
+	for k, v := range p.Claims {
+		claims[k] = v
+	}
+	p.codes[code] = grant{
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		claims:      claims,
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token() обменивает код авторизации на ID-токен. Код одноразовый,
+// code_verifier должен соответствовать code_challenge запроса авторизации
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := p.checkTokenRequest(r); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": err.Error()})
+		return
+	}
+
+	p.mu.Lock()
+	g := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	mutate := p.Mutate
+	p.mu.Unlock()
+
+	now := jwt.TimeFunc()
+	claims := jwt.MapClaims{
+		"iss":   p.URL,
+		"aud":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": g.nonce,
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	if mutate != nil {
+		mutate(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = KeyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) checkTokenRequest(r *http.Request) error {
+	if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "authorization_code" {
+		return errors.New("ожидается grant_type=authorization_code")
+	}
+	if p.ClientSecret != "" {
+		id, secret, _ := r.BasicAuth()
+		if id != p.ClientID || secret != p.ClientSecret {
+			return errors.New("неверные учётные данные клиента")
+		}
+	}
+
+	p.mu.Lock()
+	g, ok := p.codes[r.PostFormValue("code")]
+	p.mu.Unlock()
+	if !ok {
+		return errors.New("неизвестный или использованный код")
+	}
+	if r.PostFormValue("redirect_uri") != g.redirectURI {
+		return errors.New("redirect_uri не совпадает")
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		return errors.New("code_verifier не соответствует code_challenge")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+
+	"final_project/apierror"
+	"final_project/database"
+)
+
+// Роли, от которых зависит доступ к маршрутам. Администратор (User.Admin) имеет
+// права редактора и, кроме того, управляет учётными записями
+const (
+	RoleViewer = database.RoleViewer
+	RoleEditor = database.RoleEditor
+	RoleAdmin  = "admin"
+)
+
+// readMethods — методы HTTP и WebDAV, которые не изменяют данные
+var readMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "REPORT"}
+
+// Permissions задаёт минимальную роль для методов маршрута. Методам,
+// не указанным в Methods, нужна роль Default
+type Permissions struct {
+	Methods map[string]string
+	Default string
+}
+
+var (
+	// ViewerAccess — маршрут доступен всем пользователям
+	ViewerAccess = Permissions{Default: RoleViewer}
+	// ReadAccess — читать могут все пользователи, изменять — редакторы
+	ReadAccess = Permissions{Methods: methodRoles(readMethods, RoleViewer), Default: RoleEditor}
+	// EditorAccess — маршрут доступен редакторам
+	EditorAccess = Permissions{Default: RoleEditor}
+	// AdminAccess — маршрут доступен администраторам
+	AdminAccess = Permissions{Default: RoleAdmin}
+)
+
+func methodRoles(methods []string, role string) map[string]string {
+	roles := make(map[string]string, len(methods))
+	for _, m := range methods {
+		roles[m] = role
+	}
+	return roles
+}
+
+// UserRole() возвращает роль пользователя с учётом прав администратора
+func UserRole(user database.User) string {
+	if user.Admin {
+		return RoleAdmin
+	}
+	if user.Role == "" {
+		return RoleEditor
+	}
+	return user.Role
+}
+
+// roleRank() возвращает уровень прав роли, неизвестная роль прав не даёт
+func roleRank(role string) int {
+	return slices.Index([]string{RoleViewer, RoleEditor, RoleAdmin}, role) + 1
+}
+
+// Allows() проверяет, может ли пользователь выполнить запрос методом method
+func (p Permissions) Allows(user database.User, method string) bool {
+	required, ok := p.Methods[method]
+	if !ok {
+		required = p.Default
+	}
+	return roleRank(UserRole(user)) >= roleRank(required)
+}
+
+// Require(p, next) создает middleware, которое пропускает к обработчику только
+// запросы пользователей с достаточной ролью, остальным возвращается ошибка 403.
+// Используется после Auth, FeedAuth или DAVAuth, которые определяют пользователя
+func Require(p Permissions, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.Allows(UserFromContext(r.Context()), r.Method) {
+			apierror.Write(w, apierror.New(http.StatusForbidden, "недостаточно прав"))
+			return
+		}
+		next(w, r)
+	}
+}
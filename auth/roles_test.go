@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissions(t *testing.T) {
+	viewer := database.User{Login: "viewer", Role: RoleViewer}
+	editor := database.User{Login: "editor", Role: RoleEditor}
+	admin := database.User{Login: "admin", Role: RoleViewer, Admin: true}
+	unknown := database.User{Login: "unknown", Role: "guest"}
+
+	for _, tc := range []struct {
+		p                            Permissions
+		method                       string
+		viewer, editor, admin, guest bool
+	}{
+		{ReadAccess, http.MethodGet, true, true, true, false},
+		{ReadAccess, "PROPFIND", true, true, true, false},
+		{ReadAccess, http.MethodPost, false, true, true, false},
+		{ReadAccess, http.MethodDelete, false, true, true, false},
+		{EditorAccess, http.MethodGet, false, true, true, false},
+		{ViewerAccess, http.MethodPost, true, true, true, false},
+		{AdminAccess, http.MethodGet, false, false, true, false},
+	} {
+		assert.Equal(t, tc.viewer, tc.p.Allows(viewer, tc.method), "viewer %s", tc.method)
+		assert.Equal(t, tc.editor, tc.p.Allows(editor, tc.method), "editor %s", tc.method)
+		assert.Equal(t, tc.admin, tc.p.Allows(admin, tc.method), "admin %s", tc.method)
+		assert.Equal(t, tc.guest, tc.p.Allows(unknown, tc.method), "guest %s", tc.method)
+	}
+	assert.Equal(t, RoleEditor, UserRole(database.User{}), "роль по умолчанию — редактор")
+}
+
+func TestRequire(t *testing.T) {
+	h := Require(ReadAccess, whoami)
+	for _, tc := range []struct {
+		method string
+		code   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPost, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, "/api/task", nil)
+		req = req.WithContext(WithUser(req.Context(), database.User{Login: "viewer", Role: RoleViewer}))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		assert.Equal(t, tc.code, rec.Code, tc.method)
+	}
+}
+
+func TestRoleClaim(t *testing.T) {
+	mem := useUsers(t)
+	id, err := mem.CreateUser(database.User{Login: "ivan", Role: RoleViewer})
+	require.NoError(t, err)
+	ivan, err := mem.GetUser(id)
+	require.NoError(t, err)
+
+	tokens, err := IssueTokens(ivan)
+	require.NoError(t, err)
+	claims := jwt.MapClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(tokens.Token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, RoleViewer, claims["role"])
+
+	// Смена роли отзывает токены с прежней ролью
+	require.NoError(t, mem.SetRole(id, RoleEditor, false))
+	_, err = validateToken(tokens.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
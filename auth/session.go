@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Cookie сессии веб-интерфейса. Access-токен в cookie token читают скрипты,
+// refresh-токен скриптам недоступен и передаётся только обработчикам API
+const (
+	TokenCookie   = "token"
+	RefreshCookie = "refresh_token"
+)
+
+// renewGrace — сколько обменянный refresh-токен продолжает выдавать ту же пару
+// токенов. Браузер может отправить несколько запросов с одним истёкшим access-токеном,
+// а refresh-токен действует один раз
+const renewGrace = 30 * time.Second
+
+type renewal struct {
+	tokens  TokenPair
+	expires time.Time
+}
+
+var renewals = struct {
+	sync.Mutex
+	byToken map[string]renewal
+}{byToken: make(map[string]renewal)}
+
+// SetRefreshCookie() записывает refresh-токен в cookie. При maxAge < 0 cookie удаляется
+func SetRefreshCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookie,
+		Value:    value,
+		Path:     "/api/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// SetTokenCookie() записывает access-токен в cookie веб-интерфейса
+func SetTokenCookie(w http.ResponseWriter, value string) {
+	http.SetCookie(w, &http.Cookie{Name: TokenCookie, Value: value, Path: "/", SameSite: http.SameSiteLaxMode})
+}
+
+// renewSession() продлевает сессию веб-интерфейса, когда access-токен из cookie
+// истёк или отсутствует: refresh-токен из cookie обменивается на новую пару токенов,
+// которая записывается в cookie ответа. Возвращает контекст с пользователем
+func renewSession(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	cookie, err := r.Cookie(RefreshCookie)
+	if err != nil || cookie.Value == "" {
+		return r.Context(), ErrInvalidToken
+	}
+
+	renewals.Lock()
+	now := time.Now()
+	for token, rn := range renewals.byToken {
+		if now.After(rn.expires) {
+			delete(renewals.byToken, token)
+		}
+	}
+	rn, ok := renewals.byToken[cookie.Value]
+	if !ok {
+		tokens, err := Refresh(cookie.Value)
+		if err != nil {
+			renewals.Unlock()
+			return r.Context(), err
+		}
+		rn = renewal{tokens: tokens, expires: now.Add(renewGrace)}
+		renewals.byToken[cookie.Value] = rn
+	}
+	renewals.Unlock()
+
+	SetTokenCookie(w, rn.tokens.Token)
+	SetRefreshCookie(w, rn.tokens.RefreshToken, int(RefreshTTL.Seconds()))
+	return authenticateToken(r.Context(), rn.tokens.Token)
+}
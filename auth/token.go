@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Типы токенов: access передаётся с каждым запросом, refresh служит только
+// для получения новой пары токенов
+const (
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+)
+
+// Время жизни токенов. Время жизни access-токена можно изменить
+// переменной окружения TODO_TOKEN_TTL, например 1h
+const (
+	DefaultAccessTTL = 15 * time.Minute
+	RefreshTTL       = 30 * 24 * time.Hour
+)
+
+// ErrInvalidToken возвращается для недействительных, истёкших и отозванных токенов
+var ErrInvalidToken = errors.New("недействительный токен")
+
+// TokenPair — токены, выдаваемые при входе и обновлении. ExpiresIn — время жизни
+// access-токена в секундах
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// accessTTL() возвращает время жизни access-токена
+func accessTTL() time.Duration {
+	if v := os.Getenv("TODO_TOKEN_TTL"); v != "" {
+		if ttl, err := time.ParseDuration(v); err == nil && ttl > 0 {
+			return ttl
+		}
+	}
+	return DefaultAccessTTL
+}
+
+// IssueTokens() выдаёт пользователю access- и refresh-токен
+func IssueTokens(user database.User) (TokenPair, error) {
+	ttl := accessTTL()
+	access, err := signToken(user, tokenAccess, ttl)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := signToken(user, tokenRefresh, RefreshTTL)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{Token: access, RefreshToken: refresh, ExpiresIn: int(ttl.Seconds())}, nil
+}
+
+// signToken() подписывает действующим ключом токен типа typ со сроком действия ttl.
+// kid ключа записывается в заголовок токена, роль пользователя — в утверждение role
+// для клиентов. Версия токенов пользователя в утверждении ver позволяет отозвать
+// все его токены сразу
+func signToken(user database.User, typ string, ttl time.Duration) (string, error) {
+	key, err := activeKey()
+	if err != nil {
+		return "", err
+	}
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := jwt.TimeFunc()
+	claims := jwt.MapClaims{
+		"sub":   strconv.FormatInt(user.ID, 10),
+		"login": user.Login,
+		"role":  UserRole(user),
+		"typ":   typ,
+		"ver":   user.TokenVersion,
+		"jti":   jti,
+		"iat":   now.Unix(),
+		"exp":   now.Add(ttl).Unix(),
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("не удалось подписать JWT: %v", err)
+	}
+	return signed, nil
+}
+
+// newTokenID() возвращает случайный идентификатор токена
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("не удалось создать идентификатор токена: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Refresh() проверяет refresh-токен, отзывает его и выдаёт новую пару токенов.
+// Так каждый refresh-токен можно использовать только один раз
+func Refresh(refreshToken string) (TokenPair, error) {
+	user, claims, err := parseToken(refreshToken, tokenRefresh)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err := revoke(claims); err != nil {
+		return TokenPair{}, err
+	}
+	return IssueTokens(user)
+}
+
+// Revoke() отзывает токен до истечения его срока действия.
+// Недействительные и истёкшие токены отзывать не нужно, они пропускаются
+func Revoke(tokenString string) error {
+	claims, err := verifyToken(tokenString)
+	if err != nil {
+		return nil
+	}
+	return revoke(claims)
+}
+
+func revoke(claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	return users.RevokeToken(jti, time.Unix(int64(exp), 0))
+}
+
+// verifyToken() проверяет подпись и срок действия токена. Подпись проверяется
+// действующим ключом с kid из заголовка, алгоритм токена должен совпадать с алгоритмом ключа
+func verifyToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("неподдерживаемый алгоритм подписи %v", token.Header["alg"])
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	// Токены без срока действия и идентификатора не принимаются
+	if !claims.VerifyExpiresAt(jwt.TimeFunc().Unix(), true) {
+		return nil, fmt.Errorf("%w: не указан срок действия", ErrInvalidToken)
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return nil, fmt.Errorf("%w: не указан идентификатор", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// parseToken() проверяет токен типа typ и возвращает пользователя, которому он выдан.
+// Токен недействителен, если он отозван, пользователь удалён или сменил пароль
+func parseToken(tokenString, typ string) (database.User, jwt.MapClaims, error) {
+	claims, err := verifyToken(tokenString)
+	if err != nil {
+		return database.User{}, nil, err
+	}
+	if t, _ := claims["typ"].(string); t != typ {
+		return database.User{}, nil, fmt.Errorf("%w: ожидается токен типа %s", ErrInvalidToken, typ)
+	}
+
+	revoked, err := users.IsTokenRevoked(claims["jti"].(string))
+	if err != nil {
+		return database.User{}, nil, err
+	}
+	if revoked {
+		return database.User{}, nil, fmt.Errorf("%w: токен отозван", ErrInvalidToken)
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.ParseInt(sub, 10, 64)
+	if err != nil {
+		return database.User{}, nil, fmt.Errorf("%w: некорректный пользователь", ErrInvalidToken)
+	}
+	user, err := users.GetUser(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return database.User{}, nil, fmt.Errorf("%w: пользователь удалён", ErrInvalidToken)
+	}
+	if err != nil {
+		return database.User{}, nil, err
+	}
+
+	if ver, _ := claims["ver"].(float64); int(ver) != user.TokenVersion {
+		return database.User{}, nil, fmt.Errorf("%w: пароль пользователя изменён", ErrInvalidToken)
+	}
+	return user, claims, nil
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Параметры TOTP (RFC 6238), которые понимают все приложения-аутентификаторы
+const (
+	TOTPIssuer = "Scheduler"
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew — сколько соседних интервалов принимается из-за расхождения часов
+	totpSkew = 1
+)
+
+// RecoveryCodeCount — сколько одноразовых кодов восстановления выдаётся
+// при подключении второго фактора
+const RecoveryCodeCount = 10
+
+// tokenMFA — тип токена, подтверждающего пароль до ввода кода второго фактора.
+// Он не даёт доступа к API и действует MFATTL
+const tokenMFA = "mfa"
+
+// MFATTL — время на ввод кода второго фактора после пароля
+const MFATTL = 5 * time.Minute
+
+// ReasonBadCode — причина неудачной попытки входа в журнале при неверном коде
+const ReasonBadCode = "bad_totp"
+
+var (
+	// ErrSecondFactorRequired возвращается SignIn(), если пароль верен,
+	// но пользователю нужно ввести код второго фактора
+	ErrSecondFactorRequired = errors.New("требуется код двухфакторной аутентификации")
+	// ErrBadCode возвращается при неверном или уже использованном коде
+	ErrBadCode = errors.New("неверный код двухфакторной аутентификации")
+	// ErrTOTPEnabled возвращается при повторном подключении второго фактора
+	ErrTOTPEnabled = errors.New("двухфакторная аутентификация уже включена")
+	// ErrTOTPNotEnabled возвращается, если второй фактор не подключается и не подключён
+	ErrTOTPNotEnabled = errors.New("двухфакторная аутентификация не включена")
+)
+
+// BeginTOTP() создаёт пользователю новый секрет TOTP и возвращает его вместе
+// с URI otpauth:// для QR-кода. Второй фактор включается только после
+// подтверждения кодом в ConfirmTOTP()
+func BeginTOTP(user database.User) (secret, uri string, err error) {
+	if user.TOTPEnabled {
+		return "", "", ErrTOTPEnabled
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("не удалось создать секрет TOTP: %v", err)
+	}
+	secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	if err := users.SetTOTP(user.ID, secret, false); err != nil {
+		return "", "", err
+	}
+	return secret, ProvisioningURI(user.Login, secret), nil
+}
+
+// ProvisioningURI() возвращает URI otpauth:// с секретом для приложения-аутентификатора
+func ProvisioningURI(login, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", TOTPIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", strconv.Itoa(totpDigits))
+	v.Set("period", strconv.Itoa(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(TOTPIssuer+":"+login) + "?" + v.Encode()
+}
+
+// ConfirmTOTP() проверяет код из приложения, включает второй фактор
+// и возвращает коды восстановления. Коды показываются только здесь, хранятся их хеши
+func ConfirmTOTP(user database.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnabled
+	}
+	ok, err := checkTOTP(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrBadCode
+	}
+
+	codes, err := newRecoveryCodes(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := users.SetTOTP(user.ID, user.TOTPSecret, true); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP() отключает второй фактор после проверки пароля и кода
+// из приложения или кода восстановления. У пользователей без пароля,
+// например вошедших через OIDC, достаточно кода
+func DisableTOTP(user database.User, password, code string) error {
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if user.PasswordHash != "" && !CheckPassword(user, password) {
+		return ErrBadCredentials
+	}
+	ok, err := checkCode(user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBadCode
+	}
+	if err := users.SetRecoveryCodes(user.ID, nil); err != nil {
+		return err
+	}
+	return users.SetTOTP(user.ID, "", false)
+}
+
+// IssueMFAToken() выдаёт пользователю, подтвердившему пароль, токен
+// для второго шага входа
+func IssueMFAToken(user database.User) (string, error) {
+	return signToken(user, tokenMFA, MFATTL)
+}
+
+// CompleteSignIn() завершает вход по токену второго шага и коду второго фактора.
+// Токен используется один раз
+func CompleteSignIn(mfaToken, code, ip string) (database.User, error) {
+	user, claims, err := parseToken(mfaToken, tokenMFA)
+	if err != nil {
+		return database.User{}, err
+	}
+	if err := SecondFactor(user, code, ip); err != nil {
+		return database.User{}, err
+	}
+	if err := revoke(claims); err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// SecondFactor() проверяет код из приложения или код восстановления пользователя,
+// входящего с адреса ip, с учётом ограничения неудачных попыток и записывает
+// попытку в журнал. Возвращает ErrBadCode или *ThrottleError
+func SecondFactor(user database.User, code, ip string) error {
+	attempt := database.SignInAttempt{Login: truncate(user.Login, 64), IP: truncate(ip, 64), Time: time.Now()}
+	if err := limiter.Allow(ip); err != nil {
+		attempt.Reason = ReasonRateLimited
+		logAttempt(attempt)
+		return err
+	}
+
+	ok, err := checkCode(user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		limiter.Failure(ip)
+		attempt.Reason = ReasonBadCode
+		logAttempt(attempt)
+		return ErrBadCode
+	}
+
+	limiter.Success(ip)
+	attempt.Success = true
+	logAttempt(attempt)
+	return nil
+}
+
+// checkCode() проверяет код из приложения или, если код не похож на него,
+// код восстановления. Использованный код восстановления удаляется
+func checkCode(user database.User, code string) (bool, error) {
+	code = normalizeCode(code)
+	if len(code) == totpDigits {
+		if _, err := strconv.Atoi(code); err == nil {
+			return checkTOTP(user, code)
+		}
+	}
+	if code == "" {
+		return false, nil
+	}
+	return users.UseRecoveryCode(user.ID, hashRecoveryCode(code))
+}
+
+// checkTOTP() проверяет код из приложения для текущего времени jwt.TimeFunc()
+// с допуском totpSkew интервалов. Принятый интервал запоминается, поэтому
+// перехваченный код нельзя использовать повторно
+func checkTOTP(user database.User, code string) (bool, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(user.TOTPSecret)
+	if err != nil || len(key) == 0 {
+		return false, nil
+	}
+	code = normalizeCode(code)
+	now := jwt.TimeFunc().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step), totpDigits)), []byte(code)) == 1 {
+			return users.UseTOTPStep(user.ID, step)
+		}
+	}
+	return false, nil
+}
+
+// TOTPCode() возвращает код, который приложение с секретом secret покажет в момент at
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("некорректный секрет TOTP: %v", err)
+	}
+	return hotp(key, uint64(at.Unix()/totpPeriod), totpDigits), nil
+}
+
+// hotp() вычисляет одноразовый код из digits цифр для счётчика counter (RFC 4226)
+func hotp(key []byte, counter uint64, digits int) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// newRecoveryCodes() создаёт пользователю новые коды восстановления вида xxxxx-xxxxx
+// взамен прежних
+func newRecoveryCodes(user database.User) ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("не удалось создать код восстановления: %v", err)
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+	if err := users.SetRecoveryCodes(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeCode() убирает из кода пробелы и дефисы, которые пользователи вводят вместе с ним
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+}
+
+// hashRecoveryCode() возвращает хеш, под которым хранится код восстановления
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
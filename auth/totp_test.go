@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"final_project/database"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useClock() останавливает часы токенов и TOTP на now и возвращает указатель,
+// через который тест переводит их.
+func useClock(t *testing.T, now time.Time) *time.Time {
+	t.Helper()
+	jwt.TimeFunc = func() time.Time { return now }
+	t.Cleanup(func() { jwt.TimeFunc = time.Now })
+	return &now
+}
+
+// totpCode() возвращает код из приложения для секрета secret в момент at.
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := TOTPCode(secret, at)
+	require.NoError(t, err)
+	return code
+}
+
+// enrolTOTP() включает пользователю login второй фактор и возвращает его
+// с секретом и кодами восстановления.
+func enrolTOTP(t *testing.T, mem *database.MemoryStore, login string, now *time.Time) (database.User, []string) {
+	t.Helper()
+	hash, err := HashPassword("password")
+	require.NoError(t, err)
+	id, err := mem.CreateUser(database.User{Login: login, PasswordHash: hash})
+	require.NoError(t, err)
+	user, err := mem.GetUser(id)
+	require.NoError(t, err)
+
+	_, _, err = BeginTOTP(user)
+	require.NoError(t, err)
+	user, err = mem.GetUser(id)
+	require.NoError(t, err)
+	codes, err := ConfirmTOTP(user, totpCode(t, user.TOTPSecret, *now))
+	require.NoError(t, err)
+	user, err = mem.GetUser(id)
+	require.NoError(t, err)
+	return user, codes
+}
+
+func TestHOTPVectors(t *testing.T) {
+	// Тестовые значения RFC 6238, приложение B, для SHA1
+	key := []byte("12345678901234567890")
+	for _, tc := range []struct {
+		at   int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		assert.Equal(t, tc.code, hotp(key, uint64(tc.at/totpPeriod), 8), tc.at)
+	}
+	// Шестизначный код — последние цифры восьмизначного
+	assert.Equal(t, "287082", hotp(key, 1, 6))
+}
+
+func TestProvisioningURI(t *testing.T) {
+	u, err := url.Parse(ProvisioningURI("иван", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Scheduler:иван", u.Path)
+	q := u.Query()
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", q.Get("secret"))
+	assert.Equal(t, "Scheduler", q.Get("issuer"))
+	assert.Equal(t, "6", q.Get("digits"))
+	assert.Equal(t, "30", q.Get("period"))
+}
+
+func TestTOTPEnrolment(t *testing.T) {
+	mem := useUsers(t)
+	now := useClock(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	id, err := mem.CreateUser(database.User{Login: "ivan"})
+	require.NoError(t, err)
+	ivan, err := mem.GetUser(id)
+	require.NoError(t, err)
+
+	_, err = ConfirmTOTP(ivan, "123456")
+	assert.ErrorIs(t, err, ErrTOTPNotEnabled, "подтверждение до начала подключения")
+
+	secret, uri, err := BeginTOTP(ivan)
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+	assert.Contains(t, uri, "secret="+secret)
+	ivan, err = mem.GetUser(id)
+	require.NoError(t, err)
+	assert.Equal(t, secret, ivan.TOTPSecret)
+	assert.False(t, ivan.TOTPEnabled, "второй фактор включается только после подтверждения")
+
+	_, err = ConfirmTOTP(ivan, totpCode(t, secret, now.Add(-time.Hour)))
+	assert.ErrorIs(t, err, ErrBadCode)
+	codes, err := ConfirmTOTP(ivan, totpCode(t, secret, *now))
+	require.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodeCount)
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, codes[0])
+	ivan, err = mem.GetUser(id)
+	require.NoError(t, err)
+	assert.True(t, ivan.TOTPEnabled)
+	n, err := mem.CountRecoveryCodes(id)
+	require.NoError(t, err)
+	assert.Equal(t, RecoveryCodeCount, n)
+
+	_, _, err = BeginTOTP(ivan)
+	assert.ErrorIs(t, err, ErrTOTPEnabled)
+}
+
+func TestCheckTOTP(t *testing.T) {
+	mem := useUsers(t)
+	now := useClock(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ivan, _ := enrolTOTP(t, mem, "ivan", now)
+
+	// Код интервала подтверждения уже использован
+	*now = now.Add(totpPeriod * time.Second)
+	for _, tc := range []struct {
+		at time.Time
+		ok bool
+	}{
+		{now.Add(-totpPeriod * time.Second), false},
+		{now.Add(totpPeriod * time.Second), true},
+		{*now, false},
+		{now.Add(2 * totpPeriod * time.Second), false},
+	} {
+		ok, err := checkTOTP(ivan, totpCode(t, ivan.TOTPSecret, tc.at))
+		require.NoError(t, err)
+		assert.Equal(t, tc.ok, ok, tc.at)
+	}
+
+	*now = now.Add(2 * totpPeriod * time.Second)
+	code := totpCode(t, ivan.TOTPSecret, *now)
+	ok, err := checkTOTP(ivan, code[:3]+" "+code[3:])
+	require.NoError(t, err)
+	assert.True(t, ok, "пробелы в коде пропускаются")
+	ok, err = checkTOTP(ivan, code)
+	require.NoError(t, err)
+	assert.False(t, ok, "код нельзя использовать повторно")
+}
+
+func TestSignInSecondFactor(t *testing.T) {
+	mem := useUsers(t)
+	useLimiter(t, DefaultSignInLimits)
+	now := useClock(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ivan, codes := enrolTOTP(t, mem, "ivan", now)
+	*now = now.Add(time.Minute)
+
+	user, err := SignIn("ivan", "password", "10.0.0.1")
+	require.ErrorIs(t, err, ErrSecondFactorRequired)
+	assert.Equal(t, ivan.ID, user.ID)
+	_, err = SignIn("ivan", "wrong", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrBadCredentials, "без верного пароля код не запрашивается")
+
+	mfa, err := IssueMFAToken(user)
+	require.NoError(t, err)
+	_, err = validateToken(mfa)
+	assert.ErrorIs(t, err, ErrInvalidToken, "токен второго шага не даёт доступа к API")
+
+	_, err = CompleteSignIn(mfa, "000000", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrBadCode)
+	user, err = CompleteSignIn(mfa, totpCode(t, ivan.TOTPSecret, *now), "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "ivan", user.Login)
+	_, err = CompleteSignIn(mfa, totpCode(t, ivan.TOTPSecret, now.Add(totpPeriod*time.Second)), "10.0.0.1")
+	assert.ErrorIs(t, err, ErrInvalidToken, "токен второго шага одноразовый")
+
+	// Токен второго шага истекает
+	mfa, err = IssueMFAToken(user)
+	require.NoError(t, err)
+	*now = now.Add(MFATTL + time.Second)
+	_, err = CompleteSignIn(mfa, totpCode(t, ivan.TOTPSecret, *now), "10.0.0.1")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Код восстановления принимается один раз
+	require.NoError(t, SecondFactor(ivan, codes[0], "10.0.0.1"))
+	assert.ErrorIs(t, SecondFactor(ivan, codes[0], "10.0.0.1"), ErrBadCode)
+
+	attempts, err := mem.ListSignIns(10)
+	require.NoError(t, err)
+	var reasons []string
+	for _, a := range attempts {
+		reasons = append(reasons, a.Reason)
+	}
+	assert.Equal(t, []string{ReasonBadCode, "", "", ReasonBadCode, ReasonBadCredentials}, reasons)
+}
+
+func TestSecondFactorThrottle(t *testing.T) {
+	mem := useUsers(t)
+	limits := DefaultSignInLimits
+	limits.FreeAttempts = 3
+	useLimiter(t, limits)
+	now := useClock(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ivan, _ := enrolTOTP(t, mem, "ivan", now)
+
+	// Верный пароль не сбрасывает неудачи, пока не введён код
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, SecondFactor(ivan, "000000", "10.0.0.1"), ErrBadCode)
+	}
+	_, err := SignIn("ivan", "password", "10.0.0.1")
+	require.ErrorIs(t, err, ErrSecondFactorRequired)
+	assert.ErrorIs(t, SecondFactor(ivan, "000000", "10.0.0.1"), ErrBadCode)
+	assert.ErrorIs(t, SecondFactor(ivan, totpCode(t, ivan.TOTPSecret, *now), "10.0.0.1"), ErrTooManyAttempts)
+}
+
+func TestDisableTOTP(t *testing.T) {
+	mem := useUsers(t)
+	now := useClock(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ivan, codes := enrolTOTP(t, mem, "ivan", now)
+
+	assert.ErrorIs(t, DisableTOTP(ivan, "wrong", codes[0]), ErrBadCredentials)
+	assert.ErrorIs(t, DisableTOTP(ivan, "password", "abcde-abcde"), ErrBadCode)
+	require.NoError(t, DisableTOTP(ivan, "password", codes[0]))
+
+	ivan, err := mem.GetUser(ivan.ID)
+	require.NoError(t, err)
+	assert.False(t, ivan.TOTPEnabled)
+	assert.Empty(t, ivan.TOTPSecret)
+	n, err := mem.CountRecoveryCodes(ivan.ID)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.ErrorIs(t, DisableTOTP(ivan, "password", codes[1]), ErrTOTPNotEnabled)
+
+	// Пользователю без пароля достаточно кода второго фактора
+	petr, codes := enrolTOTP(t, mem, "petr", now)
+	require.NoError(t, mem.SetPassword(petr.ID, ""))
+	petr, err = mem.GetUser(petr.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, DisableTOTP(petr, "", "abcde-abcde"), ErrBadCode)
+	require.NoError(t, DisableTOTP(petr, "", codes[0]))
+	petr, err = mem.GetUser(petr.ID)
+	require.NoError(t, err)
+	assert.False(t, petr.TOTPEnabled)
+}
+
+func TestDAVAuthSecondFactor(t *testing.T) {
+	mem := useUsers(t)
+	now := useClock(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ivan, _ := enrolTOTP(t, mem, "ivan", now)
+	token, _, err := CreateAPIToken(ivan, "телефон", nil)
+	require.NoError(t, err)
+	h := DAVAuth(http.HandlerFunc(whoami))
+
+	// Пароля без кода недостаточно, API-токен работает
+	for password, code := range map[string]int{"password": http.StatusUnauthorized, token: http.StatusOK} {
+		req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+		req.SetBasicAuth("ivan", password)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, code, rec.Code)
+	}
+}
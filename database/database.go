@@ -10,68 +10,76 @@ import (
 	_ "modernc.org/sqlite"
 )
 
+const dialectSQLite = "sqlite"
+
 var ActualDbPath string
-var DBconn *sql.DB
 
-// InitializeDB проверяет существование базы данных, создаёт её и таблицы при необходимости.
-func InitializeDB() error {
-	// Используем путь из переменной окружения или тестового файла
-	dbPath := tests.DBFile
-	if path := os.Getenv("TODO_DBFILE"); path != "" {
-		dbPath = path
-	}
-	ActualDbPath = dbPath
+// Open() открывает базу данных, выбранную переменной окружения TODO_DB_DRIVER,
+// и возвращает хранилище задач поверх неё, не применяя миграции.
+//
+// Для sqlite (по умолчанию) путь к файлу берётся из TODO_DBFILE или тестового файла,
+// для postgres строка подключения берётся из TODO_DB_DSN.
+func Open() (*SQLStore, error) {
+	switch driver := os.Getenv("TODO_DB_DRIVER"); driver {
+	case "", dialectSQLite:
+		// Используем путь из переменной окружения или тестового файла
+		dbPath := tests.DBFile
+		if path := os.Getenv("TODO_DBFILE"); path != "" {
+			dbPath = path
+		}
+		ActualDbPath = dbPath
 
-	// Проверяем существование базы данных
-	_, err := os.Stat(dbPath)
-	if os.IsNotExist(err) {
-		// Создаём базу данных, если её нет
-		if err := createAndInitializeDB(dbPath); err != nil {
-			return fmt.Errorf("Ошибка при создании базы данных: %w", err)
+		db, err := connect(dialectSQLite, dbPath)
+		if err != nil {
+			return nil, err
 		}
+		return NewSQLiteStore(db), nil
+	case dialectPostgres:
+		dsn := os.Getenv("TODO_DB_DSN")
+		if dsn == "" {
+			return nil, fmt.Errorf("для драйвера postgres необходимо задать TODO_DB_DSN")
+		}
+
+		db, err := connect(dialectPostgres, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return NewPostgresStore(db), nil
+	default:
+		return nil, fmt.Errorf("неизвестный драйвер БД: %s", driver)
 	}
+}
 
-	// Открываем соединение с базой данных
-	db, err := sql.Open("sqlite", dbPath)
+// connect() открывает соединение с базой данных и проверяет его.
+func connect(driver, dsn string) (*sql.DB, error) {
+	// Открываем соединение с базой данных, файл SQLite создаётся при необходимости
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
-		return fmt.Errorf("Не удалось открыть базу данных: %w", err)
+		return nil, fmt.Errorf("Не удалось открыть базу данных: %w", err)
 	}
 
 	// Проверяем соединение
 	if err := db.Ping(); err != nil {
-		return fmt.Errorf("Ошибка проверки соединения с базой данных: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("Ошибка проверки соединения с базой данных: %w", err)
 	}
 
-	DBconn = db
-	return nil
+	return db, nil
 }
 
-// createAndInitializeDB создаёт новую базу данных и инициализирует таблицы.
-func createAndInitializeDB(path string) error {
-	// Открываем или создаём базу данных
-	db, err := sql.Open("sqlite", path)
+// InitializeDB открывает базу данных, приводит её схему к актуальной версии
+// и возвращает хранилище задач поверх неё.
+func InitializeDB() (*SQLStore, error) {
+	store, err := Open()
 	if err != nil {
-		return fmt.Errorf("Не удалось открыть базу данных: %w", err)
+		return nil, err
 	}
-	defer db.Close()
 
-	// SQL-запрос для создания таблицы и индекса
-	createQuery := `
-	CREATE TABLE scheduler (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		date CHAR(8) NOT NULL DEFAULT "19700101",
-		title VARCHAR(128) NOT NULL DEFAULT "",
-		comment VARCHAR(256) NOT NULL DEFAULT "",
-		repeat VARCHAR(128) NOT NULL DEFAULT ""
-	);
-	CREATE INDEX date_scheduler on scheduler (date);
-	`
-
-	// Выполняем запрос на создание таблицы
-	_, err = db.Exec(createQuery)
-	if err != nil {
-		return fmt.Errorf("Не удалось создать таблицу: %w", err)
+	// Применяем все ещё не применённые миграции
+	if _, err := MigrateUp(store.DB(), store.Dialect()); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("Ошибка при миграции базы данных: %w", err)
 	}
 
-	return nil
+	return store, nil
 }
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Полнотекстовый поиск по заголовку и комментарию задачи. Текст разбивается
+// на слова из букв и цифр; регистр и различие «ё» и «е» не учитываются.
+// Язык запросов описан в query.go.
+
+// Веса совпадений в заголовке и в комментарии при ранжировании.
+const (
+	titleWeight   = 10
+	commentWeight = 1
+)
+
+// Фрагмент с найденными словами: длина в словах, число слов перед первым
+// совпадением и разметка найденных слов.
+const (
+	snippetWords  = 12
+	snippetBefore = 3
+	markStart     = "<mark>"
+	markEnd       = "</mark>"
+	ellipsis      = "…"
+)
+
+// searchTerm — искомый текст: подряд идущие слова. Если prefix, последнее
+// слово ищется как префикс («отч» находит «отчёт»).
+type searchTerm struct {
+	words  []string
+	prefix bool
+}
+
+// wordMatches() сравнивает слово word с i-м словом искомого текста.
+func (t searchTerm) wordMatches(i int, word string) bool {
+	if t.prefix && i == len(t.words)-1 {
+		return strings.HasPrefix(word, t.words[i])
+	}
+	return word == t.words[i]
+}
+
+// matches() проверяет, совпадает ли слово word с одним из слов искомого текста.
+func (t searchTerm) matches(word string) bool {
+	for i := range t.words {
+		if t.wordMatches(i, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// count() возвращает число вхождений искомого текста в последовательность слов.
+func (t searchTerm) count(words []string) int {
+	n := 0
+	for i := 0; i+len(t.words) <= len(words); i++ {
+		match := true
+		for j := range t.words {
+			if !t.wordMatches(j, words[i+j]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
+
+// wordSpan — слово текста: границы в байтах и приведённая форма.
+type wordSpan struct {
+	start, end int
+	word       string
+}
+
+// splitWords() разбивает текст на слова из букв и цифр.
+func splitWords(text string) []wordSpan {
+	var spans []wordSpan
+	start := -1
+	for i, r := range text {
+		letter := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case letter && start < 0:
+			start = i
+		case !letter && start >= 0:
+			spans = append(spans, wordSpan{start, i, foldWord(text[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, wordSpan{start, len(text), foldWord(text[start:])})
+	}
+	return spans
+}
+
+// foldWords() возвращает приведённые слова текста.
+func foldWords(text string) []string {
+	spans := splitWords(text)
+	words := make([]string, len(spans))
+	for i, s := range spans {
+		words[i] = s.word
+	}
+	return words
+}
+
+// foldWord() приводит слово к нижнему регистру и заменяет «ё» на «е» —
+// так же, как триггеры индекса scheduler_fts.
+func foldWord(word string) string {
+	return strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+}
+
+// snippet() возвращает фрагмент заголовка или комментария задачи с найденными
+// словами, выделенными тегом <mark>. Остальной текст экранируется для HTML.
+func snippet(terms []searchTerm, task Task) string {
+	for _, text := range []string{task.Title, task.Comment} {
+		if s := highlight(terms, text); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// highlight() выделяет найденные слова в тексте и обрезает его до snippetWords слов
+// вокруг первого совпадения. Если совпадений нет, возвращает пустую строку.
+func highlight(terms []searchTerm, text string) string {
+	spans := splitWords(text)
+	first := -1
+	marked := make([]bool, len(spans))
+	for i, s := range spans {
+		for _, t := range terms {
+			if t.matches(s.word) {
+				marked[i] = true
+				break
+			}
+		}
+		if marked[i] && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	from := max(first-snippetBefore, 0)
+	to := min(from+snippetWords, len(spans))
+	from = max(to-snippetWords, 0)
+
+	var b strings.Builder
+	start, end := 0, len(text)
+	if from > 0 {
+		b.WriteString(ellipsis)
+		start = spans[from].start
+	}
+	if to < len(spans) {
+		end = spans[to-1].end
+	}
+	pos := start
+	for i := from; i < to; i++ {
+		if !marked[i] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:spans[i].start]))
+		b.WriteString(markStart)
+		b.WriteString(html.EscapeString(text[spans[i].start:spans[i].end]))
+		b.WriteString(markEnd)
+		pos = spans[i].end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if to < len(spans) {
+		b.WriteString(ellipsis)
+	}
+	return b.String()
+}
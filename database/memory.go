@@ -0,0 +1,729 @@
+package database
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore хранит задачи и пользователей в памяти процесса. Используется в тестах.
+// Хранилища, полученные через ForUser(), разделяют данные и отличаются только user.
+type MemoryStore struct {
+	*memoryData
+	user int64
+}
+
+type memoryData struct {
+	mu         sync.Mutex
+	nextID     int64
+	tasks      map[int64]Task
+	owners     map[int64]int64
+	nextUserID int64
+	users      map[int64]User
+	revoked    map[string]time.Time
+	keys       []SigningKey
+	signIns    []SignInAttempt
+	nextToken  int64
+	apiTokens  map[int64]APIToken
+	totpSteps  map[int64]int64
+	recovery   map[int64]map[string]bool
+	identities map[[2]string]int64
+	nextTagID  int64
+	tags       map[int64]Tag
+	tagOwners  map[int64]int64
+}
+
+// NewMemoryStore() создаёт хранилище в памяти с администратором DefaultUserID,
+// как после миграций.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		memoryData: &memoryData{
+			nextID:     1,
+			tasks:      make(map[int64]Task),
+			owners:     make(map[int64]int64),
+			nextUserID: DefaultUserID + 1,
+			users:      map[int64]User{DefaultUserID: {ID: DefaultUserID, Login: "admin", Admin: true, Role: RoleEditor}},
+			revoked:    make(map[string]time.Time),
+			nextToken:  1,
+			apiTokens:  make(map[int64]APIToken),
+			totpSteps:  make(map[int64]int64),
+			recovery:   make(map[int64]map[string]bool),
+			identities: make(map[[2]string]int64),
+			nextTagID:  1,
+			tags:       make(map[int64]Tag),
+			tagOwners:  make(map[int64]int64),
+		},
+		user: DefaultUserID,
+	}
+}
+
+func (s *MemoryStore) ForUser(userID int64) TaskStore {
+	return &MemoryStore{memoryData: s.memoryData, user: userID}
+}
+
+// task() возвращает задачу пользователя хранилища. Вызывается под s.mu.
+func (s *MemoryStore) task(id int64) (Task, bool) {
+	task, ok := s.tasks[id]
+	if !ok || s.owners[id] != s.user {
+		return Task{}, false
+	}
+	return task, true
+}
+
+func (s *MemoryStore) Get(id int64) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.task(id)
+	if !ok {
+		return Task{}, ErrNotFound
+	}
+	return task, nil
+}
+
+func (s *MemoryStore) GetByObjectName(name string) (Task, error) {
+	tasks := s.filter(func(t Task) bool { return name != "" && t.ObjectName == name }, 1)
+	if len(tasks) == 0 {
+		return Task{}, ErrNotFound
+	}
+	return tasks[0], nil
+}
+
+func (s *MemoryStore) List(limit int) ([]Task, error) {
+	return s.filter(func(Task) bool { return true }, limit), nil
+}
+
+func (s *MemoryStore) Search(filter SearchFilter, limit int) ([]Task, error) {
+	q, err := ParseQuery(filter.Text)
+	if err != nil {
+		return nil, err
+	}
+	filter.Sort = searchSort(filter, q)
+	tasks := []Task{}
+	for _, t := range s.filter(func(t Task) bool { return matchTask(filter, t) }, 0) {
+		if filter.Text != "" {
+			if !q.match(t) {
+				continue
+			}
+			t.Rank = q.rank(t)
+		}
+		if filter.After != nil && !taskBefore(*filter.After, CursorOf(t, filter.Sort), filter.Sort) {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	if filter.Sort != "" && filter.Sort != SortDate {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return taskBefore(CursorOf(tasks[i], filter.Sort), CursorOf(tasks[j], filter.Sort), filter.Sort)
+		})
+	}
+	if limit > 0 && len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	for i := range tasks {
+		tasks[i].Snippet = q.snippet(tasks[i])
+	}
+	return tasks, nil
+}
+
+func (s *MemoryStore) ListUntil(to string) ([]Task, error) {
+	return s.filter(func(t Task) bool { return t.Date <= to }, 0), nil
+}
+
+func (s *MemoryStore) Create(task Task) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	task = SplitRepeatEnd(task)
+	task.ID = strconv.FormatInt(id, 10)
+	task.Tags = s.useTags(task.Tags)
+	s.tasks[id] = task
+	s.owners[id] = s.user
+	return id, nil
+}
+
+func (s *MemoryStore) CreateMany(tasks []Task) ([]int64, error) {
+	ids := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		id, _ := s.Create(task)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) Update(task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := strconv.ParseInt(task.ID, 10, 64)
+	if err != nil {
+		return ErrNotFound
+	}
+	old, ok := s.task(id)
+	if !ok {
+		return ErrNotFound
+	}
+	task = SplitRepeatEnd(task)
+	task.UID, task.ObjectName = old.UID, old.ObjectName
+	if task.Tags == nil {
+		task.Tags = old.Tags
+	} else {
+		task.Tags = s.useTags(task.Tags)
+	}
+	s.tasks[id] = task
+	return nil
+}
+
+func (s *MemoryStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.task(id); !ok {
+		return ErrNotFound
+	}
+	delete(s.tasks, id)
+	delete(s.owners, id)
+	return nil
+}
+
+func (s *MemoryStore) Complete(id int64, nextDate string) error {
+	if nextDate == "" {
+		return s.Delete(id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.task(id)
+	if !ok {
+		return ErrNotFound
+	}
+	task.Date = nextDate
+	if task.RepeatCount > 0 {
+		task.RepeatCount--
+	}
+	s.tasks[id] = task
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// filter() возвращает не более limit задач пользователя хранилища, для которых
+// match вернул true, упорядоченных по дате и id.
+func (s *MemoryStore) filter(match func(Task) bool, limit int) []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, 0, len(s.tasks))
+	for id, t := range s.tasks {
+		if s.owners[id] == s.user && match(t) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := s.tasks[ids[i]], s.tasks[ids[j]]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return ids[i] < ids[j]
+	})
+
+	tasks := []Task{}
+	for _, id := range ids {
+		if limit > 0 && len(tasks) >= limit {
+			break
+		}
+		tasks = append(tasks, s.tasks[id])
+	}
+	return tasks
+}
+
+func (s *MemoryStore) CreateUser(user User) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Login == user.Login {
+			return 0, ErrUserExists
+		}
+	}
+	if user.Role == "" {
+		user.Role = RoleEditor
+	}
+	user.ID = s.nextUserID
+	s.nextUserID++
+	s.users[user.ID] = user
+	return user.ID, nil
+}
+
+func (s *MemoryStore) GetUser(id int64) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) GetUserByLogin(login string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Login == login {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemoryStore) ListUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *MemoryStore) SetPassword(id int64, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = hash
+	user.TokenVersion++
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) SetRole(id int64, role string, admin bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Role, user.Admin = role, admin
+	user.TokenVersion++
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	for taskID, owner := range s.owners {
+		if owner == id {
+			delete(s.tasks, taskID)
+			delete(s.owners, taskID)
+		}
+	}
+	for tokenID, token := range s.apiTokens {
+		if token.UserID == id {
+			delete(s.apiTokens, tokenID)
+		}
+	}
+	delete(s.recovery, id)
+	for key, userID := range s.identities {
+		if userID == id {
+			delete(s.identities, key)
+		}
+	}
+	for tagID, owner := range s.tagOwners {
+		if owner == id {
+			delete(s.tags, tagID)
+			delete(s.tagOwners, tagID)
+		}
+	}
+	delete(s.totpSteps, id)
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryStore) RevokeToken(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.revoked {
+		if exp.Before(now) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsTokenRevoked(jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *MemoryStore) AddSigningKey(key SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *MemoryStore) SigningKeys() ([]SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := append([]SigningKey{}, s.keys...)
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *MemoryStore) RetireSigningKey(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].KID == kid {
+			s.keys[i].RetiredAt = time.Now()
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+func (s *MemoryStore) LogSignIn(attempt SignInAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-SignInLogRetention)
+	kept := s.signIns[:0]
+	for _, a := range s.signIns {
+		if !a.Time.Before(cutoff) {
+			kept = append(kept, a)
+		}
+	}
+	attempt.ID = int64(len(kept)) + 1
+	if len(kept) > 0 {
+		attempt.ID = kept[len(kept)-1].ID + 1
+	}
+	s.signIns = append(kept, attempt)
+	return nil
+}
+
+func (s *MemoryStore) ListSignIns(limit int) ([]SignInAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := []SignInAttempt{}
+	for i := len(s.signIns) - 1; i >= 0 && len(attempts) < limit; i-- {
+		attempts = append(attempts, s.signIns[i])
+	}
+	return attempts, nil
+}
+
+func (s *MemoryStore) CreateAPIToken(token APIToken) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = s.nextToken
+	s.nextToken++
+	s.apiTokens[token.ID] = token
+	return token.ID, nil
+}
+
+func (s *MemoryStore) GetAPITokenByHash(hash string) (APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.apiTokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+	return APIToken{}, ErrAPITokenNotFound
+}
+
+func (s *MemoryStore) ListAPITokens(userID int64) ([]APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := []APIToken{}
+	for _, token := range s.apiTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func (s *MemoryStore) DeleteAPIToken(userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.apiTokens[id]; !ok || token.UserID != userID {
+		return ErrAPITokenNotFound
+	}
+	delete(s.apiTokens, id)
+	return nil
+}
+
+func (s *MemoryStore) TouchAPIToken(id int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.apiTokens[id]
+	if !ok {
+		return ErrAPITokenNotFound
+	}
+	token.LastUsedAt = at
+	s.apiTokens[id] = token
+	return nil
+}
+
+func (s *MemoryStore) SetTOTP(userID int64, secret string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.TOTPSecret, user.TOTPEnabled = secret, enabled
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStore) UseTOTPStep(userID int64, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.totpSteps[userID] >= step {
+		return false, nil
+	}
+	s.totpSteps[userID] = step
+	return true, nil
+}
+
+func (s *MemoryStore) SetRecoveryCodes(userID int64, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[h] = true
+	}
+	s.recovery[userID] = codes
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(userID int64, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.recovery[userID][hash] {
+		return false, nil
+	}
+	delete(s.recovery[userID], hash)
+	return true, nil
+}
+
+func (s *MemoryStore) CountRecoveryCodes(userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.recovery[userID]), nil
+}
+
+func (s *MemoryStore) GetUserByIdentity(issuer, subject string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.identities[[2]string{issuer, subject}]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *MemoryStore) LinkIdentity(issuer, subject string, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{issuer, subject}
+	if _, ok := s.identities[key]; ok {
+		return ErrIdentityExists
+	}
+	s.identities[key] = userID
+	return nil
+}
+
+func (s *MemoryStore) ListTags() ([]Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := []Tag{}
+	for id, tag := range s.tags {
+		if s.tagOwners[id] != s.user {
+			continue
+		}
+		for taskID, task := range s.tasks {
+			if s.owners[taskID] == s.user && slices.Contains(task.Tags, tag.Name) {
+				tag.Tasks++
+			}
+		}
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+func (s *MemoryStore) CreateTag(name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tagByName(name); ok {
+		return 0, ErrTagExists
+	}
+	return s.addTag(name), nil
+}
+
+func (s *MemoryStore) RenameTag(id int64, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tag, ok := s.tag(id)
+	if !ok {
+		return ErrTagNotFound
+	}
+	if other, ok := s.tagByName(name); ok && other.ID != id {
+		return ErrTagExists
+	}
+	s.retagTasks(tag.Name, name)
+	tag.Name = name
+	s.tags[id] = tag
+	return nil
+}
+
+func (s *MemoryStore) MergeTags(from, to int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source, ok := s.tag(from)
+	if !ok {
+		return ErrTagNotFound
+	}
+	target, ok := s.tag(to)
+	if !ok {
+		return ErrTagNotFound
+	}
+	if from == to {
+		return nil
+	}
+	s.retagTasks(source.Name, target.Name)
+	delete(s.tags, from)
+	delete(s.tagOwners, from)
+	return nil
+}
+
+func (s *MemoryStore) DeleteTag(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tag, ok := s.tag(id)
+	if !ok {
+		return ErrTagNotFound
+	}
+	s.retagTasks(tag.Name, "")
+	delete(s.tags, id)
+	delete(s.tagOwners, id)
+	return nil
+}
+
+// tag() возвращает метку пользователя хранилища. Вызывается под s.mu.
+func (s *MemoryStore) tag(id int64) (Tag, bool) {
+	tag, ok := s.tags[id]
+	if !ok || s.tagOwners[id] != s.user {
+		return Tag{}, false
+	}
+	return tag, true
+}
+
+// tagByName() ищет метку пользователя по имени. Вызывается под s.mu.
+func (s *MemoryStore) tagByName(name string) (Tag, bool) {
+	for id, tag := range s.tags {
+		if s.tagOwners[id] == s.user && tag.Name == name {
+			return tag, true
+		}
+	}
+	return Tag{}, false
+}
+
+// addTag() добавляет метку пользователю и возвращает её id. Вызывается под s.mu.
+func (s *MemoryStore) addTag(name string) int64 {
+	id := s.nextTagID
+	s.nextTagID++
+	s.tags[id] = Tag{ID: id, Name: name}
+	s.tagOwners[id] = s.user
+	return id
+}
+
+// useTags() добавляет недостающие метки и возвращает упорядоченный список имён
+// без повторов. Вызывается под s.mu.
+func (s *MemoryStore) useTags(names []string) []string {
+	tags := []string{}
+	for _, name := range names {
+		if slices.Contains(tags, name) {
+			continue
+		}
+		if _, ok := s.tagByName(name); !ok {
+			s.addTag(name)
+		}
+		tags = append(tags, name)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// retagTasks() заменяет метку from на to во всех задачах пользователя;
+// пустое to снимает метку. Вызывается под s.mu.
+func (s *MemoryStore) retagTasks(from, to string) {
+	for id, task := range s.tasks {
+		if s.owners[id] != s.user || !slices.Contains(task.Tags, from) {
+			continue
+		}
+		tags := []string{}
+		for _, name := range task.Tags {
+			if name != from && name != to {
+				tags = append(tags, name)
+			}
+		}
+		if to != "" {
+			tags = append(tags, to)
+		}
+		sort.Strings(tags)
+		task.Tags = tags
+		s.tasks[id] = task
+	}
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+// Migration описывает одну версию схемы БД: SQL для применения и отката.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState описывает миграцию и момент её применения (пустой, если не применена).
+type MigrationState struct {
+	Migration
+	AppliedAt string
+}
+
+// loadMigrations() читает встроенные файлы миграций вида NNNN_name.up.sql / NNNN_name.down.sql
+// и возвращает их, упорядоченные по версии.
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать миграции %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("некорректное имя файла миграции: %s", name)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("некорректный номер миграции: %s", name)
+		}
+
+		body, err := fs.ReadFile(migrationsFS, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать миграцию %s: %w", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("для миграции %04d отсутствует up-скрипт", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// ensureVersionTable() создаёт таблицу schema_version, если её ещё нет.
+func ensureVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(128) NOT NULL DEFAULT '',
+		applied_at VARCHAR(32) NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		return fmt.Errorf("не удалось создать таблицу schema_version: %w", err)
+	}
+	return nil
+}
+
+// appliedVersions() возвращает применённые версии схемы и время их применения.
+func appliedVersions(db *sql.DB) (map[int]string, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var at string
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("ошибка чтения schema_version: %w", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// SchemaVersion() возвращает номер последней применённой миграции (0 для пустой БД).
+func SchemaVersion(db *sql.DB) (int, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+	return current, nil
+}
+
+// MigrationStatus() возвращает список всех известных миграций с отметкой о применении.
+func MigrationStatus(db *sql.DB, dialect string) ([]MigrationState, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		states = append(states, MigrationState{Migration: m, AppliedAt: applied[m.Version]})
+	}
+	return states, nil
+}
+
+// MigrateUp() применяет по порядку все ещё не применённые миграции.
+// Возвращает количество применённых миграций.
+func MigrateUp(db *sql.DB, dialect string) (int, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := runMigration(db, dialect, m, m.Up, true); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown() откатывает steps последних применённых миграций.
+// Возвращает количество откаченных миграций.
+func MigrateDown(db *sql.DB, dialect string, steps int) (int, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return count, fmt.Errorf("миграцию %04d_%s нельзя откатить", m.Version, m.Name)
+		}
+		if err := runMigration(db, dialect, m, m.Down, false); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// runMigration() выполняет скрипт миграции и обновляет schema_version в одной транзакции.
+func runMigration(db *sql.DB, dialect string, m Migration, script string, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("ошибка миграции %04d_%s: %w", m.Version, m.Name, err)
+	}
+
+	query := `DELETE FROM schema_version WHERE version = :version`
+	args := []interface{}{sql.Named("version", m.Version)}
+	if up {
+		query = `INSERT INTO schema_version (version, name, applied_at) VALUES (:version, :name, :applied_at)`
+		args = append(args,
+			sql.Named("name", m.Name),
+			sql.Named("applied_at", time.Now().UTC().Format(time.RFC3339)),
+		)
+	}
+	if dialect == dialectPostgres {
+		query, args = rebindNamed(query, args)
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка обновления schema_version: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// baselineSchema — схема, которую создавала прежняя версия createAndInitializeDB.
+const baselineSchema = `
+	CREATE TABLE scheduler (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date CHAR(8) NOT NULL DEFAULT "19700101",
+		title VARCHAR(128) NOT NULL DEFAULT "",
+		comment VARCHAR(256) NOT NULL DEFAULT "",
+		repeat VARCHAR(128) NOT NULL DEFAULT ""
+	);
+	CREATE INDEX date_scheduler on scheduler (date);
+	`
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "scheduler.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	sqlite, err := loadMigrations(dialectSQLite)
+	require.NoError(t, err)
+	require.NotEmpty(t, sqlite)
+	postgres, err := loadMigrations(dialectPostgres)
+	require.NoError(t, err)
+	require.Len(t, postgres, len(sqlite), "миграции для всех диалектов должны совпадать")
+
+	for i, m := range sqlite {
+		assert.Equal(t, i+1, m.Version, "миграции должны идти подряд без пропусков")
+		assert.Equal(t, m.Name, postgres[i].Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+		assert.NotEmpty(t, postgres[i].Up)
+		assert.NotEmpty(t, postgres[i].Down)
+	}
+}
+
+func TestMigrateEmptyDB(t *testing.T) {
+	db := openTestDB(t)
+
+	n, err := MigrateUp(db, dialectSQLite)
+	require.NoError(t, err)
+	assert.Greater(t, n, 0)
+
+	migrations, err := loadMigrations(dialectSQLite)
+	require.NoError(t, err)
+	version, err := SchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].Version, version)
+
+	// Повторный запуск ничего не применяет
+	n, err = MigrateUp(db, dialectSQLite)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestMigrateBaselineDB(t *testing.T) {
+	db := openTestDB(t)
+
+	_, err := db.Exec(baselineSchema)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO scheduler (date, title, comment, repeat) VALUES ('20240126', 'Старая задача', 'Комментарий', 'd 5')`)
+	require.NoError(t, err)
+
+	_, err = MigrateUp(db, dialectSQLite)
+	require.NoError(t, err)
+
+	var title, repeat string
+	err = db.QueryRow(`SELECT title, repeat FROM scheduler WHERE date = '20240126'`).Scan(&title, &repeat)
+	require.NoError(t, err)
+	assert.Equal(t, "Старая задача", title)
+	assert.Equal(t, "d 5", repeat)
+
+	states, err := MigrationStatus(db, dialectSQLite)
+	require.NoError(t, err)
+	for _, s := range states {
+		assert.NotEmpty(t, s.AppliedAt, "миграция %04d_%s не применена", s.Version, s.Name)
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	db := openTestDB(t)
+
+	total, err := MigrateUp(db, dialectSQLite)
+	require.NoError(t, err)
+
+	n, err := MigrateDown(db, dialectSQLite, total)
+	require.NoError(t, err)
+	assert.Equal(t, total, n)
+
+	version, err := SchemaVersion(db)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	var name string
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'scheduler'`).Scan(&name)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// После отката схему можно снова поднять до актуальной версии
+	n, err = MigrateUp(db, dialectSQLite)
+	require.NoError(t, err)
+	assert.Equal(t, total, n)
+}
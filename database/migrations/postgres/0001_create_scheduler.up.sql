@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS scheduler (
+	id BIGSERIAL PRIMARY KEY,
+	date CHAR(8) NOT NULL DEFAULT '19700101',
+	title VARCHAR(128) NOT NULL DEFAULT '',
+	comment VARCHAR(256) NOT NULL DEFAULT '',
+	repeat VARCHAR(128) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS date_scheduler ON scheduler (date);
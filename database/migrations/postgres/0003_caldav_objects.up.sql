@@ -0,0 +1,3 @@
+ALTER TABLE scheduler ADD COLUMN uid VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE scheduler ADD COLUMN object_name VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX object_name_scheduler ON scheduler (object_name);
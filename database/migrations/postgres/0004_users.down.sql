@@ -0,0 +1,3 @@
+DROP INDEX user_date_scheduler;
+ALTER TABLE scheduler DROP COLUMN user_id;
+DROP TABLE users;
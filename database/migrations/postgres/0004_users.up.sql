@@ -0,0 +1,10 @@
+CREATE TABLE users (
+	id BIGSERIAL PRIMARY KEY,
+	login VARCHAR(64) NOT NULL UNIQUE,
+	password_hash VARCHAR(255) NOT NULL DEFAULT '',
+	admin BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO users (id, login, admin) VALUES (1, 'admin', TRUE);
+SELECT setval(pg_get_serial_sequence('users', 'id'), 1);
+ALTER TABLE scheduler ADD COLUMN user_id BIGINT NOT NULL DEFAULT 1 REFERENCES users (id);
+CREATE INDEX user_date_scheduler ON scheduler (user_id, date);
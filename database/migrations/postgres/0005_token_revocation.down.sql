@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN token_version;
+DROP INDEX expires_revoked_tokens;
+DROP TABLE revoked_tokens;
@@ -0,0 +1,7 @@
+CREATE TABLE signing_keys (
+	kid VARCHAR(64) PRIMARY KEY,
+	algorithm VARCHAR(16) NOT NULL,
+	key_data TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	retired_at BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+CREATE TABLE recovery_codes (
+	user_id BIGINT NOT NULL REFERENCES users (id),
+	code_hash VARCHAR(64) NOT NULL,
+	PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,2 @@
+DROP INDEX user_oidc_identities;
+DROP TABLE oidc_identities;
@@ -0,0 +1,2 @@
+DROP INDEX search_scheduler;
+ALTER TABLE scheduler DROP COLUMN search;
@@ -0,0 +1,5 @@
+ALTER TABLE scheduler ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', translate(title, 'ёЁ', 'еЕ')), 'A') ||
+	setweight(to_tsvector('simple', translate(comment, 'ёЁ', 'еЕ')), 'B')
+) STORED;
+CREATE INDEX search_scheduler ON scheduler USING GIN (search);
@@ -0,0 +1,3 @@
+DROP INDEX tag_task_tags;
+DROP TABLE task_tags;
+DROP TABLE tags;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS date_scheduler;
+DROP TABLE IF EXISTS scheduler;
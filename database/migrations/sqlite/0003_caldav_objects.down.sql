@@ -0,0 +1,3 @@
+DROP INDEX object_name_scheduler;
+ALTER TABLE scheduler DROP COLUMN object_name;
+ALTER TABLE scheduler DROP COLUMN uid;
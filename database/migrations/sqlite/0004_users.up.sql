@@ -0,0 +1,9 @@
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login VARCHAR(64) NOT NULL UNIQUE,
+	password_hash VARCHAR(255) NOT NULL DEFAULT '',
+	admin BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO users (id, login, admin) VALUES (1, 'admin', TRUE);
+ALTER TABLE scheduler ADD COLUMN user_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX user_date_scheduler ON scheduler (user_id, date);
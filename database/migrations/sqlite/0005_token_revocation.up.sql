@@ -0,0 +1,6 @@
+CREATE TABLE revoked_tokens (
+	jti VARCHAR(64) PRIMARY KEY,
+	expires_at BIGINT NOT NULL
+);
+CREATE INDEX expires_revoked_tokens ON revoked_tokens (expires_at);
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
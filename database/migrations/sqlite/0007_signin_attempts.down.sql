@@ -0,0 +1,2 @@
+DROP INDEX created_signin_attempts;
+DROP TABLE signin_attempts;
@@ -0,0 +1,9 @@
+CREATE TABLE signin_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login VARCHAR(64) NOT NULL DEFAULT '',
+	ip VARCHAR(64) NOT NULL DEFAULT '',
+	success BOOLEAN NOT NULL,
+	reason VARCHAR(32) NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL
+);
+CREATE INDEX created_signin_attempts ON signin_attempts (created_at);
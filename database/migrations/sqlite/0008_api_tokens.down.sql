@@ -0,0 +1,2 @@
+DROP INDEX user_api_tokens;
+DROP TABLE api_tokens;
@@ -0,0 +1,11 @@
+CREATE TABLE api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	prefix VARCHAR(16) NOT NULL,
+	scopes VARCHAR(64) NOT NULL,
+	created_at BIGINT NOT NULL,
+	last_used_at BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX user_api_tokens ON api_tokens (user_id);
@@ -0,0 +1,7 @@
+CREATE TABLE oidc_identities (
+	issuer VARCHAR(256) NOT NULL,
+	subject VARCHAR(256) NOT NULL,
+	user_id INTEGER NOT NULL,
+	PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX user_oidc_identities ON oidc_identities (user_id);
@@ -0,0 +1,19 @@
+CREATE VIRTUAL TABLE scheduler_fts USING fts5 (title, comment, tokenize = 'unicode61 remove_diacritics 0');
+
+INSERT INTO scheduler_fts (rowid, title, comment)
+	SELECT id, replace(replace(title, 'ё', 'е'), 'Ё', 'Е'), replace(replace(comment, 'ё', 'е'), 'Ё', 'Е') FROM scheduler;
+
+CREATE TRIGGER scheduler_fts_insert AFTER INSERT ON scheduler BEGIN
+	INSERT INTO scheduler_fts (rowid, title, comment)
+		VALUES (new.id, replace(replace(new.title, 'ё', 'е'), 'Ё', 'Е'), replace(replace(new.comment, 'ё', 'е'), 'Ё', 'Е'));
+END;
+
+CREATE TRIGGER scheduler_fts_update AFTER UPDATE OF title, comment ON scheduler BEGIN
+	UPDATE scheduler_fts
+		SET title = replace(replace(new.title, 'ё', 'е'), 'Ё', 'Е'), comment = replace(replace(new.comment, 'ё', 'е'), 'Ё', 'Е')
+		WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER scheduler_fts_delete AFTER DELETE ON scheduler BEGIN
+	DELETE FROM scheduler_fts WHERE rowid = old.id;
+END;
@@ -0,0 +1,4 @@
+DROP TRIGGER task_tags_delete;
+DROP INDEX tag_task_tags;
+DROP TABLE task_tags;
+DROP TABLE tags;
@@ -0,0 +1,15 @@
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	UNIQUE (user_id, name)
+);
+CREATE TABLE task_tags (
+	task_id INTEGER NOT NULL,
+	tag_id INTEGER NOT NULL,
+	PRIMARY KEY (task_id, tag_id)
+);
+CREATE INDEX tag_task_tags ON task_tags (tag_id);
+CREATE TRIGGER task_tags_delete AFTER DELETE ON scheduler BEGIN
+	DELETE FROM task_tags WHERE task_id = old.id;
+END;
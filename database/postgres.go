@@ -0,0 +1,71 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+const dialectPostgres = "postgres"
+
+// rebindNamed() переводит запрос с именованными параметрами :name в запрос
+// с позиционными параметрами $1, $2..., понятный драйверу PostgreSQL.
+// Повторяющиеся имена получают один и тот же номер. Аргументы, не являющиеся
+// sql.NamedArg, передаются как есть.
+func rebindNamed(query string, args []interface{}) (string, []interface{}) {
+	named := make(map[string]interface{})
+	var positional []interface{}
+	for _, arg := range args {
+		if na, ok := arg.(sql.NamedArg); ok {
+			named[na.Name] = na.Value
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	if len(named) == 0 {
+		return query, args
+	}
+
+	var b strings.Builder
+	index := make(map[string]int)
+	out := positional
+	inString := false
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+			b.WriteByte(c)
+		case inString:
+			b.WriteByte(c)
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			// Приведение типа ::type, не параметр
+			b.WriteString("::")
+			i++
+		case c == ':' && i+1 < len(query) && isNameChar(query[i+1]):
+			j := i + 1
+			for j < len(query) && isNameChar(query[j]) {
+				j++
+			}
+			name := query[i+1 : j]
+			n, ok := index[name]
+			if !ok {
+				out = append(out, named[name])
+				n = len(out)
+				index[name] = n
+			}
+			b.WriteString("$" + strconv.Itoa(n))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), out
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
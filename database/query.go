@@ -0,0 +1,451 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Язык запросов поиска задач. Запрос — условия через пробел, задача подходит,
+// если выполнены все условия. Оператор OR разделяет альтернативы: «a b OR c»
+// означает «(a и b) или c».
+//
+//	слово, "фраза"             — текст в заголовке или комментарии; слово ищется как префикс
+//	title:слово, comment:…     — текст только в заголовке или только в комментарии
+//	repeat:d|w|m|y|none        — тип правила повторения; none — разовые задачи
+//	tag:метка, tag:"две метки" — задачи с меткой (имя сравнивается точно)
+//	before:02.01.2006          — дата раньше указанной, after: — позже, date: — равна
+//	date<02.01.2006            — сравнение даты: <, <=, >, >=, =
+//	-условие                   — отрицание условия
+//
+// Пример: title:отчёт repeat:w before:01.12.2024 -comment:черновик
+
+// Поля условий запроса.
+const (
+	fieldText    = ""
+	fieldTitle   = "title"
+	fieldComment = "comment"
+	fieldRepeat  = "repeat"
+	fieldTag     = "tag"
+	fieldDate    = "date"
+)
+
+// repeatNone — значение repeat: для разовых задач.
+const repeatNone = "none"
+
+// queryFields — префиксы условий: поле и, для дат, операция сравнения.
+var queryFields = map[string]struct{ field, op string }{
+	"title":   {fieldTitle, ""},
+	"comment": {fieldComment, ""},
+	"repeat":  {fieldRepeat, ""},
+	"tag":     {fieldTag, ""},
+	"date":    {fieldDate, "="},
+	"before":  {fieldDate, "<"},
+	"after":   {fieldDate, ">"},
+}
+
+// QueryError — ошибка разбора запроса поиска. Pos — номер символа запроса
+// (с 1), на котором обнаружена ошибка.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("ошибка в запросе (символ %d): %s", e.Pos, e.Msg)
+}
+
+// Query — разобранный запрос поиска: альтернативы, объединённые через OR,
+// каждая из которых — условия, объединённые через И. Запрос без условий
+// не подходит ни одной задаче.
+type Query struct {
+	groups [][]queryTerm
+}
+
+// queryTerm — условие запроса. Для текстовых полей задан text, для даты —
+// операция op и дата value в формате 20060102, для repeat — тип правила value,
+// для tag — имя метки value.
+type queryTerm struct {
+	negate bool
+	field  string
+	text   searchTerm
+	op     string
+	value  string
+}
+
+// ParseQuery() разбирает текст запроса поиска. Ошибки синтаксиса
+// возвращаются как *QueryError.
+func ParseQuery(text string) (Query, error) {
+	p := queryParser{src: []rune(text)}
+	return p.parse()
+}
+
+// Ranked() сообщает, есть ли в запросе текст, по которому задачи
+// можно упорядочить по релевантности.
+func (q Query) Ranked() bool {
+	return len(q.textTerms()) > 0
+}
+
+// textTerms() возвращает текстовые условия без отрицания: по ним задачи
+// ранжируются и выделяются найденные слова.
+func (q Query) textTerms() []queryTerm {
+	var terms []queryTerm
+	for _, group := range q.groups {
+		for _, t := range group {
+			if t.isText() && !t.negate {
+				terms = append(terms, t)
+			}
+		}
+	}
+	return terms
+}
+
+func (t queryTerm) isText() bool {
+	return t.field == fieldText || t.field == fieldTitle || t.field == fieldComment
+}
+
+// queryParser разбирает запрос посимвольно; pos — текущая позиция в src.
+type queryParser struct {
+	src []rune
+	pos int
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &QueryError{Pos: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) parse() (Query, error) {
+	var q Query
+	var group []queryTerm
+	orPos := -1
+	for {
+		for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			break
+		}
+		start := p.pos
+		if p.keyword("OR") {
+			if len(group) == 0 {
+				return Query{}, p.errorf(start, "слева от OR нет условия")
+			}
+			q.groups = append(q.groups, group)
+			group, orPos = nil, start
+			continue
+		}
+		term, ok, err := p.term()
+		if err != nil {
+			return Query{}, err
+		}
+		if ok {
+			group = append(group, term)
+		}
+	}
+	if len(group) == 0 {
+		if orPos >= 0 {
+			return Query{}, p.errorf(orPos, "справа от OR нет условия")
+		}
+		return q, nil
+	}
+	q.groups = append(q.groups, group)
+	return q, nil
+}
+
+// keyword() пропускает слово word, если оно стоит в текущей позиции отдельно.
+func (p *queryParser) keyword(word string) bool {
+	end := p.pos + len(word)
+	if end > len(p.src) || string(p.src[p.pos:end]) != word {
+		return false
+	}
+	if end < len(p.src) && !unicode.IsSpace(p.src[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// term() разбирает одно условие. Текст без букв и цифр пропускается: ok == false.
+func (p *queryParser) term() (t queryTerm, ok bool, err error) {
+	start := p.pos
+	if p.src[p.pos] == '-' {
+		t.negate = true
+		p.pos++
+		if p.pos >= len(p.src) || unicode.IsSpace(p.src[p.pos]) {
+			return t, false, p.errorf(start, "после «-» нет условия")
+		}
+	}
+	name := p.pos
+	if t.field, t.op, err = p.field(); err != nil {
+		return t, false, err
+	}
+	valuePos := p.pos
+	value, quoted, err := p.value()
+	if err != nil {
+		return t, false, err
+	}
+	if t.field != fieldText && value == "" {
+		return t, false, p.errorf(name, "не указано значение условия %s", string(p.src[name:valuePos]))
+	}
+
+	switch t.field {
+	case fieldTag:
+		t.value = value
+	case fieldRepeat:
+		t.value = strings.ToLower(value)
+		if _, ok := RepeatTypes[t.value]; !ok && t.value != repeatNone {
+			return t, false, p.errorf(valuePos, "repeat должен быть d, w, m, y или none")
+		}
+	case fieldDate:
+		date, err := time.Parse("02.01.2006", value)
+		if err != nil {
+			return t, false, p.errorf(valuePos, "некорректная дата «%s», ожидается дд.мм.гггг", value)
+		}
+		t.value = date.Format("20060102")
+	default:
+		words := foldWords(value)
+		if len(words) == 0 {
+			switch {
+			case quoted:
+				return t, false, p.errorf(valuePos, "в кавычках нет ни одного слова")
+			case t.field != fieldText:
+				return t, false, p.errorf(valuePos, "в значении %s нет ни одного слова", t.field)
+			case t.negate:
+				return t, false, p.errorf(start, "после «-» нет ни одного слова")
+			}
+			return t, false, nil
+		}
+		// Фраза в кавычках ищется точно, слова без кавычек — с префиксом последнего слова
+		t.text = searchTerm{words: words, prefix: !quoted}
+	}
+	return t, true, nil
+}
+
+// field() разбирает префикс условия вида title: или date>=. Если префикса нет,
+// условие относится к тексту заголовка и комментария.
+func (p *queryParser) field() (field, op string, err error) {
+	end := p.pos
+	for end < len(p.src) && p.src[end] < unicode.MaxASCII && unicode.IsLetter(p.src[end]) {
+		end++
+	}
+	if end == p.pos || end >= len(p.src) {
+		return fieldText, "", nil
+	}
+	name := strings.ToLower(string(p.src[p.pos:end]))
+	f, known := queryFields[name]
+	if p.src[end] == ':' {
+		if !known {
+			return "", "", p.errorf(p.pos, "неизвестное поле «%s»: ожидается title, comment, repeat, tag, date, before или after", name)
+		}
+		p.pos = end + 1
+		return f.field, f.op, nil
+	}
+	if name != fieldDate {
+		return fieldText, "", nil
+	}
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(string(p.src[end:]), op) {
+			p.pos = end + len(op)
+			return fieldDate, op, nil
+		}
+	}
+	return fieldText, "", nil
+}
+
+// value() разбирает значение условия: текст в кавычках или до пробела.
+func (p *queryParser) value() (value string, quoted bool, err error) {
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		start := p.pos
+		for end := p.pos + 1; end < len(p.src); end++ {
+			if p.src[end] == '"' {
+				p.pos = end + 1
+				return string(p.src[start+1 : end]), true, nil
+			}
+		}
+		return "", false, p.errorf(start, "не закрыта кавычка")
+	}
+	start := p.pos
+	for p.pos < len(p.src) && !unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos]), false, nil
+}
+
+// sql() записывает запрос условием SQL с именованными параметрами q1, q2…
+func (q Query) sql(dialect string) (string, []sql.NamedArg) {
+	if len(q.groups) == 0 {
+		return `1 = 0`, nil
+	}
+	var args []sql.NamedArg
+	var alternatives []string
+	for _, group := range q.groups {
+		var conds []string
+		for _, t := range group {
+			name := "q" + strconv.Itoa(len(args)+1)
+			cond, named := t.sql(dialect, name)
+			conds = append(conds, cond)
+			args = append(args, named...)
+		}
+		alternatives = append(alternatives, `(`+strings.Join(conds, ` AND `)+`)`)
+	}
+	return `(` + strings.Join(alternatives, ` OR `) + `)`, args
+}
+
+// sql() записывает условие с параметром name.
+func (t queryTerm) sql(dialect, name string) (string, []sql.NamedArg) {
+	var cond string
+	var args []sql.NamedArg
+	switch {
+	case t.field == fieldRepeat && t.value == repeatNone:
+		cond = `repeat = ''`
+	case t.field == fieldRepeat:
+		cond, args = repeatCondition(t.value, name)
+	case t.field == fieldTag:
+		cond, args = tagCondition(name), []sql.NamedArg{sql.Named(name, t.value)}
+	case t.field == fieldDate:
+		cond, args = `date `+t.op+` :`+name, []sql.NamedArg{sql.Named(name, t.value)}
+	case dialect == dialectPostgres:
+		cond, args = `search @@ to_tsquery('simple', :`+name+`)`, []sql.NamedArg{sql.Named(name, t.tsQuery())}
+	default:
+		cond = `id IN (SELECT rowid FROM scheduler_fts WHERE scheduler_fts MATCH :` + name + `)`
+		args = []sql.NamedArg{sql.Named(name, t.ftsQuery())}
+	}
+	if t.negate {
+		cond = `NOT (` + cond + `)`
+	}
+	return cond, args
+}
+
+// ftsQuery() записывает текстовое условие на языке запросов SQLite FTS5.
+func (t queryTerm) ftsQuery() string {
+	s := `"` + strings.Join(t.text.words, " ") + `"`
+	if t.text.prefix {
+		s += "*"
+	}
+	if t.field != fieldText {
+		s = t.field + " : " + s
+	}
+	return s
+}
+
+// tsQuery() записывает текстовое условие на языке tsquery PostgreSQL.
+// Заголовок проиндексирован с весом A, комментарий — с весом B.
+func (t queryTerm) tsQuery() string {
+	weight := map[string]string{fieldTitle: "A", fieldComment: "B"}[t.field]
+	lexemes := make([]string, len(t.text.words))
+	for i, w := range t.text.words {
+		label := weight
+		if t.text.prefix && i == len(t.text.words)-1 {
+			label = "*" + label
+		}
+		if label != "" {
+			w += ":" + label
+		}
+		lexemes[i] = w
+	}
+	return strings.Join(lexemes, " <-> ")
+}
+
+// rankQuery() возвращает запрос, по которому ранжируются результаты:
+// любое из текстовых условий без отрицания.
+func (q Query) rankQuery(dialect string) string {
+	var parts []string
+	for _, t := range q.textTerms() {
+		if dialect == dialectPostgres {
+			parts = append(parts, "("+t.tsQuery()+")")
+		} else {
+			parts = append(parts, t.ftsQuery())
+		}
+	}
+	if dialect == dialectPostgres {
+		return strings.Join(parts, " | ")
+	}
+	return strings.Join(parts, " OR ")
+}
+
+// match() проверяет задачу на соответствие запросу так же, как sql().
+func (q Query) match(task Task) bool {
+	title, comment := foldWords(task.Title), foldWords(task.Comment)
+	for _, group := range q.groups {
+		all := true
+		for _, t := range group {
+			if !t.match(task, title, comment) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// match() проверяет условие; title и comment — приведённые слова задачи.
+func (t queryTerm) match(task Task, title, comment []string) bool {
+	var ok bool
+	switch t.field {
+	case fieldTag:
+		ok = slices.Contains(task.Tags, t.value)
+	case fieldRepeat:
+		if t.value == repeatNone {
+			ok = task.Repeat == ""
+		} else {
+			ok = repeatMatches(task.Repeat, t.value)
+		}
+	case fieldDate:
+		switch t.op {
+		case "<":
+			ok = task.Date < t.value
+		case "<=":
+			ok = task.Date <= t.value
+		case ">":
+			ok = task.Date > t.value
+		case ">=":
+			ok = task.Date >= t.value
+		default:
+			ok = task.Date == t.value
+		}
+	default:
+		inTitle, inComment := t.counts(title, comment)
+		ok = inTitle+inComment > 0
+	}
+	return ok != t.negate
+}
+
+// counts() возвращает число вхождений текстового условия в заголовок и комментарий
+// с учётом поля условия.
+func (t queryTerm) counts(title, comment []string) (inTitle, inComment int) {
+	if t.field != fieldComment {
+		inTitle = t.text.count(title)
+	}
+	if t.field != fieldTitle {
+		inComment = t.text.count(comment)
+	}
+	return inTitle, inComment
+}
+
+// rank() возвращает ранг задачи: чем меньше, тем выше задача в результатах.
+// Совпадения в заголовке весят больше.
+func (q Query) rank(task Task) float64 {
+	title, comment := foldWords(task.Title), foldWords(task.Comment)
+	score := 0
+	for _, t := range q.textTerms() {
+		inTitle, inComment := t.counts(title, comment)
+		score += titleWeight*inTitle + commentWeight*inComment
+	}
+	return -float64(score)
+}
+
+// snippet() возвращает фрагмент задачи с найденными словами запроса.
+func (q Query) snippet(task Task) string {
+	var terms []searchTerm
+	for _, t := range q.textTerms() {
+		terms = append(terms, t.text)
+	}
+	return snippet(terms, task)
+}
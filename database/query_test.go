@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`title:Отчёт repeat:W before:01.12.2024 -comment:"черновик версии" склад OR date>=02.01.2025`)
+	require.NoError(t, err)
+	require.Len(t, q.groups, 2)
+	assert.Equal(t, []queryTerm{
+		{field: fieldTitle, text: searchTerm{words: []string{"отчет"}, prefix: true}},
+		{field: fieldRepeat, value: "w"},
+		{field: fieldDate, op: "<", value: "20241201"},
+		{negate: true, field: fieldComment, text: searchTerm{words: []string{"черновик", "версии"}}},
+		{field: fieldText, text: searchTerm{words: []string{"склад"}, prefix: true}},
+	}, q.groups[0])
+	assert.Equal(t, []queryTerm{{field: fieldDate, op: ">=", value: "20250102"}}, q.groups[1])
+	assert.True(t, q.Ranked())
+
+	q, err = ParseQuery("repeat:none -after:01.01.2025")
+	require.NoError(t, err)
+	assert.False(t, q.Ranked())
+
+	// Текст без букв и цифр не задаёт условий
+	q, err = ParseQuery("% ...")
+	require.NoError(t, err)
+	assert.Empty(t, q.groups)
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for text, pos := range map[string]int{
+		`title:`:            1,
+		`отчёт -`:           7,
+		`OR отчёт`:          1,
+		`отчёт OR`:          7,
+		`a OR OR b`:         6,
+		`title:"отчёт`:      7,
+		`"  "`:              1,
+		`author:ivan`:       1,
+		`repeat:q`:          8,
+		`before:2024-12-01`: 8,
+		`date>=31.02.2024`:  7,
+		`comment:%`:         9,
+	} {
+		_, err := ParseQuery(text)
+		var qe *QueryError
+		if assert.ErrorAs(t, err, &qe, text) {
+			assert.Equal(t, pos, qe.Pos, text)
+			assert.NotEmpty(t, qe.Msg, text)
+		}
+	}
+}
+
+func TestQuerySQL(t *testing.T) {
+	q, err := ParseQuery(`title:отч "за год" -repeat:m OR date:01.12.2024`)
+	require.NoError(t, err)
+
+	cond, args := q.sql(dialectSQLite)
+	assert.Equal(t, `((id IN (SELECT rowid FROM scheduler_fts WHERE scheduler_fts MATCH :q1) AND `+
+		`id IN (SELECT rowid FROM scheduler_fts WHERE scheduler_fts MATCH :q2) AND `+
+		`NOT ((repeat = :q3_type OR repeat LIKE :q3_prefix OR UPPER(repeat) LIKE :q3_freq))) OR (date = :q6))`, cond)
+	assert.Equal(t, sql.Named("q1", `title : "отч"*`), args[0])
+	assert.Equal(t, sql.Named("q2", `"за год"`), args[1])
+	assert.Equal(t, sql.Named("q6", "20241201"), args[5])
+	assert.Equal(t, `title : "отч"* OR "за год"`, q.rankQuery(dialectSQLite))
+
+	cond, args = q.sql(dialectPostgres)
+	assert.Contains(t, cond, `search @@ to_tsquery('simple', :q1)`)
+	assert.Equal(t, sql.Named("q1", `отч:*A`), args[0])
+	assert.Equal(t, sql.Named("q2", `за <-> год`), args[1])
+	assert.Equal(t, `(отч:*A) | (за <-> год)`, q.rankQuery(dialectPostgres))
+
+	cond, args = Query{}.sql(dialectSQLite)
+	assert.Equal(t, `1 = 0`, cond)
+	assert.Empty(t, args)
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SplitRepeatEnd() переносит условия окончания COUNT и UNTIL из правила RFC 5545
+// в поля RepeatCount и RepeatUntil: по полям хранилище завершает серию, а правило
+// пересчитывается от даты задачи при каждом выполнении и само серию не закончит.
+// Условие, уже заданное полем, главнее правила. Краткий синтаксис и правила
+// с некорректными COUNT или UNTIL не меняются
+func SplitRepeatEnd(task Task) Task {
+	rule := strings.TrimSpace(task.Repeat)
+	upper := strings.ToUpper(rule)
+	if !strings.HasPrefix(upper, "RRULE:") && !strings.HasPrefix(upper, "FREQ=") {
+		return task
+	}
+
+	prefix := ""
+	if strings.HasPrefix(upper, "RRULE:") {
+		prefix, rule = rule[:len("RRULE:")], rule[len("RRULE:"):]
+	}
+	var parts []string
+	until, count := "", 0
+	for _, part := range strings.Split(rule, ";") {
+		name, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(name) {
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return task
+			}
+			count = n
+		case "UNTIL":
+			if len(val) < 8 {
+				return task
+			}
+			if _, err := time.Parse("20060102", val[:8]); err != nil {
+				return task
+			}
+			until = val[:8]
+		default:
+			parts = append(parts, part)
+		}
+	}
+	if until == "" && count == 0 {
+		return task
+	}
+
+	task.Repeat = prefix + strings.Join(parts, ";")
+	if task.RepeatUntil == "" && task.RepeatCount == 0 {
+		task.RepeatUntil, task.RepeatCount = until, count
+	}
+	return task
+}
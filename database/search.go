@@ -0,0 +1,255 @@
+package database
+
+import (
+	"database/sql"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Порядок задач в результатах поиска. Задачи с одинаковым ключом сортировки
+// упорядочиваются по id, поэтому порядок всегда однозначен.
+const (
+	SortDate     = "date"
+	SortDateDesc = "date_desc"
+	SortTitle    = "title"
+	SortID       = "id"
+	// SortRelevance упорядочивает результаты полнотекстового поиска от более
+	// релевантных к менее; без текста поиска задачи упорядочиваются по дате.
+	SortRelevance = "relevance"
+)
+
+// RepeatTypes — типы правил повторения: первая буква краткого синтаксиса
+// и соответствующая частота правила RFC 5545.
+var RepeatTypes = map[string]string{
+	"d": "DAILY",
+	"w": "WEEKLY",
+	"m": "MONTHLY",
+	"y": "YEARLY",
+}
+
+// SearchFilter задаёт условия поиска задач. Пустые поля не ограничивают поиск,
+// пустой фильтр подходит всем задачам.
+//
+// Date — точная дата в формате 20060102, From и To — границы диапазона дат включительно,
+// Text — запрос поиска на языке ParseQuery(), Tags — метки, которые все должны быть
+// у задачи. Repeating отбирает повторяющиеся (true) или разовые (false) задачи,
+// RepeatType — задачи с правилом одного из RepeatTypes.
+// Sort — порядок результатов, по умолчанию SortDate. Если задан After, возвращаются
+// только задачи после этой позиции списка в том же порядке.
+type SearchFilter struct {
+	Date       string
+	From       string
+	To         string
+	Text       string
+	Tags       []string
+	Repeating  *bool
+	RepeatType string
+	Sort       string
+	After      *Cursor
+}
+
+// Cursor — позиция в упорядоченном списке задач: ключ сортировки
+// (дата или заголовок; для SortID пустой), ранг для SortRelevance
+// и id последней задачи предыдущей страницы.
+type Cursor struct {
+	Key  string
+	Rank float64
+	ID   int64
+}
+
+// CursorOf() возвращает позицию задачи task в списке с порядком sort.
+func CursorOf(task Task, sort string) Cursor {
+	id, _ := strconv.ParseInt(task.ID, 10, 64)
+	c := Cursor{Key: sortKey(task, sort), ID: id}
+	if sort == SortRelevance {
+		c.Rank = task.Rank
+	}
+	return c
+}
+
+// searchSort() возвращает порядок результатов фильтра с запросом q: по релевантности
+// можно упорядочить только результаты поиска по тексту.
+func searchSort(filter SearchFilter, q Query) string {
+	if filter.Sort == SortRelevance && !q.Ranked() {
+		return SortDate
+	}
+	return filter.Sort
+}
+
+// sortKey() возвращает значение, по которому задача упорядочивается при порядке sort.
+func sortKey(task Task, sort string) string {
+	switch sort {
+	case SortTitle:
+		return task.Title
+	case SortID, SortRelevance:
+		return ""
+	}
+	return task.Date
+}
+
+// buildSearchQuery() строит запрос задач пользователя user по фильтру и разобранному
+// filter.Text запросу q с именованными параметрами. Кроме taskColumns запрос
+// возвращает ранг задачи в полнотекстовом поиске (0 без текста поиска).
+func buildSearchQuery(dialect string, filter SearchFilter, q Query, user int64, limit int) (string, []interface{}) {
+	where := []string{`user_id = :user`}
+	args := []interface{}{sql.Named("user", user)}
+	add := func(cond string, named ...sql.NamedArg) {
+		where = append(where, cond)
+		for _, arg := range named {
+			args = append(args, arg)
+		}
+	}
+
+	if filter.Date != "" {
+		add(`date = :date`, sql.Named("date", filter.Date))
+	}
+	if filter.From != "" {
+		add(`date >= :from`, sql.Named("from", filter.From))
+	}
+	if filter.To != "" {
+		add(`date <= :to`, sql.Named("to", filter.To))
+	}
+	source, score := `scheduler`, `0`
+	if filter.Text != "" {
+		cond, named := q.sql(dialect)
+		add(cond, named...)
+	}
+	if rank := q.rankQuery(dialect); rank != "" {
+		if dialect == dialectPostgres {
+			source = `(SELECT scheduler.*, -ts_rank(search, to_tsquery('simple', :rank)) AS score FROM scheduler) AS scheduler`
+		} else {
+			// bm25() возвращает тем меньшее значение, чем релевантнее строка;
+			// задачи, подходящие запросу без текста, получают ранг 0
+			source = `(SELECT scheduler.*, COALESCE(m.score, 0) AS score FROM scheduler LEFT JOIN (
+				SELECT rowid AS fts_id, bm25(scheduler_fts, 10.0, 1.0) AS score
+				FROM scheduler_fts WHERE scheduler_fts MATCH :rank) AS m ON m.fts_id = scheduler.id) AS scheduler`
+		}
+		score = `score`
+		args = append(args, sql.Named("rank", rank))
+	}
+	if filter.Repeating != nil {
+		if *filter.Repeating {
+			add(`repeat <> ''`)
+		} else {
+			add(`repeat = ''`)
+		}
+	}
+	if _, ok := RepeatTypes[filter.RepeatType]; ok {
+		cond, named := repeatCondition(filter.RepeatType, "repeat")
+		add(cond, named...)
+	}
+	for i, tag := range filter.Tags {
+		name := "tag" + strconv.Itoa(i+1)
+		add(tagCondition(name), sql.Named(name, tag))
+	}
+
+	// Страница начинается после задачи курсора; порядок по ключу и id однозначен,
+	// поэтому задачи с одинаковым ключом не теряются и не повторяются между страницами
+	column, desc := sortColumn(searchSort(filter, q))
+	var order string
+	switch {
+	case column == `id`:
+		order = `id`
+	case desc:
+		order = column + ` DESC, id DESC`
+	default:
+		order = column + `, id`
+	}
+	if filter.After != nil {
+		switch {
+		case column == `id`:
+			add(`id > :after_id`, sql.Named("after_id", filter.After.ID))
+		case column == `score`:
+			add(`(score > :after_rank OR (score = :after_rank AND id > :after_id))`,
+				sql.Named("after_rank", filter.After.Rank), sql.Named("after_id", filter.After.ID))
+		case desc:
+			add(`(`+column+` < :after_key OR (`+column+` = :after_key AND id < :after_id))`,
+				sql.Named("after_key", filter.After.Key), sql.Named("after_id", filter.After.ID))
+		default:
+			add(`(`+column+` > :after_key OR (`+column+` = :after_key AND id > :after_id))`,
+				sql.Named("after_key", filter.After.Key), sql.Named("after_id", filter.After.ID))
+		}
+	}
+
+	query := `SELECT ` + taskColumns + `, ` + score + ` AS score FROM ` + source + ` WHERE ` + strings.Join(where, ` AND `) + ` ORDER BY ` + order
+	if limit > 0 {
+		query += ` LIMIT :limit`
+		args = append(args, sql.Named("limit", limit))
+	}
+	return query, args
+}
+
+// repeatCondition() возвращает условие SQL на тип правила повторения с параметрами,
+// имена которых начинаются с name. Правило в кратком синтаксисе начинается
+// с буквы типа, правило RFC 5545 содержит FREQ.
+func repeatCondition(repeatType, name string) (string, []sql.NamedArg) {
+	return `(repeat = :` + name + `_type OR repeat LIKE :` + name + `_prefix OR UPPER(repeat) LIKE :` + name + `_freq)`,
+		[]sql.NamedArg{
+			sql.Named(name+"_type", repeatType),
+			sql.Named(name+"_prefix", repeatType+" %"),
+			sql.Named(name+"_freq", "%FREQ="+RepeatTypes[repeatType]+"%"),
+		}
+}
+
+// tagCondition() возвращает условие SQL на метку задачи с именем из параметра name.
+func tagCondition(name string) string {
+	return `id IN (SELECT task_tags.task_id FROM task_tags JOIN tags ON tags.id = task_tags.tag_id WHERE tags.name = :` + name + `)`
+}
+
+// repeatMatches() проверяет правило повторения repeat на тип repeatType так же, как repeatCondition().
+func repeatMatches(repeat, repeatType string) bool {
+	return repeat == repeatType || strings.HasPrefix(repeat, repeatType+" ") ||
+		strings.Contains(strings.ToUpper(repeat), "FREQ="+RepeatTypes[repeatType])
+}
+
+// sortColumn() возвращает столбец сортировки для порядка sort и признак обратного порядка.
+func sortColumn(sort string) (column string, desc bool) {
+	switch sort {
+	case SortDateDesc:
+		return `date`, true
+	case SortTitle:
+		return `title`, false
+	case SortID:
+		return `id`, false
+	case SortRelevance:
+		return `score`, false
+	}
+	return `date`, false
+}
+
+// matchTask() проверяет задачу на соответствие фильтру так же, как buildSearchQuery().
+// Текст поиска и позицию After проверяет MemoryStore.Search(), которому нужен ранг задачи.
+func matchTask(filter SearchFilter, task Task) bool {
+	switch {
+	case filter.Date != "" && task.Date != filter.Date,
+		filter.From != "" && task.Date < filter.From,
+		filter.To != "" && task.Date > filter.To,
+		filter.Repeating != nil && *filter.Repeating != (task.Repeat != ""):
+		return false
+	}
+	if _, ok := RepeatTypes[filter.RepeatType]; ok && !repeatMatches(task.Repeat, filter.RepeatType) {
+		return false
+	}
+	for _, tag := range filter.Tags {
+		if !slices.Contains(task.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// taskBefore() сравнивает позиции a и b в списке с порядком sort: true, если a раньше b.
+func taskBefore(a, b Cursor, sort string) bool {
+	_, desc := sortColumn(sort)
+	if a.Rank != b.Rank {
+		return a.Rank < b.Rank
+	}
+	if a.Key != b.Key {
+		return (a.Key < b.Key) != desc
+	}
+	if a.ID == b.ID {
+		return false
+	}
+	return (a.ID < b.ID) != desc
+}
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// taskColumns — столбцы таблицы scheduler в порядке, ожидаемом scanTask().
+const taskColumns = `id, date, title, comment, repeat, repeat_until, repeat_count, uid, object_name`
+
+// SQLStore хранит задачи в таблице scheduler реляционной БД (SQLite или PostgreSQL).
+// Запросы пишутся с именованными параметрами вида :name и при необходимости
+// переводятся в синтаксис конкретной СУБД. Все запросы к задачам ограничены
+// задачами пользователя user.
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+	user    int64
+}
+
+// NewSQLiteStore() создаёт хранилище поверх открытого соединения с SQLite.
+func NewSQLiteStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: dialectSQLite, user: DefaultUserID}
+}
+
+// NewPostgresStore() создаёт хранилище поверх открытого соединения с PostgreSQL.
+func NewPostgresStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: dialectPostgres, user: DefaultUserID}
+}
+
+func (s *SQLStore) ForUser(userID int64) TaskStore {
+	return &SQLStore{db: s.db, dialect: s.dialect, user: userID}
+}
+
+// DB() возвращает соединение с базой данных.
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+// Dialect() возвращает диалект SQL хранилища: sqlite или postgres.
+func (s *SQLStore) Dialect() string {
+	return s.dialect
+}
+
+func (s *SQLStore) Get(id int64) (Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM scheduler WHERE id = :id AND user_id = :user`
+	task, err := scanTask(s.queryRow(query, sql.Named("id", id), sql.Named("user", s.user)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return task, ErrNotFound
+	}
+	if err != nil {
+		return task, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	tasks := []Task{task}
+	err = s.loadTags(tasks)
+	return tasks[0], err
+}
+
+func (s *SQLStore) GetByObjectName(name string) (Task, error) {
+	if name == "" {
+		return Task{}, ErrNotFound
+	}
+	query := `SELECT ` + taskColumns + ` FROM scheduler WHERE object_name = :name AND user_id = :user`
+	task, err := scanTask(s.queryRow(query, sql.Named("name", name), sql.Named("user", s.user)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return task, ErrNotFound
+	}
+	if err != nil {
+		return task, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	tasks := []Task{task}
+	err = s.loadTags(tasks)
+	return tasks[0], err
+}
+
+func (s *SQLStore) List(limit int) ([]Task, error) {
+	if limit <= 0 {
+		query := `SELECT ` + taskColumns + ` FROM scheduler WHERE user_id = :user ORDER BY date, id`
+		return s.queryTasks(query, sql.Named("user", s.user))
+	}
+	query := `SELECT ` + taskColumns + ` FROM scheduler WHERE user_id = :user ORDER BY date, id LIMIT :limit`
+	return s.queryTasks(query, sql.Named("limit", limit), sql.Named("user", s.user))
+}
+
+func (s *SQLStore) Search(filter SearchFilter, limit int) ([]Task, error) {
+	q, err := ParseQuery(filter.Text)
+	if err != nil {
+		return nil, err
+	}
+	query, args := buildSearchQuery(s.dialect, filter, q, s.user, limit)
+	tasks, err := s.scanTasks(scanRankedTask, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		tasks[i].Snippet = q.snippet(tasks[i])
+	}
+	return tasks, nil
+}
+
+func (s *SQLStore) ListUntil(to string) ([]Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM scheduler WHERE user_id = :user AND date <= :to ORDER BY date, id`
+	return s.queryTasks(query, sql.Named("to", to), sql.Named("user", s.user))
+}
+
+const insertTask = `INSERT INTO scheduler (date, title, comment, repeat, repeat_until, repeat_count, uid, object_name, user_id)
+	VALUES (:date, :title, :comment, :repeat, :repeat_until, :repeat_count, :uid, :object_name, :user) RETURNING id`
+
+// insertArgs() возвращает именованные параметры запроса insertTask.
+func (s *SQLStore) insertArgs(task Task) []interface{} {
+	return []interface{}{
+		sql.Named("user", s.user),
+		sql.Named("date", task.Date),
+		sql.Named("title", task.Title),
+		sql.Named("comment", task.Comment),
+		sql.Named("repeat", task.Repeat),
+		sql.Named("repeat_until", task.RepeatUntil),
+		sql.Named("repeat_count", task.RepeatCount),
+		sql.Named("uid", task.UID),
+		sql.Named("object_name", task.ObjectName),
+	}
+}
+
+func (s *SQLStore) Create(task Task) (int64, error) {
+	ids, err := s.CreateMany([]Task{task})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (s *SQLStore) CreateMany(tasks []Task) ([]int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer tx.Rollback()
+
+	ids := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		task = SplitRepeatEnd(task)
+		query, args := s.bind(insertTask, s.insertArgs(task))
+		var id int64
+		if err := tx.QueryRow(query, args...).Scan(&id); err != nil {
+			return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		if len(task.Tags) > 0 {
+			if err := s.setTaskTags(tx, id, task.Tags); err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return ids, nil
+}
+
+func (s *SQLStore) Update(task Task) error {
+	task = SplitRepeatEnd(task)
+	query := `UPDATE scheduler SET date = :date, title = :title, comment = :comment, repeat = :repeat,
+		repeat_until = :repeat_until, repeat_count = :repeat_count WHERE id = :id AND user_id = :user`
+	args := []interface{}{
+		sql.Named("date", task.Date),
+		sql.Named("title", task.Title),
+		sql.Named("comment", task.Comment),
+		sql.Named("repeat", task.Repeat),
+		sql.Named("repeat_until", task.RepeatUntil),
+		sql.Named("repeat_count", task.RepeatCount),
+		sql.Named("id", task.ID),
+		sql.Named("user", s.user),
+	}
+	if task.Tags == nil {
+		return s.execOne(query, args...)
+	}
+	id, err := strconv.ParseInt(task.ID, 10, 64)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args = s.bind(query, args)
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+	if err := s.setTaskTags(tx, id, task.Tags); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) Delete(id int64) error {
+	query := `DELETE FROM scheduler WHERE id = :id AND user_id = :user`
+	return s.execOne(query, sql.Named("id", id), sql.Named("user", s.user))
+}
+
+func (s *SQLStore) Complete(id int64, nextDate string) error {
+	if nextDate == "" {
+		return s.Delete(id)
+	}
+	query := `UPDATE scheduler SET date = :date,
+		repeat_count = CASE WHEN repeat_count > 0 THEN repeat_count - 1 ELSE 0 END WHERE id = :id AND user_id = :user`
+	return s.execOne(query, sql.Named("id", id), sql.Named("date", nextDate), sql.Named("user", s.user))
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// bind() переводит именованные параметры запроса в синтаксис диалекта хранилища.
+func (s *SQLStore) bind(query string, args []interface{}) (string, []interface{}) {
+	if s.dialect == dialectPostgres {
+		return rebindNamed(query, args)
+	}
+	return query, args
+}
+
+// queryRow() выполняет запрос, возвращающий не более одной строки.
+func (s *SQLStore) queryRow(query string, args ...interface{}) *sql.Row {
+	query, args = s.bind(query, args)
+	return s.db.QueryRow(query, args...)
+}
+
+// queryTasks() выполняет запрос и читает из результата список задач.
+func (s *SQLStore) queryTasks(query string, args ...interface{}) ([]Task, error) {
+	return s.scanTasks(scanTask, query, args...)
+}
+
+// scanTasks() выполняет запрос и читает каждую строку результата функцией scan.
+func (s *SQLStore) scanTasks(scan func(scanner) (Task, error), query string, args ...interface{}) ([]Task, error) {
+	query, args = s.bind(query, args)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	rows.Close()
+	return tasks, s.loadTags(tasks)
+}
+
+// scanner — строка результата запроса: *sql.Row или *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTask() читает задачу из строки результата со столбцами taskColumns.
+func scanTask(row scanner) (Task, error) {
+	var t Task
+	err := row.Scan(&t.ID, &t.Date, &t.Title, &t.Comment, &t.Repeat, &t.RepeatUntil, &t.RepeatCount, &t.UID, &t.ObjectName)
+	return t, err
+}
+
+// scanRankedTask() читает задачу из строки со столбцами taskColumns и рангом
+// полнотекстового поиска.
+func scanRankedTask(row scanner) (Task, error) {
+	var t Task
+	err := row.Scan(&t.ID, &t.Date, &t.Title, &t.Comment, &t.Repeat, &t.RepeatUntil, &t.RepeatCount, &t.UID, &t.ObjectName, &t.Rank)
+	return t, err
+}
+
+// execOne() выполняет изменяющий запрос и возвращает ErrNotFound, если ни одна строка не затронута.
+func (s *SQLStore) execOne(query string, args ...interface{}) error {
+	query, args = s.bind(query, args)
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
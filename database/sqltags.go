@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func (s *SQLStore) ListTags() ([]Tag, error) {
+	query, args := s.bind(`SELECT tags.id, tags.name, COUNT(task_tags.task_id) FROM tags
+		LEFT JOIN task_tags ON task_tags.tag_id = tags.id
+		WHERE tags.user_id = :user GROUP BY tags.id, tags.name ORDER BY tags.name`,
+		[]interface{}{sql.Named("user", s.user)})
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Tasks); err != nil {
+			return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return tags, nil
+}
+
+func (s *SQLStore) CreateTag(name string) (int64, error) {
+	var id int64
+	err := s.queryRow(`INSERT INTO tags (user_id, name) VALUES (:user, :name) RETURNING id`,
+		sql.Named("user", s.user), sql.Named("name", name)).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, ErrTagExists
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return id, nil
+}
+
+func (s *SQLStore) RenameTag(id int64, name string) error {
+	err := s.execOne(`UPDATE tags SET name = :name WHERE id = :id AND user_id = :user`,
+		sql.Named("name", name), sql.Named("id", id), sql.Named("user", s.user))
+	switch {
+	case isUniqueViolation(err):
+		return ErrTagExists
+	case errors.Is(err, ErrNotFound):
+		return ErrTagNotFound
+	}
+	return err
+}
+
+func (s *SQLStore) MergeTags(from, to int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer tx.Rollback()
+
+	var found int
+	query, args := s.bind(`SELECT COUNT(*) FROM tags WHERE user_id = :user AND id IN (:from, :to)`,
+		[]interface{}{sql.Named("user", s.user), sql.Named("from", from), sql.Named("to", to)})
+	if err := tx.QueryRow(query, args...).Scan(&found); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	if found == 0 || found == 1 && from != to {
+		return ErrTagNotFound
+	}
+	if from == to {
+		return nil
+	}
+
+	for _, query := range []string{
+		`INSERT INTO task_tags (task_id, tag_id) SELECT task_id, :to FROM task_tags
+			WHERE tag_id = :from AND task_id NOT IN (SELECT task_id FROM task_tags WHERE tag_id = :to)`,
+		`DELETE FROM task_tags WHERE tag_id = :from`,
+		`DELETE FROM tags WHERE id = :from`,
+	} {
+		query, args := s.bind(query, []interface{}{sql.Named("from", from), sql.Named("to", to)})
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteTag(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args := s.bind(`DELETE FROM task_tags WHERE tag_id IN (SELECT id FROM tags WHERE id = :id AND user_id = :user)`,
+		[]interface{}{sql.Named("id", id), sql.Named("user", s.user)})
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	query, args = s.bind(`DELETE FROM tags WHERE id = :id AND user_id = :user`,
+		[]interface{}{sql.Named("id", id), sql.Named("user", s.user)})
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrTagNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+// setTaskTags() заменяет метки задачи taskID в транзакции tx. Метки,
+// которых ещё нет у пользователя, добавляются.
+func (s *SQLStore) setTaskTags(tx *sql.Tx, taskID int64, names []string) error {
+	exec := func(query string, args ...interface{}) error {
+		query, args = s.bind(query, args)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		return nil
+	}
+
+	if err := exec(`DELETE FROM task_tags WHERE task_id = :task`, sql.Named("task", taskID)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		args := []interface{}{sql.Named("user", s.user), sql.Named("name", name), sql.Named("task", taskID)}
+		if err := exec(`INSERT INTO tags (user_id, name) VALUES (:user, :name) ON CONFLICT (user_id, name) DO NOTHING`, args[:2]...); err != nil {
+			return err
+		}
+		if err := exec(`INSERT INTO task_tags (task_id, tag_id) SELECT :task, id FROM tags
+			WHERE user_id = :user AND name = :name ON CONFLICT DO NOTHING`, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Сколько задач loadTags() читает одним запросом: число параметров запроса ограничено
+const tagsBatch = 500
+
+// loadTags() заполняет метки задач tasks. Читаются только метки этих задач.
+func (s *SQLStore) loadTags(tasks []Task) error {
+	for from := 0; from < len(tasks); from += tagsBatch {
+		if err := s.loadTagsBatch(tasks[from:min(from+tagsBatch, len(tasks))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) loadTagsBatch(tasks []Task) error {
+	args := []interface{}{sql.Named("user", s.user)}
+	params := make([]string, len(tasks))
+	for i, task := range tasks {
+		name := "t" + strconv.Itoa(i)
+		params[i] = ":" + name
+		args = append(args, sql.Named(name, task.ID))
+	}
+	query, args := s.bind(`SELECT task_tags.task_id, tags.name FROM task_tags JOIN tags ON tags.id = task_tags.tag_id
+		WHERE tags.user_id = :user AND task_tags.task_id IN (`+strings.Join(params, ", ")+`) ORDER BY tags.name`, args)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var taskID int64
+		var name string
+		if err := rows.Scan(&taskID, &name); err != nil {
+			return fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		id := strconv.FormatInt(taskID, 10)
+		tags[id] = append(tags[id], name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	for i := range tasks {
+		tasks[i].Tags = tags[tasks[i].ID]
+		if tasks[i].Tags == nil {
+			tasks[i].Tags = []string{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,448 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// userColumns — столбцы таблицы users в порядке, ожидаемом scanUser().
+const userColumns = `id, login, password_hash, admin, role, token_version, totp_secret, totp_enabled`
+
+func (s *SQLStore) CreateUser(user User) (int64, error) {
+	if user.Role == "" {
+		user.Role = RoleEditor
+	}
+	query := `INSERT INTO users (login, password_hash, admin, role) VALUES (:login, :hash, :admin, :role) RETURNING id`
+	var id int64
+	err := s.queryRow(query,
+		sql.Named("login", user.Login),
+		sql.Named("hash", user.PasswordHash),
+		sql.Named("admin", user.Admin),
+		sql.Named("role", user.Role),
+	).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, ErrUserExists
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return id, nil
+}
+
+func (s *SQLStore) GetUser(id int64) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = :id`
+	return s.queryUser(query, sql.Named("id", id))
+}
+
+func (s *SQLStore) GetUserByLogin(login string) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE login = :login`
+	return s.queryUser(query, sql.Named("login", login))
+}
+
+func (s *SQLStore) ListUsers() ([]User, error) {
+	query, _ := s.bind(`SELECT `+userColumns+` FROM users ORDER BY id`, nil)
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return users, nil
+}
+
+func (s *SQLStore) SetPassword(id int64, hash string) error {
+	query := `UPDATE users SET password_hash = :hash, token_version = token_version + 1 WHERE id = :id`
+	err := s.execOne(query, sql.Named("hash", hash), sql.Named("id", id))
+	if errors.Is(err, ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (s *SQLStore) SetRole(id int64, role string, admin bool) error {
+	query := `UPDATE users SET role = :role, admin = :admin, token_version = token_version + 1 WHERE id = :id`
+	err := s.execOne(query, sql.Named("role", role), sql.Named("admin", admin), sql.Named("id", id))
+	if errors.Is(err, ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (s *SQLStore) DeleteUser(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"scheduler", "tags", "api_tokens", "recovery_codes", "oidc_identities"} {
+		query, args := s.bind(`DELETE FROM `+table+` WHERE user_id = :id`, []interface{}{sql.Named("id", id)})
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+	}
+	query, args := s.bind(`DELETE FROM users WHERE id = :id`, []interface{}{sql.Named("id", id)})
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) RevokeToken(jti string, expiresAt time.Time) error {
+	now := time.Now().Unix()
+	query, args := s.bind(`DELETE FROM revoked_tokens WHERE expires_at < :now`, []interface{}{sql.Named("now", now)})
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+
+	query, args = s.bind(`INSERT INTO revoked_tokens (jti, expires_at) VALUES (:jti, :expires)
+		ON CONFLICT (jti) DO NOTHING`, []interface{}{sql.Named("jti", jti), sql.Named("expires", expiresAt.Unix())})
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) IsTokenRevoked(jti string) (bool, error) {
+	var n int
+	err := s.queryRow(`SELECT count(*) FROM revoked_tokens WHERE jti = :jti`, sql.Named("jti", jti)).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (s *SQLStore) AddSigningKey(key SigningKey) error {
+	// Время хранится в наносекундах, чтобы порядок ключей, созданных подряд, был однозначным
+	var retired int64
+	if !key.RetiredAt.IsZero() {
+		retired = key.RetiredAt.UnixNano()
+	}
+	query, args := s.bind(`INSERT INTO signing_keys (kid, algorithm, key_data, created_at, retired_at)
+		VALUES (:kid, :alg, :data, :created, :retired)`, []interface{}{
+		sql.Named("kid", key.KID),
+		sql.Named("alg", key.Algorithm),
+		sql.Named("data", key.Data),
+		sql.Named("created", key.CreatedAt.UnixNano()),
+		sql.Named("retired", retired),
+	})
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) SigningKeys() ([]SigningKey, error) {
+	query, _ := s.bind(`SELECT kid, algorithm, key_data, created_at, retired_at
+		FROM signing_keys ORDER BY created_at DESC`, nil)
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var (
+			k                SigningKey
+			created, retired int64
+		)
+		if err := rows.Scan(&k.KID, &k.Algorithm, &k.Data, &created, &retired); err != nil {
+			return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		k.CreatedAt = time.Unix(0, created)
+		if retired != 0 {
+			k.RetiredAt = time.Unix(0, retired)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *SQLStore) RetireSigningKey(kid string) error {
+	query := `UPDATE signing_keys SET retired_at = :now WHERE kid = :kid`
+	err := s.execOne(query, sql.Named("now", time.Now().UnixNano()), sql.Named("kid", kid))
+	if errors.Is(err, ErrNotFound) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+func (s *SQLStore) LogSignIn(attempt SignInAttempt) error {
+	cutoff := time.Now().Add(-SignInLogRetention).Unix()
+	query, args := s.bind(`DELETE FROM signin_attempts WHERE created_at < :cutoff`, []interface{}{sql.Named("cutoff", cutoff)})
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+
+	query, args = s.bind(`INSERT INTO signin_attempts (login, ip, success, reason, created_at)
+		VALUES (:login, :ip, :success, :reason, :created)`, []interface{}{
+		sql.Named("login", attempt.Login),
+		sql.Named("ip", attempt.IP),
+		sql.Named("success", attempt.Success),
+		sql.Named("reason", attempt.Reason),
+		sql.Named("created", attempt.Time.Unix()),
+	})
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) ListSignIns(limit int) ([]SignInAttempt, error) {
+	query, args := s.bind(`SELECT id, login, ip, success, reason, created_at FROM signin_attempts
+		ORDER BY id DESC LIMIT :limit`, []interface{}{sql.Named("limit", limit)})
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []SignInAttempt{}
+	for rows.Next() {
+		var (
+			a       SignInAttempt
+			created int64
+		)
+		if err := rows.Scan(&a.ID, &a.Login, &a.IP, &a.Success, &a.Reason, &created); err != nil {
+			return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		a.Time = time.Unix(created, 0)
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return attempts, nil
+}
+
+func (s *SQLStore) SetTOTP(userID int64, secret string, enabled bool) error {
+	query := `UPDATE users SET totp_secret = :secret, totp_enabled = :enabled WHERE id = :id`
+	err := s.execOne(query, sql.Named("secret", secret), sql.Named("enabled", enabled), sql.Named("id", userID))
+	if errors.Is(err, ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (s *SQLStore) UseTOTPStep(userID int64, step int64) (bool, error) {
+	// Интервал отмечается условным обновлением, поэтому один код
+	// не принимается дважды и при одновременных запросах
+	query := `UPDATE users SET totp_last_step = :step WHERE id = :id AND totp_last_step < :step`
+	err := s.execOne(query, sql.Named("step", step), sql.Named("id", userID))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SQLStore) SetRecoveryCodes(userID int64, hashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args := s.bind(`DELETE FROM recovery_codes WHERE user_id = :user`, []interface{}{sql.Named("user", userID)})
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	for _, h := range hashes {
+		query, args := s.bind(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (:user, :hash)`,
+			[]interface{}{sql.Named("user", userID), sql.Named("hash", h)})
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) UseRecoveryCode(userID int64, hash string) (bool, error) {
+	query := `DELETE FROM recovery_codes WHERE user_id = :user AND code_hash = :hash`
+	err := s.execOne(query, sql.Named("user", userID), sql.Named("hash", hash))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SQLStore) CountRecoveryCodes(userID int64) (int, error) {
+	var n int
+	err := s.queryRow(`SELECT count(*) FROM recovery_codes WHERE user_id = :user`, sql.Named("user", userID)).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return n, nil
+}
+
+func (s *SQLStore) GetUserByIdentity(issuer, subject string) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = (SELECT user_id FROM oidc_identities WHERE issuer = :issuer AND subject = :subject)`
+	return s.queryUser(query, sql.Named("issuer", issuer), sql.Named("subject", subject))
+}
+
+func (s *SQLStore) LinkIdentity(issuer, subject string, userID int64) error {
+	query, args := s.bind(`INSERT INTO oidc_identities (issuer, subject, user_id) VALUES (:issuer, :subject, :user)`,
+		[]interface{}{sql.Named("issuer", issuer), sql.Named("subject", subject), sql.Named("user", userID)})
+	_, err := s.db.Exec(query, args...)
+	if isUniqueViolation(err) {
+		return ErrIdentityExists
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return nil
+}
+
+// apiTokenColumns — столбцы таблицы api_tokens в порядке, ожидаемом scanAPIToken().
+const apiTokenColumns = `id, user_id, name, token_hash, prefix, scopes, created_at, last_used_at`
+
+func (s *SQLStore) CreateAPIToken(token APIToken) (int64, error) {
+	query := `INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at)
+		VALUES (:user, :name, :hash, :prefix, :scopes, :created) RETURNING id`
+	var id int64
+	err := s.queryRow(query,
+		sql.Named("user", token.UserID),
+		sql.Named("name", token.Name),
+		sql.Named("hash", token.Hash),
+		sql.Named("prefix", token.Prefix),
+		sql.Named("scopes", strings.Join(token.Scopes, ",")),
+		sql.Named("created", token.CreatedAt.Unix()),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return id, nil
+}
+
+func (s *SQLStore) GetAPITokenByHash(hash string) (APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE token_hash = :hash`
+	token, err := scanAPIToken(s.queryRow(query, sql.Named("hash", hash)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return token, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return token, nil
+}
+
+func (s *SQLStore) ListAPITokens(userID int64) ([]APIToken, error) {
+	query, args := s.bind(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = :user ORDER BY id`,
+		[]interface{}{sql.Named("user", userID)})
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *SQLStore) DeleteAPIToken(userID, id int64) error {
+	query := `DELETE FROM api_tokens WHERE id = :id AND user_id = :user`
+	err := s.execOne(query, sql.Named("id", id), sql.Named("user", userID))
+	if errors.Is(err, ErrNotFound) {
+		return ErrAPITokenNotFound
+	}
+	return err
+}
+
+func (s *SQLStore) TouchAPIToken(id int64, at time.Time) error {
+	query := `UPDATE api_tokens SET last_used_at = :at WHERE id = :id`
+	err := s.execOne(query, sql.Named("at", at.Unix()), sql.Named("id", id))
+	if errors.Is(err, ErrNotFound) {
+		return ErrAPITokenNotFound
+	}
+	return err
+}
+
+// scanAPIToken() читает API-токен из строки результата со столбцами apiTokenColumns.
+func scanAPIToken(row scanner) (APIToken, error) {
+	var (
+		t                 APIToken
+		scopes            string
+		created, lastUsed int64
+	)
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Hash, &t.Prefix, &scopes, &created, &lastUsed)
+	if err != nil {
+		return t, err
+	}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	t.CreatedAt = time.Unix(created, 0)
+	if lastUsed != 0 {
+		t.LastUsedAt = time.Unix(lastUsed, 0)
+	}
+	return t, nil
+}
+
+// queryUser() выполняет запрос, возвращающий не более одного пользователя.
+func (s *SQLStore) queryUser(query string, args ...interface{}) (User, error) {
+	user, err := scanUser(s.queryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrUserNotFound
+	}
+	if err != nil {
+		return user, fmt.Errorf("ошибка работы с БД: %w", err)
+	}
+	return user, nil
+}
+
+// scanUser() читает пользователя из строки результата со столбцами userColumns.
+func scanUser(row scanner) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Login, &u.PasswordHash, &u.Admin, &u.Role, &u.TokenVersion, &u.TOTPSecret, &u.TOTPEnabled)
+	return u, err
+}
+
+// isUniqueViolation() проверяет, нарушено ли ограничение уникальности.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,296 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound возвращается хранилищем, если задача с указанным id не существует.
+var ErrNotFound = errors.New("задача не найдена")
+
+// ErrUserNotFound возвращается хранилищем, если пользователь не существует.
+var ErrUserNotFound = errors.New("пользователь не найден")
+
+// ErrUserExists возвращается при добавлении пользователя с занятым логином.
+var ErrUserExists = errors.New("пользователь с таким логином уже существует")
+
+// ErrAPITokenNotFound возвращается хранилищем, если API-токен не существует.
+var ErrAPITokenNotFound = errors.New("API-токен не найден")
+
+// ErrIdentityExists возвращается хранилищем, если учётная запись поставщика
+// OpenID Connect уже связана с пользователем.
+var ErrIdentityExists = errors.New("учётная запись OpenID Connect уже связана с пользователем")
+
+// ErrTagNotFound возвращается хранилищем, если метка с указанным id не существует.
+var ErrTagNotFound = errors.New("метка не найдена")
+
+// ErrTagExists возвращается при добавлении или переименовании метки в занятое имя.
+var ErrTagExists = errors.New("метка с таким именем уже существует")
+
+// ErrKeyNotFound возвращается хранилищем, если ключ подписи с указанным kid не существует.
+var ErrKeyNotFound = errors.New("ключ подписи не найден")
+
+// DefaultUserID — id администратора, создаваемого миграцией. Ему принадлежат задачи,
+// добавленные до появления учётных записей, и от его имени работает планировщик
+// без пароля. Корневое хранилище, возвращаемое конструкторами, работает с его задачами.
+const DefaultUserID int64 = 1
+
+// Роли пользователей: читатель только просматривает задачи, редактор также изменяет их.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+)
+
+// User описывает учётную запись планировщика. PasswordHash — хеш bcrypt,
+// пустой хеш означает, что войти под пользователем нельзя. Role — роль
+// пользователя, пустая роль при добавлении означает RoleEditor. TokenVersion
+// записывается в выданные токены и увеличивается при смене пароля и прав, отзывая их все.
+//
+// TOTPSecret — секрет второго фактора в base32. Пока TOTPEnabled не установлен,
+// секрет ожидает подтверждения кодом и при входе не проверяется.
+type User struct {
+	ID           int64  `json:"id"`
+	Login        string `json:"login"`
+	PasswordHash string `json:"-"`
+	Admin        bool   `json:"admin"`
+	Role         string `json:"role"`
+	TokenVersion int    `json:"-"`
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+}
+
+// SigningKey описывает ключ подписи токенов. Data — секрет HS256 в base64
+// или закрытый ключ EdDSA и RS256 в PEM (PKCS #8). Токены подписываются самым
+// новым действующим ключом, остальные действующие ключи только проверяют выданные
+// ранее токены. Выведенный из оборота ключ (RetiredAt не нулевое) токены не проверяет.
+type SigningKey struct {
+	KID       string
+	Algorithm string
+	Data      string
+	CreatedAt time.Time
+	RetiredAt time.Time
+}
+
+// APIToken описывает именованный API-токен пользователя для скриптов и CI.
+// Сам токен не хранится: Hash — его хеш SHA-256, Prefix — начало токена,
+// по которому пользователь узнаёт его в списке. Scopes — области действия токена.
+// Нулевое LastUsedAt означает, что токен ещё не использовался.
+type APIToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Hash       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// SignInAttempt — запись журнала попыток входа. Reason объясняет неудачу:
+// неверный логин или пароль либо превышение числа попыток.
+type SignInAttempt struct {
+	ID      int64     `json:"id"`
+	Login   string    `json:"login"`
+	IP      string    `json:"ip"`
+	Success bool      `json:"success"`
+	Reason  string    `json:"reason,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// SignInLogRetention — сколько хранятся записи журнала попыток входа.
+const SignInLogRetention = 90 * 24 * time.Hour
+
+// Task описывает задачу планировщика.
+//
+// Для повторяющихся задач можно задать условия окончания серии:
+// RepeatUntil — последняя допустимая дата в формате 20060102,
+// RepeatCount — сколько выполнений осталось, включая текущее (0 — без ограничения).
+//
+// UID и ObjectName задают клиенты CalDAV при создании задачи: UID записи календаря
+// и имя ресурса в коллекции. Для остальных задач они пустые и вычисляются по id.
+//
+// Tags — имена меток задачи, упорядоченные по имени. При изменении задачи
+// nil оставляет метки прежними, пустой список снимает все метки.
+//
+// Snippet и Rank заполняет только полнотекстовый поиск: фрагмент текста с найденными
+// словами, выделенными <mark>, и релевантность (чем меньше, тем выше задача в результатах).
+type Task struct {
+	ID          string   `json:"id"`
+	Date        string   `json:"date"`
+	Title       string   `json:"title"`
+	Comment     string   `json:"comment"`
+	Repeat      string   `json:"repeat"`
+	RepeatUntil string   `json:"repeat_until,omitempty"`
+	RepeatCount int      `json:"repeat_count,omitempty"`
+	UID         string   `json:"uid,omitempty"`
+	ObjectName  string   `json:"-"`
+	Tags        []string `json:"tags"`
+	Snippet     string   `json:"snippet,omitempty"`
+	Rank        float64  `json:"-"`
+}
+
+// Tag — метка задач пользователя. Tasks — число задач с меткой.
+type Tag struct {
+	ID    int64  `json:"id,string"`
+	Name  string `json:"name"`
+	Tasks int    `json:"tasks"`
+}
+
+// TaskStore — хранилище задач планировщика.
+type TaskStore interface {
+	// Get() возвращает задачу по id или ErrNotFound.
+	Get(id int64) (Task, error)
+	// GetByObjectName() возвращает задачу по имени ресурса CalDAV или ErrNotFound.
+	GetByObjectName(name string) (Task, error)
+	// List() возвращает не более limit задач, упорядоченных по дате,
+	// или все задачи, если limit не больше нуля.
+	List(limit int) ([]Task, error)
+	// Search() возвращает не более limit задач, подходящих под фильтр,
+	// в порядке filter.Sort, или все такие задачи, если limit не больше нуля.
+	Search(filter SearchFilter, limit int) ([]Task, error)
+	// ListUntil() возвращает все задачи с датой не позже to, упорядоченные по дате.
+	ListUntil(to string) ([]Task, error)
+	// Create() добавляет задачу и возвращает её id. Метки задачи, которых
+	// ещё нет у пользователя, добавляются.
+	Create(task Task) (int64, error)
+	// CreateMany() добавляет задачи в одной транзакции и возвращает их id
+	// в том же порядке. При ошибке не добавляется ни одна задача.
+	CreateMany(tasks []Task) ([]int64, error)
+	// Update() изменяет задачу с id task.ID или возвращает ErrNotFound.
+	// UID и ObjectName задаются только при создании и не изменяются,
+	// метки заменяются, если task.Tags не nil.
+	Update(task Task) error
+	// Delete() удаляет задачу по id или возвращает ErrNotFound.
+	Delete(id int64) error
+	// Complete() отмечает задачу выполненной: переносит её на nextDate и уменьшает
+	// счётчик оставшихся повторений, а если nextDate пустая — удаляет.
+	Complete(id int64, nextDate string) error
+	// ForUser() возвращает хранилище, в котором видны и создаются только задачи
+	// и метки пользователя userID. Хранилища разделяют соединение с БД.
+	ForUser(userID int64) TaskStore
+	// Close() освобождает ресурсы хранилища.
+	Close() error
+	TagStore
+}
+
+// TagStore — метки задач пользователя. Изменения меток сразу видны во всех задачах.
+type TagStore interface {
+	// ListTags() возвращает метки, упорядоченные по имени, с числом задач.
+	ListTags() ([]Tag, error)
+	// CreateTag() добавляет метку и возвращает её id или ErrTagExists.
+	CreateTag(name string) (int64, error)
+	// RenameTag() переименовывает метку или возвращает ErrTagNotFound либо ErrTagExists.
+	RenameTag(id int64, name string) error
+	// MergeTags() переносит задачи метки from на метку to и удаляет from
+	// в одной транзакции или возвращает ErrTagNotFound.
+	MergeTags(from, to int64) error
+	// DeleteTag() снимает метку со всех задач и удаляет её или возвращает ErrTagNotFound.
+	DeleteTag(id int64) error
+}
+
+// UserStore — хранилище учётных записей.
+type UserStore interface {
+	// CreateUser() добавляет пользователя и возвращает его id или ErrUserExists.
+	CreateUser(user User) (int64, error)
+	// GetUser() возвращает пользователя по id или ErrUserNotFound.
+	GetUser(id int64) (User, error)
+	// GetUserByLogin() возвращает пользователя по логину или ErrUserNotFound.
+	GetUserByLogin(login string) (User, error)
+	// ListUsers() возвращает всех пользователей, упорядоченных по id.
+	ListUsers() ([]User, error)
+	// SetPassword() заменяет хеш пароля пользователя и увеличивает TokenVersion
+	// или возвращает ErrUserNotFound.
+	SetPassword(id int64, hash string) error
+	// SetRole() изменяет роль и права администратора пользователя, увеличивает
+	// TokenVersion или возвращает ErrUserNotFound.
+	SetRole(id int64, role string, admin bool) error
+	// DeleteUser() удаляет пользователя вместе с его задачами, метками, API-токенами,
+	// кодами восстановления и связями OpenID Connect или возвращает ErrUserNotFound.
+	DeleteUser(id int64) error
+}
+
+// TokenStore — список отозванных токенов.
+type TokenStore interface {
+	// RevokeToken() отзывает токен с идентификатором jti до expiresAt, когда
+	// он истечёт сам. Записи об уже истёкших токенах при этом удаляются.
+	RevokeToken(jti string, expiresAt time.Time) error
+	// IsTokenRevoked() проверяет, отозван ли токен с идентификатором jti.
+	IsTokenRevoked(jti string) (bool, error)
+}
+
+// KeyStore — ключи подписи токенов.
+type KeyStore interface {
+	// AddSigningKey() сохраняет ключ подписи.
+	AddSigningKey(key SigningKey) error
+	// SigningKeys() возвращает все ключи подписи, от новых к старым.
+	SigningKeys() ([]SigningKey, error)
+	// RetireSigningKey() выводит ключ из оборота или возвращает ErrKeyNotFound.
+	RetireSigningKey(kid string) error
+}
+
+// AuditStore — журнал попыток входа.
+type AuditStore interface {
+	// LogSignIn() записывает попытку входа. Записи старше SignInLogRetention при этом удаляются.
+	LogSignIn(attempt SignInAttempt) error
+	// ListSignIns() возвращает не более limit последних попыток входа, от новых к старым.
+	ListSignIns(limit int) ([]SignInAttempt, error)
+}
+
+// APITokenStore — API-токены пользователей.
+type APITokenStore interface {
+	// CreateAPIToken() сохраняет API-токен и возвращает его id.
+	CreateAPIToken(token APIToken) (int64, error)
+	// GetAPITokenByHash() возвращает API-токен по хешу или ErrAPITokenNotFound.
+	GetAPITokenByHash(hash string) (APIToken, error)
+	// ListAPITokens() возвращает API-токены пользователя, упорядоченные по id.
+	ListAPITokens(userID int64) ([]APIToken, error)
+	// DeleteAPIToken() удаляет API-токен пользователя или возвращает ErrAPITokenNotFound.
+	DeleteAPIToken(userID, id int64) error
+	// TouchAPIToken() запоминает время последнего использования API-токена.
+	TouchAPIToken(id int64, at time.Time) error
+}
+
+// TwoFactorStore — второй фактор аутентификации пользователей.
+type TwoFactorStore interface {
+	// SetTOTP() задаёт секрет TOTP и признак его подтверждения или возвращает
+	// ErrUserNotFound. Пустой секрет отключает второй фактор.
+	SetTOTP(userID int64, secret string, enabled bool) error
+	// UseTOTPStep() отмечает интервал step использованным и возвращает false,
+	// если код этого или более позднего интервала уже принимался.
+	UseTOTPStep(userID int64, step int64) (bool, error)
+	// SetRecoveryCodes() заменяет хеши кодов восстановления пользователя.
+	SetRecoveryCodes(userID int64, hashes []string) error
+	// UseRecoveryCode() удаляет код восстановления с хешем hash и возвращает false,
+	// если такого кода нет.
+	UseRecoveryCode(userID int64, hash string) (bool, error)
+	// CountRecoveryCodes() возвращает число неиспользованных кодов восстановления.
+	CountRecoveryCodes(userID int64) (int, error)
+}
+
+// IdentityStore — связь учётных записей внешнего поставщика OpenID Connect
+// с пользователями планировщика.
+type IdentityStore interface {
+	// GetUserByIdentity() возвращает пользователя, связанного с субъектом subject
+	// поставщика issuer, или ErrUserNotFound.
+	GetUserByIdentity(issuer, subject string) (User, error)
+	// LinkIdentity() связывает субъекта subject поставщика issuer с пользователем
+	// или возвращает ErrIdentityExists, если субъект уже связан.
+	LinkIdentity(issuer, subject string, userID int64) error
+}
+
+// AccountStore объединяет хранилища, необходимые для аутентификации.
+type AccountStore interface {
+	UserStore
+	TokenStore
+	KeyStore
+	AuditStore
+	APITokenStore
+	TwoFactorStore
+	IdentityStore
+}
+
+// Store объединяет хранилища задач и учётных записей одной БД.
+type Store interface {
+	TaskStore
+	AccountStore
+}
@@ -0,0 +1,876 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeFactories перечисляет реализации TaskStore, на которых прогоняется общий набор тестов.
+var storeFactories = map[string]func(t *testing.T) TaskStore{
+	"memory": func(t *testing.T) TaskStore {
+		return NewMemoryStore()
+	},
+	"sqlite": func(t *testing.T) TaskStore {
+		db := openTestDB(t)
+		_, err := MigrateUp(db, dialectSQLite)
+		require.NoError(t, err)
+		return NewSQLiteStore(db)
+	},
+	"postgres": openTestPostgres,
+}
+
+// openTestPostgres() подключается к PostgreSQL из TODO_TEST_POSTGRES_DSN, очищает схему public
+// и применяет миграции. Без переменной окружения тест пропускается.
+// Внимание: все данные в указанной базе будут удалены.
+func openTestPostgres(t *testing.T) TaskStore {
+	dsn := os.Getenv("TODO_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TODO_TEST_POSTGRES_DSN не задана")
+	}
+	db, err := sql.Open(dialectPostgres, dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public;`)
+	require.NoError(t, err)
+	_, err = MigrateUp(db, dialectPostgres)
+	require.NoError(t, err)
+	return NewPostgresStore(db)
+}
+
+func runStoreTests(t *testing.T, test func(t *testing.T, s TaskStore)) {
+	for name, factory := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			test(t, factory(t))
+		})
+	}
+}
+
+func TestRebindNamed(t *testing.T) {
+	query, args := rebindNamed(
+		`SELECT id FROM scheduler WHERE title LIKE :search OR comment LIKE :search AND date = ':date'::text LIMIT :limit`,
+		[]interface{}{sql.Named("limit", 50), sql.Named("search", "%УК%")},
+	)
+	assert.Equal(t, `SELECT id FROM scheduler WHERE title LIKE $1 OR comment LIKE $1 AND date = ':date'::text LIMIT $2`, query)
+	assert.Equal(t, []interface{}{"%УК%", 50}, args)
+}
+
+func TestStoreCRUD(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		id, err := s.Create(Task{Date: "20240126", Title: "Созвон", Comment: "в 16:00", Repeat: "d 5"})
+		require.NoError(t, err)
+
+		task, err := s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, strconv.FormatInt(id, 10), task.ID)
+		assert.Equal(t, "Созвон", task.Title)
+		assert.Equal(t, "d 5", task.Repeat)
+
+		task.Title = "Созвон перенесён"
+		require.NoError(t, s.Update(task))
+		task, err = s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, "Созвон перенесён", task.Title)
+
+		require.NoError(t, s.Delete(id))
+		_, err = s.Get(id)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, s.Delete(id), ErrNotFound)
+		assert.ErrorIs(t, s.Update(Task{ID: "abc", Title: "Тест"}), ErrNotFound)
+	})
+}
+
+func TestStoreListAndSearch(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		for _, task := range []Task{
+			{Date: "20240203", Title: "Позвонить в УК", Comment: "Горячая вода"},
+			{Date: "20240201", Title: "Бассейн"},
+			{Date: "20240203", Title: "Встреча", Comment: "с УК"},
+		} {
+			_, err := s.Create(task)
+			require.NoError(t, err)
+		}
+
+		tasks, err := s.List(10)
+		require.NoError(t, err)
+		require.Len(t, tasks, 3)
+		assert.Equal(t, "Бассейн", tasks[0].Title)
+
+		tasks, err = s.List(2)
+		require.NoError(t, err)
+		assert.Len(t, tasks, 2)
+
+		tasks, err = s.List(0)
+		require.NoError(t, err)
+		assert.Len(t, tasks, 3)
+
+		tasks, err = s.Search(SearchFilter{Text: "УК"}, 10)
+		require.NoError(t, err)
+		assert.Len(t, tasks, 2)
+
+		tasks, err = s.Search(SearchFilter{Date: "20240203"}, 10)
+		require.NoError(t, err)
+		assert.Len(t, tasks, 2)
+
+		tasks, err = s.Search(SearchFilter{Text: "нет такой"}, 10)
+		require.NoError(t, err)
+		assert.NotNil(t, tasks)
+		assert.Empty(t, tasks)
+
+		tasks, err = s.ListUntil("20240202")
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		assert.Equal(t, "Бассейн", tasks[0].Title)
+
+		tasks, err = s.ListUntil("20240203")
+		require.NoError(t, err)
+		require.Len(t, tasks, 3)
+		assert.Equal(t, "Позвонить в УК", tasks[1].Title)
+	})
+}
+
+func TestStoreSearchAfter(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		// Пять задач, у трёх из них одна дата
+		var ids []int64
+		for _, date := range []string{"20240203", "20240201", "20240203", "20240205", "20240203"} {
+			id, err := s.Create(Task{Date: date, Title: "Задача " + date})
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+		want := []int64{ids[1], ids[0], ids[2], ids[4], ids[3]}
+
+		var got []int64
+		filter := SearchFilter{}
+		for page := 0; page < 5; page++ {
+			tasks, err := s.Search(filter, 2)
+			require.NoError(t, err)
+			if len(tasks) == 0 {
+				break
+			}
+			for _, task := range tasks {
+				id, err := strconv.ParseInt(task.ID, 10, 64)
+				require.NoError(t, err)
+				got = append(got, id)
+			}
+			last := tasks[len(tasks)-1]
+			filter.After = &Cursor{Key: last.Date, ID: got[len(got)-1]}
+		}
+		assert.Equal(t, want, got)
+
+		// Курсор сочетается с условиями поиска
+		tasks, err := s.Search(SearchFilter{Date: "20240203", After: &Cursor{Key: "20240203", ID: ids[0]}}, 0)
+		require.NoError(t, err)
+		require.Len(t, tasks, 2)
+		assert.Equal(t, strconv.FormatInt(ids[2], 10), tasks[0].ID)
+	})
+}
+
+func TestStoreSearchFilter(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		for _, task := range []Task{
+			{Date: "20240201", Title: "Бассейн", Repeat: "w 1,3"},
+			{Date: "20240203", Title: "Анализы"},
+			{Date: "20240205", Title: "Оплатить связь", Repeat: "m 5"},
+			{Date: "20240207", Title: "Вынести мусор", Repeat: "d 2"},
+			{Date: "20240209", Title: "Отчёт", Repeat: "FREQ=MONTHLY;BYDAY=-1FR"},
+			{Date: "20240211", Title: "День рождения", Repeat: "y"},
+		} {
+			_, err := s.Create(task)
+			require.NoError(t, err)
+		}
+		yes, no := true, false
+
+		titles := func(filter SearchFilter) []string {
+			t.Helper()
+			tasks, err := s.Search(filter, 0)
+			require.NoError(t, err)
+			var titles []string
+			for _, task := range tasks {
+				titles = append(titles, task.Title)
+			}
+			return titles
+		}
+
+		assert.Equal(t, []string{"Анализы", "Оплатить связь", "Вынести мусор"}, titles(SearchFilter{From: "20240202", To: "20240207"}))
+		assert.Equal(t, []string{"Анализы"}, titles(SearchFilter{Repeating: &no}))
+		assert.Len(t, titles(SearchFilter{Repeating: &yes}), 5)
+		assert.Equal(t, []string{"Оплатить связь", "Отчёт"}, titles(SearchFilter{RepeatType: "m"}))
+		assert.Equal(t, []string{"День рождения"}, titles(SearchFilter{RepeatType: "y"}))
+		assert.Equal(t, []string{"Бассейн"}, titles(SearchFilter{RepeatType: "w", To: "20240205"}))
+		assert.Equal(t, []string{"Отчёт", "Оплатить связь"}, titles(SearchFilter{RepeatType: "m", Sort: SortDateDesc}))
+		assert.Equal(t, []string{"Анализы", "Бассейн", "Вынести мусор", "День рождения", "Оплатить связь", "Отчёт"},
+			titles(SearchFilter{Sort: SortTitle}))
+		assert.Equal(t, []string{"Оплатить связь"}, titles(SearchFilter{Text: "связь", Repeating: &yes, From: "20240205"}))
+
+		// Курсор продолжает список в выбранном порядке
+		tasks, err := s.Search(SearchFilter{Sort: SortTitle}, 2)
+		require.NoError(t, err)
+		after := CursorOf(tasks[1], SortTitle)
+		assert.Equal(t, []string{"Вынести мусор", "День рождения"}, titles(SearchFilter{Sort: SortTitle, After: &after})[:2])
+		tasks, err = s.Search(SearchFilter{Sort: SortDateDesc}, 2)
+		require.NoError(t, err)
+		after = CursorOf(tasks[1], SortDateDesc)
+		assert.Equal(t, []string{"Вынести мусор", "Оплатить связь", "Анализы", "Бассейн"}, titles(SearchFilter{Sort: SortDateDesc, After: &after}))
+		after = CursorOf(tasks[0], SortID)
+		assert.Len(t, titles(SearchFilter{Sort: SortID, After: &after}), 0)
+	})
+}
+
+func TestStoreFullText(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		for _, task := range []Task{
+			{Date: "20240201", Title: "Ёлка", Comment: "купить игрушки и гирлянду"},
+			{Date: "20240202", Title: "Отчёт", Comment: "квартальный отчёт для <бухгалтерии>"},
+			{Date: "20240203", Title: "Позвонить в бухгалтерию", Comment: "спросить про отчёт"},
+			{Date: "20240204", Title: "Встреча", Comment: "обсудить годовой отчёт с командой"},
+		} {
+			_, err := s.Create(task)
+			require.NoError(t, err)
+		}
+
+		titles := func(text string) []string {
+			t.Helper()
+			tasks, err := s.Search(SearchFilter{Text: text, Sort: SortRelevance}, 0)
+			require.NoError(t, err)
+			titles := []string{}
+			for _, task := range tasks {
+				titles = append(titles, task.Title)
+			}
+			return titles
+		}
+
+		// Регистр и «ё» не различаются, слово ищется как префикс
+		assert.Equal(t, []string{"Ёлка"}, titles("ЕЛКА"))
+		assert.Equal(t, []string{"Ёлка"}, titles("ёлк"))
+		assert.Equal(t, []string{"Позвонить в бухгалтерию", "Отчёт"}, titles("бухгалт"))
+		// Совпадение в заголовке важнее совпадения в комментарии
+		assert.Equal(t, "Отчёт", titles("отчет")[0])
+		assert.Len(t, titles("отчет"), 3)
+		// Фраза ищется целиком, слова — в любом месте задачи
+		assert.Equal(t, []string{"Встреча"}, titles(`"годовой отчёт"`))
+		assert.Empty(t, titles(`"отчёт годовой"`))
+		assert.Equal(t, []string{"Позвонить в бухгалтерию"}, titles("отчёт позвонить"))
+		assert.Empty(t, titles("%"))
+
+		tasks, err := s.Search(SearchFilter{Text: "бухгалтерии"}, 0)
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		assert.Equal(t, "квартальный отчёт для &lt;<mark>бухгалтерии</mark>&gt;", tasks[0].Snippet)
+
+		// Индекс следует за изменением и удалением задач
+		id, err := strconv.ParseInt(tasks[0].ID, 10, 64)
+		require.NoError(t, err)
+		task := tasks[0]
+		task.Comment = "квартальный"
+		require.NoError(t, s.Update(task))
+		assert.Empty(t, titles("бухгалтерии"))
+		assert.Equal(t, []string{"Отчёт"}, titles("квартал"))
+		require.NoError(t, s.Delete(id))
+		assert.Empty(t, titles("квартал"))
+
+		// Курсор продолжает результаты в порядке релевантности
+		tasks, err = s.Search(SearchFilter{Text: "отчет", Sort: SortRelevance}, 1)
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		after := CursorOf(tasks[0], SortRelevance)
+		next, err := s.Search(SearchFilter{Text: "отчет", Sort: SortRelevance, After: &after}, 0)
+		require.NoError(t, err)
+		assert.Len(t, next, 1)
+		assert.NotEqual(t, tasks[0].ID, next[0].ID)
+	})
+}
+
+func TestHighlight(t *testing.T) {
+	terms := []searchTerm{{words: []string{"отч"}, prefix: true}, {words: []string{"за", "неделю"}}}
+	assert.Equal(t, "<mark>Отчёт</mark> <mark>за</mark> <mark>неделю</mark>", highlight(terms, "Отчёт за неделю"))
+	assert.Equal(t, "…три четыре пять <mark>отчёт</mark> семь восемь девять десять одиннадцать двенадцать тринадцать четырнадцать…",
+		highlight(terms, "один два три четыре пять отчёт семь восемь девять десять одиннадцать двенадцать тринадцать четырнадцать пятнадцать"))
+	assert.Empty(t, highlight(terms, "Бассейн"))
+}
+
+func TestStoreQuery(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		for _, task := range []Task{
+			{Date: "20241115", Title: "Отчёт по продажам", Comment: "черновик", Repeat: "w 5"},
+			{Date: "20241122", Title: "Отчёт для склада", Comment: "итоговый", Repeat: "w 5"},
+			{Date: "20241210", Title: "Отчёт за год", Repeat: "FREQ=YEARLY"},
+			{Date: "20241120", Title: "Позвонить в банк", Comment: "про отчёт"},
+			{Date: "20241125", Title: "Купить молоко"},
+		} {
+			_, err := s.Create(task)
+			require.NoError(t, err)
+		}
+
+		titles := func(text string) []string {
+			t.Helper()
+			tasks, err := s.Search(SearchFilter{Text: text}, 0)
+			require.NoError(t, err)
+			titles := []string{}
+			for _, task := range tasks {
+				titles = append(titles, task.Title)
+			}
+			return titles
+		}
+
+		assert.Equal(t, []string{"Отчёт для склада"}, titles("title:отчёт repeat:w before:01.12.2024 -comment:черновик"))
+		assert.Equal(t, []string{"Отчёт по продажам", "Отчёт для склада", "Отчёт за год"}, titles("title:отчет"))
+		assert.Equal(t, []string{"Позвонить в банк"}, titles("comment:отчёт"))
+		assert.Equal(t, []string{"Отчёт по продажам", "Отчёт для склада", "Отчёт за год"}, titles("-repeat:none"))
+		assert.Equal(t, []string{"Отчёт за год"}, titles("repeat:y"))
+		assert.Equal(t, []string{"Позвонить в банк", "Купить молоко"}, titles(`repeat:none OR title:"за год"`)[:2])
+		assert.Len(t, titles(`repeat:none OR title:"за год"`), 3)
+		assert.Equal(t, []string{"Отчёт для склада", "Купить молоко"}, titles("after:20.11.2024 date<=25.11.2024"))
+		assert.Equal(t, []string{"Позвонить в банк"}, titles("date:20.11.2024"))
+		assert.Equal(t, []string{"Купить молоко", "Отчёт за год"}, titles("молоко OR date>=01.12.2024"))
+		assert.Empty(t, titles(`title:"год за"`))
+
+		_, err := s.Search(SearchFilter{Text: "title:"}, 0)
+		var qe *QueryError
+		assert.ErrorAs(t, err, &qe)
+	})
+}
+
+func TestStoreTags(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		report, err := s.Create(Task{Date: "20240201", Title: "Отчёт", Tags: []string{"работа", "срочно"}})
+		require.NoError(t, err)
+		call, err := s.Create(Task{Date: "20240202", Title: "Созвон", Tags: []string{"работа"}})
+		require.NoError(t, err)
+		cleaning, err := s.Create(Task{Date: "20240203", Title: "Уборка", Tags: []string{"дом"}})
+		require.NoError(t, err)
+
+		task, err := s.Get(report)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"работа", "срочно"}, task.Tags)
+
+		tags, err := s.ListTags()
+		require.NoError(t, err)
+		require.Len(t, tags, 3)
+		ids := map[string]int64{}
+		for _, tag := range tags {
+			ids[tag.Name] = tag.ID
+		}
+		assert.Equal(t, []Tag{{ids["дом"], "дом", 1}, {ids["работа"], "работа", 2}, {ids["срочно"], "срочно", 1}}, tags)
+
+		titles := func(filter SearchFilter) []string {
+			t.Helper()
+			tasks, err := s.Search(filter, 0)
+			require.NoError(t, err)
+			titles := []string{}
+			for _, task := range tasks {
+				titles = append(titles, task.Title)
+			}
+			return titles
+		}
+		assert.Equal(t, []string{"Отчёт", "Созвон"}, titles(SearchFilter{Tags: []string{"работа"}}))
+		assert.Equal(t, []string{"Отчёт"}, titles(SearchFilter{Tags: []string{"работа", "срочно"}}))
+		assert.Equal(t, []string{"Созвон", "Уборка"}, titles(SearchFilter{Text: "-tag:срочно"}))
+
+		// nil оставляет метки, пустой список снимает их
+		task.Title = "Квартальный отчёт"
+		task.Tags = nil
+		require.NoError(t, s.Update(task))
+		task, err = s.Get(report)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"работа", "срочно"}, task.Tags)
+		task.Tags = []string{"важно"}
+		require.NoError(t, s.Update(task))
+		task, err = s.Get(report)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"важно"}, task.Tags)
+
+		_, err = s.CreateTag("дом")
+		assert.ErrorIs(t, err, ErrTagExists)
+		assert.ErrorIs(t, s.RenameTag(ids["дом"], "работа"), ErrTagExists)
+		assert.ErrorIs(t, s.RenameTag(404, "дача"), ErrTagNotFound)
+		require.NoError(t, s.RenameTag(ids["дом"], "дача"))
+		task, err = s.Get(cleaning)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"дача"}, task.Tags)
+
+		// Объединение переносит задачи и не дублирует метку у задач, где есть обе
+		require.NoError(t, s.Update(Task{ID: strconv.FormatInt(call, 10), Date: "20240202", Title: "Созвон", Tags: []string{"работа", "дача"}}))
+		assert.ErrorIs(t, s.MergeTags(ids["дом"], 404), ErrTagNotFound)
+		require.NoError(t, s.MergeTags(ids["дом"], ids["работа"]))
+		assert.Equal(t, []string{"Созвон", "Уборка"}, titles(SearchFilter{Tags: []string{"работа"}}))
+		task, err = s.Get(call)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"работа"}, task.Tags)
+
+		require.NoError(t, s.DeleteTag(ids["работа"]))
+		assert.ErrorIs(t, s.DeleteTag(ids["работа"]), ErrTagNotFound)
+		task, err = s.Get(cleaning)
+		require.NoError(t, err)
+		assert.Equal(t, []string{}, task.Tags)
+
+		// Метки видны только своему пользователю
+		other := s.ForUser(DefaultUserID + 1)
+		tags, err = other.ListTags()
+		require.NoError(t, err)
+		assert.Empty(t, tags)
+		assert.ErrorIs(t, other.DeleteTag(ids["срочно"]), ErrTagNotFound)
+
+		// Метки длинного списка читаются частями
+		many := make([]Task, tagsBatch+1)
+		for i := range many {
+			many[i] = Task{Date: "20250101", Title: "Пачка"}
+		}
+		many[tagsBatch].Tags = []string{"последняя"}
+		_, err = other.CreateMany(many)
+		require.NoError(t, err)
+		tasks, err := other.List(0)
+		require.NoError(t, err)
+		require.Len(t, tasks, tagsBatch+1)
+		assert.Equal(t, []string{}, tasks[0].Tags)
+		assert.Equal(t, []string{"последняя"}, tasks[tagsBatch].Tags)
+	})
+}
+
+func TestStoreComplete(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		id, err := s.Create(Task{Date: "20240126", Title: "Повтор", Repeat: "d 3"})
+		require.NoError(t, err)
+
+		require.NoError(t, s.Complete(id, "20240129"))
+		task, err := s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, "20240129", task.Date)
+
+		require.NoError(t, s.Complete(id, ""))
+		_, err = s.Get(id)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, s.Complete(id, "20240201"), ErrNotFound)
+
+		id, err = s.Create(Task{Date: "20240126", Title: "Три раза", Repeat: "d 1", RepeatUntil: "20240301", RepeatCount: 3})
+		require.NoError(t, err)
+		require.NoError(t, s.Complete(id, "20240127"))
+		task, err = s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, "20240127", task.Date)
+		assert.Equal(t, "20240301", task.RepeatUntil)
+		assert.Equal(t, 2, task.RepeatCount)
+	})
+}
+
+func TestStoreRepeatEnd(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		id, err := s.Create(Task{Date: "20240126", Title: "Дважды", Repeat: "RRULE:FREQ=DAILY;COUNT=2;INTERVAL=2"})
+		require.NoError(t, err)
+		task, err := s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, "RRULE:FREQ=DAILY;INTERVAL=2", task.Repeat)
+		assert.Equal(t, 2, task.RepeatCount)
+
+		task.Repeat = "FREQ=WEEKLY;UNTIL=20240301T235959Z"
+		task.RepeatCount = 0
+		require.NoError(t, s.Update(task))
+		task, err = s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, "FREQ=WEEKLY", task.Repeat)
+		assert.Equal(t, "20240301", task.RepeatUntil)
+		assert.Zero(t, task.RepeatCount)
+
+		// Условие из поля главнее правила, краткий синтаксис не меняется
+		ids, err := s.CreateMany([]Task{
+			{Date: "20240126", Title: "Поле", Repeat: "FREQ=DAILY;COUNT=5", RepeatCount: 3},
+			{Date: "20240126", Title: "Кратко", Repeat: "d 1"},
+		})
+		require.NoError(t, err)
+		task, err = s.Get(ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, "FREQ=DAILY", task.Repeat)
+		assert.Equal(t, 3, task.RepeatCount)
+		task, err = s.Get(ids[1])
+		require.NoError(t, err)
+		assert.Equal(t, "d 1", task.Repeat)
+		assert.Zero(t, task.RepeatCount)
+	})
+}
+
+func TestStoreCreateMany(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		ids, err := s.CreateMany([]Task{
+			{Date: "20240201", Title: "Первая"},
+			{Date: "20240202", Title: "Вторая", Repeat: "d 1", RepeatCount: 3},
+		})
+		require.NoError(t, err)
+		require.Len(t, ids, 2)
+
+		task, err := s.Get(ids[1])
+		require.NoError(t, err)
+		assert.Equal(t, "Вторая", task.Title)
+		assert.Equal(t, 3, task.RepeatCount)
+
+		ids, err = s.CreateMany(nil)
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+	})
+}
+
+func TestSQLiteCreateManyRollback(t *testing.T) {
+	db := openTestDB(t)
+	_, err := MigrateUp(db, dialectSQLite)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TRIGGER reject_task BEFORE INSERT ON scheduler
+		WHEN NEW.title = 'Ошибка' BEGIN SELECT RAISE(ABORT, 'задача отклонена'); END`)
+	require.NoError(t, err)
+	s := NewSQLiteStore(db)
+
+	_, err = s.CreateMany([]Task{
+		{Date: "20240201", Title: "Первая"},
+		{Date: "20240202", Title: "Ошибка"},
+	})
+	require.Error(t, err)
+
+	tasks, err := s.List(0)
+	require.NoError(t, err)
+	assert.Empty(t, tasks, "при ошибке транзакция должна откатываться целиком")
+}
+
+func TestStoreObjectName(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s TaskStore) {
+		_, err := s.Create(Task{Date: "20240201", Title: "Из API"})
+		require.NoError(t, err)
+		id, err := s.Create(Task{Date: "20240202", Title: "С телефона", UID: "abc@phone", ObjectName: "abc.ics"})
+		require.NoError(t, err)
+
+		task, err := s.GetByObjectName("abc.ics")
+		require.NoError(t, err)
+		assert.Equal(t, strconv.FormatInt(id, 10), task.ID)
+		assert.Equal(t, "abc@phone", task.UID)
+
+		_, err = s.GetByObjectName("")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = s.GetByObjectName("nope.ics")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		// Обновление через API не затирает UID и имя ресурса
+		require.NoError(t, s.Update(Task{ID: task.ID, Date: "20240203", Title: "Изменена"}))
+		task, err = s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, "Изменена", task.Title)
+		assert.Equal(t, "abc@phone", task.UID)
+		assert.Equal(t, "abc.ics", task.ObjectName)
+	})
+}
+
+// userStoreFactories перечисляет реализации Store для тестов учётных записей.
+var userStoreFactories = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return NewMemoryStore()
+	},
+	"sqlite": func(t *testing.T) Store {
+		db := openTestDB(t)
+		_, err := MigrateUp(db, dialectSQLite)
+		require.NoError(t, err)
+		return NewSQLiteStore(db)
+	},
+	"postgres": func(t *testing.T) Store {
+		return openTestPostgres(t).(Store)
+	},
+}
+
+func runUserStoreTests(t *testing.T, test func(t *testing.T, s Store)) {
+	for name, factory := range userStoreFactories {
+		t.Run(name, func(t *testing.T) {
+			test(t, factory(t))
+		})
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		admin, err := s.GetUser(DefaultUserID)
+		require.NoError(t, err)
+		assert.Equal(t, "admin", admin.Login)
+		assert.True(t, admin.Admin)
+		assert.Equal(t, RoleEditor, admin.Role)
+		assert.Empty(t, admin.PasswordHash)
+
+		id, err := s.CreateUser(User{Login: "ivan", PasswordHash: "hash"})
+		require.NoError(t, err)
+		assert.NotEqual(t, DefaultUserID, id)
+		_, err = s.CreateUser(User{Login: "ivan"})
+		assert.ErrorIs(t, err, ErrUserExists)
+
+		user, err := s.GetUserByLogin("ivan")
+		require.NoError(t, err)
+		assert.Equal(t, id, user.ID)
+		assert.Equal(t, "hash", user.PasswordHash)
+		assert.False(t, user.Admin)
+		_, err = s.GetUserByLogin("nobody")
+		assert.ErrorIs(t, err, ErrUserNotFound)
+
+		require.NoError(t, s.SetPassword(id, "new"))
+		user, err = s.GetUser(id)
+		require.NoError(t, err)
+		assert.Equal(t, "new", user.PasswordHash)
+		assert.Equal(t, 1, user.TokenVersion, "смена пароля отзывает выданные токены")
+		assert.ErrorIs(t, s.SetPassword(999, "x"), ErrUserNotFound)
+
+		assert.Equal(t, RoleEditor, user.Role, "по умолчанию пользователь — редактор")
+		require.NoError(t, s.SetRole(id, RoleViewer, true))
+		user, err = s.GetUser(id)
+		require.NoError(t, err)
+		assert.Equal(t, RoleViewer, user.Role)
+		assert.True(t, user.Admin)
+		assert.Equal(t, 2, user.TokenVersion, "смена прав отзывает выданные токены")
+		assert.ErrorIs(t, s.SetRole(999, RoleViewer, false), ErrUserNotFound)
+		viewer, err := s.CreateUser(User{Login: "petr", Role: RoleViewer})
+		require.NoError(t, err)
+		user, err = s.GetUser(viewer)
+		require.NoError(t, err)
+		assert.Equal(t, RoleViewer, user.Role)
+		require.NoError(t, s.DeleteUser(viewer))
+
+		users, err := s.ListUsers()
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		assert.Equal(t, DefaultUserID, users[0].ID)
+
+		_, err = s.ForUser(id).Create(Task{Date: "20240201", Title: "Задача Ивана"})
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteUser(id))
+		_, err = s.GetUser(id)
+		assert.ErrorIs(t, err, ErrUserNotFound)
+		tasks, err := s.ForUser(id).List(0)
+		require.NoError(t, err)
+		assert.Empty(t, tasks, "задачи удалённого пользователя удаляются")
+		assert.ErrorIs(t, s.DeleteUser(id), ErrUserNotFound)
+	})
+}
+
+func TestStoreForUser(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		otherID, err := s.CreateUser(User{Login: "petr"})
+		require.NoError(t, err)
+		other := s.ForUser(otherID)
+
+		mine, err := s.Create(Task{Date: "20240201", Title: "Моя", ObjectName: "mine.ics"})
+		require.NoError(t, err)
+		theirs, err := other.Create(Task{Date: "20240201", Title: "Чужая", ObjectName: "theirs.ics"})
+		require.NoError(t, err)
+
+		tasks, err := s.List(0)
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		assert.Equal(t, "Моя", tasks[0].Title)
+		tasks, err = other.Search(SearchFilter{Date: "20240201"}, 10)
+		require.NoError(t, err)
+		require.Len(t, tasks, 1)
+		assert.Equal(t, "Чужая", tasks[0].Title)
+		tasks, err = s.Search(SearchFilter{Text: "Чуж"}, 10)
+		require.NoError(t, err)
+		assert.Empty(t, tasks)
+		tasks, err = other.ListUntil("20240201")
+		require.NoError(t, err)
+		assert.Len(t, tasks, 1)
+
+		// Чужие задачи недоступны по id и имени ресурса
+		_, err = s.Get(theirs)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = s.GetByObjectName("theirs.ics")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, s.Update(Task{ID: strconv.FormatInt(theirs, 10), Date: "20240202", Title: "Взлом"}), ErrNotFound)
+		assert.ErrorIs(t, s.Complete(theirs, "20240202"), ErrNotFound)
+		assert.ErrorIs(t, s.Delete(theirs), ErrNotFound)
+		assert.ErrorIs(t, other.Delete(mine), ErrNotFound)
+
+		task, err := other.Get(theirs)
+		require.NoError(t, err)
+		assert.Equal(t, "Чужая", task.Title)
+		assert.Equal(t, "20240201", task.Date)
+	})
+}
+
+func TestStoreRevokedTokens(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		revoked, err := s.IsTokenRevoked("abc")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		require.NoError(t, s.RevokeToken("abc", time.Now().Add(time.Hour)))
+		require.NoError(t, s.RevokeToken("abc", time.Now().Add(time.Hour)), "повторный отзыв не ошибка")
+		require.NoError(t, s.RevokeToken("old", time.Now().Add(-time.Hour)))
+		revoked, err = s.IsTokenRevoked("abc")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		// Истёкшие записи удаляются при следующем отзыве
+		require.NoError(t, s.RevokeToken("def", time.Now().Add(time.Hour)))
+		revoked, err = s.IsTokenRevoked("old")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+}
+
+func TestStoreSigningKeys(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		keys, err := s.SigningKeys()
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+
+		created := time.Now()
+		require.NoError(t, s.AddSigningKey(SigningKey{KID: "old", Algorithm: "HS256", Data: "c2VjcmV0", CreatedAt: created}))
+		require.NoError(t, s.AddSigningKey(SigningKey{KID: "new", Algorithm: "EdDSA", Data: "pem", CreatedAt: created.Add(time.Nanosecond)}))
+
+		keys, err = s.SigningKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, "new", keys[0].KID, "новые ключи первыми")
+		assert.Equal(t, "EdDSA", keys[0].Algorithm)
+		assert.Equal(t, "pem", keys[0].Data)
+		assert.True(t, keys[1].CreatedAt.Equal(created))
+		assert.True(t, keys[1].RetiredAt.IsZero())
+
+		require.NoError(t, s.RetireSigningKey("old"))
+		assert.ErrorIs(t, s.RetireSigningKey("nobody"), ErrKeyNotFound)
+		keys, err = s.SigningKeys()
+		require.NoError(t, err)
+		assert.False(t, keys[1].RetiredAt.IsZero())
+		assert.True(t, keys[0].RetiredAt.IsZero())
+	})
+}
+
+func TestStoreSignInLog(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		now := time.Now()
+		require.NoError(t, s.LogSignIn(SignInAttempt{Login: "old", IP: "192.0.2.1", Time: now.Add(-SignInLogRetention - time.Hour)}))
+		require.NoError(t, s.LogSignIn(SignInAttempt{Login: "ivan", IP: "192.0.2.1", Reason: "bad_credentials", Time: now}))
+		require.NoError(t, s.LogSignIn(SignInAttempt{Login: "ivan", IP: "192.0.2.1", Success: true, Time: now}))
+
+		attempts, err := s.ListSignIns(10)
+		require.NoError(t, err)
+		require.Len(t, attempts, 2, "старые записи удаляются")
+		assert.True(t, attempts[0].Success, "новые записи первыми")
+		assert.Equal(t, "ivan", attempts[1].Login)
+		assert.Equal(t, "192.0.2.1", attempts[1].IP)
+		assert.Equal(t, "bad_credentials", attempts[1].Reason)
+		assert.Equal(t, now.Unix(), attempts[1].Time.Unix())
+
+		attempts, err = s.ListSignIns(1)
+		require.NoError(t, err)
+		assert.Len(t, attempts, 1)
+	})
+}
+
+func TestStoreAPITokens(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		ivan, err := s.CreateUser(User{Login: "ivan"})
+		require.NoError(t, err)
+		created := time.Unix(time.Now().Unix(), 0)
+		id, err := s.CreateAPIToken(APIToken{UserID: ivan, Name: "CI", Hash: "abc", Prefix: "sch_abcd", Scopes: []string{"read"}, CreatedAt: created})
+		require.NoError(t, err)
+		_, err = s.CreateAPIToken(APIToken{UserID: DefaultUserID, Name: "admin", Hash: "def", Prefix: "sch_defg", Scopes: []string{"read", "write"}, CreatedAt: created})
+		require.NoError(t, err)
+
+		token, err := s.GetAPITokenByHash("abc")
+		require.NoError(t, err)
+		assert.Equal(t, id, token.ID)
+		assert.Equal(t, ivan, token.UserID)
+		assert.Equal(t, "CI", token.Name)
+		assert.Equal(t, "sch_abcd", token.Prefix)
+		assert.Equal(t, []string{"read"}, token.Scopes)
+		assert.True(t, token.CreatedAt.Equal(created))
+		assert.True(t, token.LastUsedAt.IsZero())
+		_, err = s.GetAPITokenByHash("nope")
+		assert.ErrorIs(t, err, ErrAPITokenNotFound)
+
+		used := created.Add(time.Hour)
+		require.NoError(t, s.TouchAPIToken(id, used))
+		tokens, err := s.ListAPITokens(ivan)
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+		assert.True(t, tokens[0].LastUsedAt.Equal(used))
+
+		// Чужой токен удалить нельзя, токены удаляются вместе с пользователем
+		assert.ErrorIs(t, s.DeleteAPIToken(DefaultUserID, id), ErrAPITokenNotFound)
+		require.NoError(t, s.DeleteUser(ivan))
+		_, err = s.GetAPITokenByHash("abc")
+		assert.ErrorIs(t, err, ErrAPITokenNotFound)
+
+		tokens, err = s.ListAPITokens(DefaultUserID)
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+		require.NoError(t, s.DeleteAPIToken(DefaultUserID, tokens[0].ID))
+		assert.ErrorIs(t, s.DeleteAPIToken(DefaultUserID, tokens[0].ID), ErrAPITokenNotFound)
+	})
+}
+
+func TestStoreTwoFactor(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		ivan, err := s.CreateUser(User{Login: "ivan"})
+		require.NoError(t, err)
+
+		require.NoError(t, s.SetTOTP(ivan, "SECRET", true))
+		user, err := s.GetUser(ivan)
+		require.NoError(t, err)
+		assert.Equal(t, "SECRET", user.TOTPSecret)
+		assert.True(t, user.TOTPEnabled)
+		assert.ErrorIs(t, s.SetTOTP(ivan+100, "SECRET", true), ErrUserNotFound)
+
+		// Интервал принимается один раз, более ранние интервалы — нет
+		for _, tc := range []struct {
+			step int64
+			ok   bool
+		}{{100, true}, {100, false}, {99, false}, {101, true}} {
+			ok, err := s.UseTOTPStep(ivan, tc.step)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ok, ok, tc.step)
+		}
+
+		require.NoError(t, s.SetRecoveryCodes(ivan, []string{"a", "b", "c"}))
+		require.NoError(t, s.SetRecoveryCodes(ivan, []string{"d", "e"}))
+		n, err := s.CountRecoveryCodes(ivan)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		ok, err := s.UseRecoveryCode(ivan, "a")
+		require.NoError(t, err)
+		assert.False(t, ok, "старые коды заменены")
+		ok, err = s.UseRecoveryCode(DefaultUserID, "d")
+		require.NoError(t, err)
+		assert.False(t, ok, "чужой код")
+		ok, err = s.UseRecoveryCode(ivan, "d")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = s.UseRecoveryCode(ivan, "d")
+		require.NoError(t, err)
+		assert.False(t, ok, "код одноразовый")
+
+		require.NoError(t, s.DeleteUser(ivan))
+		n, err = s.CountRecoveryCodes(ivan)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+}
+
+func TestStoreIdentities(t *testing.T) {
+	runUserStoreTests(t, func(t *testing.T, s Store) {
+		ivan, err := s.CreateUser(User{Login: "ivan"})
+		require.NoError(t, err)
+
+		_, err = s.GetUserByIdentity("https://idp.example", "42")
+		assert.ErrorIs(t, err, ErrUserNotFound)
+		require.NoError(t, s.LinkIdentity("https://idp.example", "42", ivan))
+		assert.ErrorIs(t, s.LinkIdentity("https://idp.example", "42", DefaultUserID), ErrIdentityExists)
+		require.NoError(t, s.LinkIdentity("https://other.example", "42", DefaultUserID))
+
+		user, err := s.GetUserByIdentity("https://idp.example", "42")
+		require.NoError(t, err)
+		assert.Equal(t, "ivan", user.Login)
+		user, err = s.GetUserByIdentity("https://other.example", "42")
+		require.NoError(t, err)
+		assert.Equal(t, DefaultUserID, user.ID)
+
+		require.NoError(t, s.DeleteUser(ivan))
+		_, err = s.GetUserByIdentity("https://idp.example", "42")
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	})
+}
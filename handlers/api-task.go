@@ -1,252 +1,169 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"final_project/database"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"final_project/apierror"
+	"final_project/database"
 )
 
-// taskByIdHandler() обрабатывает GET-запросы по адресу /api/task
-func taskByIdHandler(rw http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		return
-	}
-	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
+// taskID() возвращает идентификатор задачи из параметра id запроса
+func taskID(r *http.Request) (int64, error) {
 	id := r.FormValue("id")
 	if len(id) == 0 {
-		rw.Write([]byte(`{"error":"не указан идентификатор"}`))
-		rw.WriteHeader(http.StatusBadRequest)
-		return
+		return 0, apierror.Field("id", "не указан идентификатор")
+	}
+	idInt, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, apierror.Field("id", "некорректный идентификатор")
 	}
+	return idInt, nil
+}
 
-	db := database.DBconn
+// decodeTask() читает задачу из тела запроса
+func decodeTask(r *http.Request) (Task, error) {
+	defer r.Body.Close()
 
-	idInt, err := strconv.Atoi(id)
-	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка работы с id %w"}`, err)))
-		rw.WriteHeader(http.StatusBadRequest)
-		return
+	var t Task
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		return t, apierror.Validation(fmt.Sprintf("ошибка десериализации: %v", err))
 	}
-	query := `SELECT id, date, title, comment, repeat FROM scheduler WHERE id = :id`
-	row := db.QueryRow(query, sql.Named("id", idInt))
+	return t, nil
+}
 
-	var task Task
-	err = row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
+// taskByIdHandler() обрабатывает GET-запросы по адресу /api/task
+func taskByIdHandler(rw http.ResponseWriter, r *http.Request) {
+	id, err := taskID(r)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			rw.Write([]byte(`{"error":"запись не найдена"}`))
-			rw.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка работы с БД %w"}`, err)))
-		rw.WriteHeader(http.StatusBadRequest)
+		apierror.Write(rw, err)
 		return
 	}
 
-	data, err := json.Marshal(task)
+	task, err := tasksOf(r.Context()).Get(id)
 	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка сериализации %w"}`, err)))
-		rw.WriteHeader(http.StatusBadRequest)
+		handledbError(rw, err)
 		return
 	}
-
-	rw.WriteHeader(http.StatusOK)
-	rw.Write(data)
+	respondWithJSON(rw, task)
 }
 
 // updateTaskHandler() обрабатывает PUT-запросы по адресу /api/task
 func updateTaskHandler(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
-	decoder := json.NewDecoder(r.Body)
-	var t Task
-	err := decoder.Decode(&t)
-	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка десериализации %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	defer r.Body.Close()
-
-	id, date, title, comment, repeat := t.ID, t.Date, t.Title, t.Comment, t.Repeat
-
-	if len(title) == 0 {
-		rw.Write([]byte(`{"error":"заголовок не может быть пустым"}`))
-		rw.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	if len(date) == 0 {
-		date = time.Now().Format("20060102")
+	t, err := decodeTask(r)
+	if err == nil {
+		t, err = prepareTask(t, time.Now())
 	}
-
-	dateTo, err := time.Parse("20060102", date)
 	if err != nil {
-		rw.Write([]byte(`{"error":"некорректная дата"}`))
-		rw.WriteHeader(http.StatusBadRequest)
+		apierror.Write(rw, err)
 		return
 	}
 
-	newDate := ""
-	if len(repeat) > 0 {
-		newDate, err = NextDate(time.Now(), date, repeat)
-		if err != nil {
-			rw.Write([]byte(fmt.Sprintf(`{"error":"%v"}`, err.Error())))
-			rw.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		if timeDiff(time.Now(), dateTo) {
-			date = newDate
-		}
-	}
-
-	//если дата меньше сегодняшнего числа
-	if timeDiff(time.Now(), dateTo) {
-		date = time.Now().Format("20060102")
-	}
-
-	db := database.DBconn
-
-	query := `UPDATE scheduler SET date = :date, title = :title, comment = :comment, repeat = :repeat WHERE id = :id`
-	res, err := db.Exec(query,
-		sql.Named("date", date),
-		sql.Named("title", title),
-		sql.Named("comment", comment),
-		sql.Named("repeat", repeat),
-		sql.Named("id", id),
-	)
-	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"работы с БД %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
+	if err := tasksOf(r.Context()).Update(t); err != nil {
+		handledbError(rw, err)
 		return
 	}
-	if rows, err := res.RowsAffected(); err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"работы с БД %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
-	} else if rows == 0 {
-		rw.Write([]byte(`{"error":"задача не найдена"}`))
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	rw.WriteHeader(http.StatusOK)
-	rw.Write([]byte(`{}`))
+	respondWithJSON(rw, struct{}{})
 }
 
 // deleteTaskHandler() обрабатывает DELETE-запросы по адресу /api/task
 func deleteTaskHandler(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
-	id := r.FormValue("id")
-	if len(id) == 0 {
-		rw.Write([]byte(`{"error":"не указан идентификатор"}`))
-		rw.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	db := database.DBconn
-
-	query := `DELETE FROM scheduler WHERE id = :id`
-	res, err := db.Exec(query, sql.Named("id", id))
+	id, err := taskID(r)
 	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка работы с БД %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
+		apierror.Write(rw, err)
 		return
 	}
-	if rows, err := res.RowsAffected(); err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка работы с БД %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
-	} else if rows == 0 {
-		rw.Write([]byte(`{"error":"задача не найдена"}`))
-		rw.WriteHeader(http.StatusInternalServerError)
+
+	if err := tasksOf(r.Context()).Delete(id); err != nil {
+		handledbError(rw, err)
 		return
 	}
-
-	rw.WriteHeader(http.StatusOK)
-	rw.Write([]byte(`{}`))
+	respondWithJSON(rw, struct{}{})
 }
 
 // addTaskHandler() обрабатывает POST-запросы по адресу /api/task
 func addTaskHandler(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
-	decoder := json.NewDecoder(r.Body)
-	var t Task
-	err := decoder.Decode(&t)
+	t, err := decodeTask(r)
+	if err == nil {
+		t, err = prepareTask(t, time.Now())
+	}
 	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка десериализации %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
+		apierror.Write(rw, err)
 		return
 	}
-	defer r.Body.Close()
-
-	date, title, comment, repeat := t.Date, t.Title, t.Comment, t.Repeat
 
-	if len(title) == 0 {
-		rw.Write([]byte(`{"error":"заголовок не может быть пустым"}`))
-		rw.WriteHeader(http.StatusBadRequest)
+	id, err := tasksOf(r.Context()).Create(t)
+	if err != nil {
+		handledbError(rw, err)
 		return
 	}
+	respondWithJSON(rw, struct {
+		ID string `json:"id"`
+	}{strconv.FormatInt(id, 10)})
+}
 
-	if len(date) == 0 {
-		date = time.Now().Format("20060102")
+// prepareTask() проверяет задачу перед сохранением: заголовок, дату, правило
+// повторения, условия окончания серии и метки. Пустая или прошедшая дата заменяется на сегодняшнюю.
+// Ошибки возвращаются как *apierror.Error с указанием неверного поля
+func prepareTask(t Task, now time.Time) (Task, error) {
+	if len(t.Title) == 0 {
+		return t, apierror.Field("title", "заголовок не может быть пустым")
 	}
 
-	dateTo, err := time.Parse("20060102", date)
+	tags, err := prepareTags(t.Tags)
 	if err != nil {
-		rw.Write([]byte(`{"error":"некорректная дата"}`))
-		rw.WriteHeader(http.StatusBadRequest)
-		return
+		return t, err
+	}
+	t.Tags = tags
+
+	if len(t.Date) == 0 {
+		t.Date = now.Format("20060102")
 	}
 
-	newDate := ""
-	if len(repeat) > 0 {
-		newDate, err = NextDate(time.Now(), date, repeat)
-		if err != nil {
-			rw.Write([]byte(fmt.Sprintf(`{"error":"%v"}`, err.Error())))
-			rw.WriteHeader(http.StatusBadRequest)
-			return
+	dateTo, err := time.Parse("20060102", t.Date)
+	if err != nil {
+		return t, apierror.Field("date", "некорректная дата")
+	}
+
+	if len(t.Repeat) > 0 {
+		// COUNT и UNTIL правила считаются от даты задачи, которая меняется при каждом
+		// выполнении, поэтому серию завершают поля repeat_count и repeat_until
+		count := t.RepeatCount
+		t = database.SplitRepeatEnd(t)
+		ruleCount := t.RepeatCount != count
+		newDate, err := NextDate(now, t.Date, t.Repeat)
+		// Законченная серия допустима, если её первая дата ещё не наступила
+		if err != nil && !(errors.Is(err, ErrSeriesEnded) && !timeDiff(now, dateTo)) {
+			return t, apierror.Field("repeat", err.Error())
 		}
-		if timeDiff(time.Now(), dateTo) {
-			date = newDate
+		if timeDiff(now, dateTo) {
+			// COUNT правила отсчитывается от даты задачи: выполнения до сегодняшнего
+			// дня уже прошли и вычитаются. Поле repeat_count хранит оставшиеся выполнения
+			if ruleCount {
+				passed, err := NextDates(dateTo, t.Date, t.Repeat, t.RepeatCount, now.AddDate(0, 0, -1).Format("20060102"))
+				if err != nil {
+					return t, apierror.Field("repeat", err.Error())
+				}
+				t.RepeatCount -= 1 + len(passed)
+				if t.RepeatCount <= 0 {
+					return t, apierror.Field("repeat", "серия повторений уже закончилась")
+				}
+			}
+			t.Date = newDate
 		}
 	}
 
 	//если дата меньше сегодняшнего числа
-	if timeDiff(time.Now(), dateTo) {
-		date = time.Now().Format("20060102")
+	if timeDiff(now, dateTo) {
+		t.Date = now.Format("20060102")
 	}
 
-	db := database.DBconn
-
-	query := `INSERT INTO scheduler (date, title, comment, repeat) VALUES (:date, :title, :comment, :repeat)`
-	res, err := db.Exec(query,
-		sql.Named("date", date),
-		sql.Named("title", title),
-		sql.Named("comment", comment),
-		sql.Named("repeat", repeat),
-	)
-	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка работы с БД %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
+	if err := checkRepeatEnd(t.Date, t.Repeat, t.RepeatUntil, t.RepeatCount); err != nil {
+		return t, err
 	}
-	idToAdd, err := res.LastInsertId()
-	if err != nil {
-		rw.Write([]byte(fmt.Sprintf(`{"error":"ошибка работы с БД %w"}`, err)))
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	rw.WriteHeader(http.StatusOK)
-	rw.Write([]byte(fmt.Sprintf(`{"id":"%d"}`, idToAdd)))
+	return t, nil
 }
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"final_project/apierror"
+	"final_project/auth"
+	"final_project/database"
+)
+
+// Наибольшая длина имени API-токена
+const maxAPITokenName = 64
+
+// apiTokenResponse — API-токен в ответах /api/tokens. Сам токен Token
+// возвращается только при создании
+type apiTokenResponse struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	Token      string   `json:"token,omitempty"`
+}
+
+func newAPITokenResponse(t database.APIToken) apiTokenResponse {
+	resp := apiTokenResponse{
+		ID:        strconv.FormatInt(t.ID, 10),
+		Name:      t.Name,
+		Prefix:    t.Prefix,
+		Scopes:    t.Scopes,
+		CreatedAt: t.CreatedAt.Format(time.RFC3339),
+	}
+	if !t.LastUsedAt.IsZero() {
+		resp.LastUsedAt = t.LastUsedAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+// APITokensHandler() обрабатывает запросы по адресу /api/tokens к API-токенам
+// текущего пользователя: GET — список, POST {"name", "scopes"} — создание,
+// DELETE ?id= — отзыв. Управлять токенами можно только после входа по паролю,
+// запросы с API-токеном отклоняются
+func APITokensHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if _, ok := auth.APITokenFromContext(r.Context()); ok {
+		respondWithStatus(rw, http.StatusForbidden, "API-токеном нельзя управлять API-токенами")
+		return
+	}
+	user := auth.UserFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := store.ListAPITokens(user.ID)
+		if err != nil {
+			handledbError(rw, err)
+			return
+		}
+		resp := make([]apiTokenResponse, 0, len(tokens))
+		for _, t := range tokens {
+			resp = append(resp, newAPITokenResponse(t))
+		}
+		respondWithJSON(rw, struct {
+			Tokens []apiTokenResponse `json:"tokens"`
+		}{Tokens: resp})
+	case http.MethodPost:
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > maxAPITokenName {
+			apierror.Write(rw, apierror.Field("name", fmt.Sprintf("имя токена должно содержать от 1 до %d символов", maxAPITokenName)))
+			return
+		}
+
+		secret, token, err := auth.CreateAPIToken(user, req.Name, req.Scopes)
+		if errors.Is(err, auth.ErrUnknownScope) {
+			apierror.Write(rw, apierror.Field("scopes", err.Error()))
+			return
+		}
+		if err != nil {
+			handledbError(rw, err)
+			return
+		}
+		resp := newAPITokenResponse(token)
+		resp.Token = secret
+		respondWithJSON(rw, resp)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			apierror.Write(rw, apierror.Field("id", "некорректный идентификатор токена"))
+			return
+		}
+		if err := store.DeleteAPIToken(user.ID, id); err != nil {
+			handledbError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct{}{})
+	default:
+		apierror.MethodNotAllowed(rw, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"final_project/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITokensHandler(t *testing.T) {
+	mem := useMemoryStore(t)
+	ivan := addUser(t, mem, "ivan", "ivan-password")
+
+	_, m := doUserRequest(t, APITokensHandler, ivan, http.MethodPost, "/api/tokens",
+		map[string]any{"name": "CI", "scopes": []string{"read"}})
+	require.NotContains(t, m, "error")
+	secret := m["token"].(string)
+	id := m["id"].(string)
+	assert.True(t, strings.HasPrefix(secret, m["prefix"].(string)))
+	assert.Equal(t, []any{"read"}, m["scopes"])
+	assert.NotContains(t, m, "last_used_at")
+
+	for _, body := range []map[string]any{
+		{"name": " "},
+		{"name": strings.Repeat("я", 65)},
+		{"name": "admin", "scopes": []string{"admin"}},
+	} {
+		_, m = doUserRequest(t, APITokensHandler, ivan, http.MethodPost, "/api/tokens", body)
+		assert.Contains(t, m, "error", body)
+	}
+
+	// В списке нет самого токена, а чужие токены не видны
+	_, m = doUserRequest(t, APITokensHandler, ivan, http.MethodGet, "/api/tokens", nil)
+	tokens := m["tokens"].([]any)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, "CI", tokens[0].(map[string]any)["name"])
+	assert.NotContains(t, tokens[0], "token")
+	_, m = doUserRequest(t, APITokensHandler, auth.DefaultUser, http.MethodGet, "/api/tokens", nil)
+	assert.Empty(t, m["tokens"])
+	_, m = doUserRequest(t, APITokensHandler, auth.DefaultUser, http.MethodDelete, "/api/tokens?id="+id, nil)
+	assert.Contains(t, m, "error")
+
+	// Запрос с API-токеном не может управлять токенами
+	t.Setenv("TODO_PASSWORD", "secret-password")
+	req := httptest.NewRequest(http.MethodPost, "/api/tokens", strings.NewReader(`{"name":"ещё"}`))
+	req.Header.Set("Authorization", "Bearer "+secret)
+	rec := httptest.NewRecorder()
+	auth.Auth(APITokensHandler)(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Токен для чтения получает задачи, но не изменяет их
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	rec = httptest.NewRecorder()
+	auth.Auth(TasksHandler)(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"tasks"`)
+	req = httptest.NewRequest(http.MethodPost, "/api/task", strings.NewReader(`{"date":"20990101","title":"x"}`))
+	req.Header.Set("Authorization", "Bearer "+secret)
+	rec = httptest.NewRecorder()
+	auth.Auth(TaskHandler)(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	_, m = doUserRequest(t, APITokensHandler, ivan, http.MethodDelete, "/api/tokens?id="+id, nil)
+	assert.Empty(t, m)
+	_, m = doUserRequest(t, APITokensHandler, ivan, http.MethodGet, "/api/tokens", nil)
+	assert.Empty(t, m["tokens"])
+}
@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"final_project/database"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+)
+
+// Пути CalDAV относительно префикса: принципал, набор календарей
+// и единственная коллекция, в которой лежат все задачи планировщика
+const (
+	calDAVPrincipal  = "/scheduler/"
+	calDAVHomeSet    = "/scheduler/calendars/"
+	calDAVCollection = "/scheduler/calendars/tasks/"
+	calDAVName       = "Планировщик"
+)
+
+// Пространства имён XML, используемые в ответах PROPFIND
+const (
+	nsDAV            = "DAV:"
+	nsCalDAV         = "urn:ietf:params:xml:ns:caldav"
+	nsCalendarServer = "http://calendarserver.org/ns/"
+)
+
+// calDAVBackend хранит задачи коллекции CalDAV в хранилище планировщика,
+// поэтому изменения видны и обработчикам /api/task, и клиентам CalDAV
+type calDAVBackend struct {
+	prefix string
+}
+
+// calDAVServer дополняет обработчик go-webdav свойством getctag коллекции,
+// по которому клиенты узнают, что коллекцию нужно синхронизировать
+type calDAVServer struct {
+	handler *caldav.Handler
+	backend *calDAVBackend
+}
+
+// NewCalDAVHandler() создаёт обработчик CalDAV с коллекцией задач по адресу
+// prefix/scheduler/calendars/tasks/
+func NewCalDAVHandler(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	backend := &calDAVBackend{prefix: prefix}
+	return &calDAVServer{
+		handler: &caldav.Handler{Backend: backend, Prefix: prefix},
+		backend: backend,
+	}
+}
+
+func (s *calDAVServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == "PROPFIND" && r.Header.Get("Depth") == "0" &&
+		path.Clean(r.URL.Path)+"/" == s.backend.prefix+calDAVCollection {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if bytes.Contains(body, []byte("getctag")) {
+			s.serveCollectionProps(rw, r, body)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	s.handler.ServeHTTP(rw, r)
+}
+
+// xmlElement — имя запрошенного свойства
+type xmlElement struct {
+	XMLName xml.Name
+}
+
+type propfindRequest struct {
+	XMLName xml.Name `xml:"DAV: propfind"`
+	Prop    *struct {
+		Names []xmlElement `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+// serveCollectionProps() отвечает на PROPFIND коллекции задач с глубиной 0.
+// go-webdav не знает свойство getctag, поэтому такие запросы обрабатываются здесь
+func (s *calDAVServer) serveCollectionProps(rw http.ResponseWriter, r *http.Request, body []byte) {
+	var req propfindRequest
+	if err := xml.Unmarshal(body, &req); err != nil || req.Prop == nil {
+		http.Error(rw, "некорректный запрос PROPFIND", http.StatusBadRequest)
+		return
+	}
+
+	ctag, err := collectionCTag(r.Context())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	values := map[xml.Name]string{
+		{Space: nsDAV, Local: "resourcetype"}:                        `<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>`,
+		{Space: nsDAV, Local: "displayname"}:                         `<d:displayname>` + xmlEscape(calDAVName) + `</d:displayname>`,
+		{Space: nsCalendarServer, Local: "getctag"}:                  `<cs:getctag>` + ctag + `</cs:getctag>`,
+		{Space: nsDAV, Local: "current-user-principal"}:              `<d:current-user-principal><d:href>` + xmlEscape(s.backend.prefix+calDAVPrincipal) + `</d:href></d:current-user-principal>`,
+		{Space: nsCalDAV, Local: "supported-calendar-component-set"}: `<c:supported-calendar-component-set><c:comp name="VTODO"/></c:supported-calendar-component-set>`,
+	}
+
+	var found, missing strings.Builder
+	for _, el := range req.Prop.Names {
+		if v, ok := values[el.XMLName]; ok {
+			found.WriteString(v)
+			continue
+		}
+		fmt.Fprintf(&missing, `<x:%s xmlns:x="%s"/>`, el.XMLName.Local, xmlEscape(el.XMLName.Space))
+	}
+
+	var out strings.Builder
+	out.WriteString(xml.Header)
+	fmt.Fprintf(&out, `<d:multistatus xmlns:d="%s" xmlns:c="%s" xmlns:cs="%s"><d:response><d:href>%s</d:href>`,
+		nsDAV, nsCalDAV, nsCalendarServer, xmlEscape(s.backend.prefix+calDAVCollection))
+	if found.Len() > 0 {
+		fmt.Fprintf(&out, `<d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>`, found.String())
+	}
+	if missing.Len() > 0 {
+		fmt.Fprintf(&out, `<d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>`, missing.String())
+	}
+	out.WriteString(`</d:response></d:multistatus>`)
+
+	rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	rw.WriteHeader(http.StatusMultiStatus)
+	rw.Write([]byte(out.String()))
+}
+
+// xmlEscape экранирует текст для вставки в XML
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// taskETag возвращает ETag задачи: он меняется при любом изменении задачи,
+// в том числе через /api/task
+func taskETag(task Task) string {
+	data, _ := json.Marshal(task)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// collectionCTag возвращает ctag коллекции: он меняется при добавлении,
+// изменении и удалении любой задачи
+func collectionCTag(ctx context.Context) (string, error) {
+	tasks, err := tasksOf(ctx).List(0)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, task := range tasks {
+		fmt.Fprintf(h, "%s:%s\n", task.ID, taskETag(task))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+func (b *calDAVBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	return b.prefix + calDAVPrincipal, nil
+}
+
+func (b *calDAVBackend) CalendarHomeSetPath(ctx context.Context) (string, error) {
+	return b.prefix + calDAVHomeSet, nil
+}
+
+func (b *calDAVBackend) calendar() caldav.Calendar {
+	return caldav.Calendar{
+		Path:                  b.prefix + calDAVCollection,
+		Name:                  calDAVName,
+		Description:           "Задачи планировщика",
+		MaxResourceSize:       MaxImportSize,
+		SupportedComponentSet: []string{ical.CompToDo},
+	}
+}
+
+func (b *calDAVBackend) CreateCalendar(ctx context.Context, calendar *caldav.Calendar) error {
+	return webdav.NewHTTPError(http.StatusForbidden, errors.New("создание календарей не поддерживается"))
+}
+
+func (b *calDAVBackend) ListCalendars(ctx context.Context) ([]caldav.Calendar, error) {
+	return []caldav.Calendar{b.calendar()}, nil
+}
+
+func (b *calDAVBackend) GetCalendar(ctx context.Context, p string) (*caldav.Calendar, error) {
+	if path.Clean(p)+"/" != b.prefix+calDAVCollection {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("календарь не найден"))
+	}
+	cal := b.calendar()
+	return &cal, nil
+}
+
+// objectName возвращает имя ресурса задачи в коллекции по пути запроса
+func (b *calDAVBackend) objectName(p string) (string, error) {
+	dir, name := path.Split(path.Clean(p))
+	if dir != b.prefix+calDAVCollection || name == "" {
+		return "", webdav.NewHTTPError(http.StatusNotFound, errors.New("ресурс не найден"))
+	}
+	return name, nil
+}
+
+// objectPath возвращает путь ресурса задачи: заданный клиентом или построенный по id
+func (b *calDAVBackend) objectPath(task Task) string {
+	name := task.ObjectName
+	if name == "" {
+		name = "task-" + task.ID + ".ics"
+	}
+	return b.prefix + calDAVCollection + name
+}
+
+// findTask ищет задачу по имени ресурса. Задачи, созданные не через CalDAV,
+// доступны по имени task-<id>.ics
+func findTask(ctx context.Context, name string) (Task, error) {
+	task, err := tasksOf(ctx).GetByObjectName(name)
+	if !errors.Is(err, database.ErrNotFound) {
+		return task, err
+	}
+
+	id, ok := strings.CutPrefix(name, "task-")
+	if !ok {
+		return Task{}, err
+	}
+	id, ok = strings.CutSuffix(id, ".ics")
+	idInt, convErr := strconv.ParseInt(id, 10, 64)
+	if !ok || convErr != nil {
+		return Task{}, err
+	}
+	task, err = tasksOf(ctx).Get(idInt)
+	if err == nil && task.ObjectName != "" {
+		return Task{}, database.ErrNotFound
+	}
+	return task, err
+}
+
+// lookup ищет задачу по пути ресурса, отсутствие задачи возвращается как ошибка 404
+func (b *calDAVBackend) lookup(ctx context.Context, p string) (Task, error) {
+	name, err := b.objectName(p)
+	if err != nil {
+		return Task{}, err
+	}
+	task, err := findTask(ctx, name)
+	if errors.Is(err, database.ErrNotFound) {
+		return Task{}, webdav.NewHTTPError(http.StatusNotFound, err)
+	}
+	return task, err
+}
+
+// taskObject представляет задачу ресурсом коллекции с записью VTODO
+func (b *calDAVBackend) taskObject(task Task) (caldav.CalendarObject, error) {
+	comp, err := taskComponent(task, ical.CompToDo, time.Now())
+	if err != nil {
+		return caldav.CalendarObject{}, err
+	}
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, icsProdID)
+	cal.Children = append(cal.Children, comp)
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return caldav.CalendarObject{}, err
+	}
+	return caldav.CalendarObject{
+		Path:          b.objectPath(task),
+		ContentLength: int64(buf.Len()),
+		ETag:          taskETag(task),
+		Data:          cal,
+	}, nil
+}
+
+func (b *calDAVBackend) GetCalendarObject(ctx context.Context, p string, req *caldav.CalendarCompRequest) (*caldav.CalendarObject, error) {
+	task, err := b.lookup(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.taskObject(task)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (b *calDAVBackend) ListCalendarObjects(ctx context.Context, p string, req *caldav.CalendarCompRequest) ([]caldav.CalendarObject, error) {
+	if _, err := b.GetCalendar(ctx, p); err != nil {
+		return nil, err
+	}
+	tasks, err := tasksOf(ctx).List(0)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]caldav.CalendarObject, 0, len(tasks))
+	for _, task := range tasks {
+		obj, err := b.taskObject(task)
+		if err != nil {
+			// Задачи с некорректной датой клиентам не показываются
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+func (b *calDAVBackend) QueryCalendarObjects(ctx context.Context, p string, query *caldav.CalendarQuery) ([]caldav.CalendarObject, error) {
+	objects, err := b.ListCalendarObjects(ctx, p, &query.CompRequest)
+	if err != nil {
+		return nil, err
+	}
+	return caldav.Filter(query, objects)
+}
+
+func (b *calDAVBackend) PutCalendarObject(ctx context.Context, p string, cal *ical.Calendar, opts *caldav.PutCalendarObjectOptions) (*caldav.CalendarObject, error) {
+	name, err := b.objectName(p)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := findTask(ctx, name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+	if err := checkConditions(opts, existing, exists); err != nil {
+		return nil, err
+	}
+
+	kind, uid, err := caldav.ValidateCalendarObject(cal)
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if kind != ical.CompToDo {
+		return nil, webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("коллекция принимает только VTODO, а не %s", kind))
+	}
+	if exists && uid != taskUID(existing) {
+		return nil, webdav.NewHTTPError(http.StatusConflict, errors.New("UID задачи нельзя изменить"))
+	}
+
+	var comp *ical.Component
+	for _, c := range cal.Children {
+		if c.Name == ical.CompToDo && c.Props.Get(ical.PropRecurrenceID) == nil {
+			comp = c
+			break
+		}
+	}
+	if comp == nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, errors.New("не найдена запись VTODO"))
+	}
+
+	now := time.Now()
+	if componentCompleted(comp) {
+		return b.completeObject(ctx, p, existing, exists, now)
+	}
+
+	task, _, err := taskFromComponent(comp, now)
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+	// PUT заменяет запись целиком: без CATEGORIES метки задачи снимаются
+	if task.Tags == nil {
+		task.Tags = []string{}
+	}
+	task, err = prepareTask(task, now)
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var id int64
+	if exists {
+		task.ID = existing.ID
+		if err := tasksOf(ctx).Update(task); err != nil {
+			return nil, err
+		}
+		id, _ = strconv.ParseInt(existing.ID, 10, 64)
+	} else {
+		task.UID, task.ObjectName = uid, name
+		if id, err = tasksOf(ctx).Create(task); err != nil {
+			return nil, err
+		}
+	}
+
+	saved, err := tasksOf(ctx).Get(id)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.taskObject(saved)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// completeObject отмечает задачу выполненной, как /api/task/done: повторяющаяся задача
+// переносится на следующую дату, разовая удаляется. Выполненную задачу, которой
+// нет в планировщике, сохранять не нужно
+func (b *calDAVBackend) completeObject(ctx context.Context, p string, task Task, exists bool, now time.Time) (*caldav.CalendarObject, error) {
+	if !exists {
+		return &caldav.CalendarObject{Path: p}, nil
+	}
+	nextDate, err := completionDate(now, task)
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+	id, _ := strconv.ParseInt(task.ID, 10, 64)
+	if err := tasksOf(ctx).Complete(id, nextDate); err != nil {
+		return nil, err
+	}
+	if nextDate == "" {
+		return &caldav.CalendarObject{Path: p}, nil
+	}
+
+	saved, err := tasksOf(ctx).Get(id)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := b.taskObject(saved)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// checkConditions проверяет заголовки If-Match и If-None-Match запроса PUT
+func checkConditions(opts *caldav.PutCalendarObjectOptions, existing Task, exists bool) error {
+	failed := webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("задача изменилась на сервере"))
+
+	if opts.IfNoneMatch.IsSet() && exists {
+		if opts.IfNoneMatch.IsWildcard() {
+			return failed
+		}
+		if etag, err := opts.IfNoneMatch.ETag(); err == nil && etag == taskETag(existing) {
+			return failed
+		}
+	}
+	if opts.IfMatch.IsSet() {
+		if !exists {
+			return failed
+		}
+		if !opts.IfMatch.IsWildcard() {
+			etag, err := opts.IfMatch.ETag()
+			if err != nil || etag != taskETag(existing) {
+				return failed
+			}
+		}
+	}
+	return nil
+}
+
+func (b *calDAVBackend) DeleteCalendarObject(ctx context.Context, p string) error {
+	task, err := b.lookup(ctx, p)
+	if err != nil {
+		return err
+	}
+	id, _ := strconv.ParseInt(task.ID, 10, 64)
+	return tasksOf(ctx).Delete(id)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"final_project/database"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCollection = "/dav/scheduler/calendars/tasks/"
+
+// startCalDAV() запускает сервер CalDAV на хранилище в памяти и возвращает клиент к нему.
+func startCalDAV(t *testing.T) (*httptest.Server, *caldav.Client, *database.MemoryStore) {
+	t.Helper()
+	mem := useMemoryStore(t)
+	srv := httptest.NewServer(NewCalDAVHandler("/dav"))
+	t.Cleanup(srv.Close)
+
+	client, err := caldav.NewClient(webdav.HTTPClientWithBasicAuth(srv.Client(), "user", "pass"), srv.URL+"/dav/")
+	require.NoError(t, err)
+	return srv, client, mem
+}
+
+// davRequest() выполняет запрос к серверу CalDAV и возвращает ответ с прочитанным телом.
+func davRequest(t *testing.T, srv *httptest.Server, method, p, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+p, strings.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+var ctagPattern = regexp.MustCompile(`<cs:getctag>([^<]+)</cs:getctag>`)
+
+// collectionCTagOf() запрашивает getctag коллекции задач.
+func collectionCTagOf(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	resp, body := davRequest(t, srv, "PROPFIND", testCollection,
+		`<?xml version="1.0"?><d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">`+
+			`<d:prop><d:displayname/><cs:getctag/><d:sync-token/></d:prop></d:propfind>`,
+		map[string]string{"Depth": "0", "Content-Type": "application/xml"})
+	require.Equal(t, http.StatusMultiStatus, resp.StatusCode, body)
+	assert.Contains(t, body, "404 Not Found", "неизвестные свойства возвращаются со статусом 404")
+	m := ctagPattern.FindStringSubmatch(body)
+	require.Len(t, m, 2, body)
+	return m[1]
+}
+
+// todo() возвращает календарь с одной записью VTODO.
+func todo(lines ...string) *ical.Calendar {
+	cal, err := ical.NewDecoder(strings.NewReader(strings.Join(vcalendar(
+		"BEGIN:VTODO\n"+strings.Join(lines, "\n")+"\nDTSTAMP:20990101T000000Z\nEND:VTODO"), "\r\n") + "\r\n")).Decode()
+	if err != nil {
+		panic(err)
+	}
+	return cal
+}
+
+func TestCalDAVDiscovery(t *testing.T) {
+	srv, client, mem := startCalDAV(t)
+	ctx := context.Background()
+	_, err := mem.Create(Task{Date: "20990105", Title: "Из API", Repeat: "d 7"})
+	require.NoError(t, err)
+
+	principal, err := client.FindCurrentUserPrincipal(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "/dav/scheduler/", principal)
+	home, err := client.FindCalendarHomeSet(ctx, principal)
+	require.NoError(t, err)
+	calendars, err := client.FindCalendars(ctx, home)
+	require.NoError(t, err)
+	require.Len(t, calendars, 1)
+	assert.Equal(t, testCollection, calendars[0].Path)
+	assert.Equal(t, []string{ical.CompToDo}, calendars[0].SupportedComponentSet)
+
+	objects, err := client.QueryCalendar(ctx, testCollection, &caldav.CalendarQuery{
+		CompRequest: caldav.CalendarCompRequest{Name: ical.CompCalendar, AllProps: true, AllComps: true},
+		CompFilter:  caldav.CompFilter{Name: ical.CompCalendar, Comps: []caldav.CompFilter{{Name: ical.CompToDo}}},
+	})
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, testCollection+"task-1.ics", objects[0].Path)
+	assert.NotEmpty(t, objects[0].ETag)
+	title, err := objects[0].Data.Children[0].Props.Text(ical.PropSummary)
+	require.NoError(t, err)
+	assert.Equal(t, "Из API", title)
+
+	rec := httptest.NewRecorder()
+	srv.Config.Handler.ServeHTTP(rec, httptest.NewRequest("PROPFIND", "/.well-known/caldav", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	assert.Equal(t, "/dav/scheduler/", rec.Header().Get("Location"))
+}
+
+func TestCalDAVSync(t *testing.T) {
+	srv, client, mem := startCalDAV(t)
+	ctx := context.Background()
+	ctag := collectionCTagOf(t, srv)
+
+	// Задача, созданная на телефоне, попадает в планировщик
+	created, err := client.PutCalendarObject(ctx, testCollection+"phone-1.ics", todo(
+		"UID:phone-1@test", "SUMMARY:С телефона", "DTSTART;VALUE=DATE:20990105", "RRULE:FREQ=DAILY;INTERVAL=2"))
+	require.NoError(t, err)
+	task, err := mem.GetByObjectName("phone-1.ics")
+	require.NoError(t, err)
+	assert.Equal(t, "С телефона", task.Title)
+	assert.Equal(t, "d 2", task.Repeat)
+	assert.Equal(t, "phone-1@test", task.UID)
+
+	obj, err := client.GetCalendarObject(ctx, testCollection+"phone-1.ics")
+	require.NoError(t, err)
+	assert.Equal(t, created.ETag, obj.ETag)
+	uid, err := obj.Data.Children[0].Props.Text(ical.PropUID)
+	require.NoError(t, err)
+	assert.Equal(t, "phone-1@test", uid)
+
+	newCTag := collectionCTagOf(t, srv)
+	assert.NotEqual(t, ctag, newCTag)
+
+	// Изменение через API меняет ETag задачи и ctag коллекции
+	task.Title = "Изменена в браузере"
+	require.NoError(t, mem.Update(task))
+	obj, err = client.GetCalendarObject(ctx, testCollection+"phone-1.ics")
+	require.NoError(t, err)
+	assert.NotEqual(t, created.ETag, obj.ETag)
+	assert.NotEqual(t, newCTag, collectionCTagOf(t, srv))
+
+	// Запись с устаревшим ETag отклоняется
+	body := strings.Join(vcalendar("BEGIN:VTODO\nUID:phone-1@test\nDTSTAMP:20990101T000000Z\nSUMMARY:Старая версия\nDTSTART;VALUE=DATE:20990105\nEND:VTODO"), "\r\n")
+	resp, _ := davRequest(t, srv, http.MethodPut, testCollection+"phone-1.ics", body,
+		map[string]string{"Content-Type": "text/calendar", "If-Match": `"` + created.ETag + `"`})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = davRequest(t, srv, http.MethodPut, testCollection+"phone-1.ics", body,
+		map[string]string{"Content-Type": "text/calendar", "If-None-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = davRequest(t, srv, http.MethodPut, testCollection+"phone-1.ics", body,
+		map[string]string{"Content-Type": "text/calendar", "If-Match": `"` + obj.ETag + `"`})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	task, err = mem.GetByObjectName("phone-1.ics")
+	require.NoError(t, err)
+	assert.Equal(t, "Старая версия", task.Title)
+	assert.Empty(t, task.Repeat, "правило удалено клиентом")
+
+	// Смена UID ресурса и записи VEVENT отклоняются
+	_, err = client.PutCalendarObject(ctx, testCollection+"phone-1.ics", todo("UID:other@test", "SUMMARY:Другая"))
+	assert.Error(t, err)
+	event, err := ical.NewDecoder(strings.NewReader(strings.Join(vcalendar(
+		"BEGIN:VEVENT\nUID:ev@test\nDTSTAMP:20990101T000000Z\nDTSTART;VALUE=DATE:20990105\nSUMMARY:Событие\nEND:VEVENT"), "\r\n") + "\r\n")).Decode()
+	require.NoError(t, err)
+	_, err = client.PutCalendarObject(ctx, testCollection+"event.ics", event)
+	assert.Error(t, err)
+
+	require.NoError(t, client.RemoveAll(ctx, testCollection+"phone-1.ics"))
+	_, err = mem.GetByObjectName("phone-1.ics")
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	_, err = client.GetCalendarObject(ctx, testCollection+"phone-1.ics")
+	assert.Error(t, err)
+}
+
+func TestCalDAVComplete(t *testing.T) {
+	_, client, mem := startCalDAV(t)
+	ctx := context.Background()
+	repeating, err := mem.Create(Task{Date: "20990105", Title: "Полив", Repeat: "d 2"})
+	require.NoError(t, err)
+	single, err := mem.Create(Task{Date: "20990105", Title: "Разовая"})
+	require.NoError(t, err)
+
+	// Выполненная повторяющаяся задача переносится на следующую дату
+	_, err = client.PutCalendarObject(ctx, testCollection+"task-1.ics", todo(
+		"UID:task-1@scheduler", "SUMMARY:Полив", "DTSTART;VALUE=DATE:20990105", "RRULE:FREQ=DAILY;INTERVAL=2",
+		"STATUS:COMPLETED", "COMPLETED:20990105T100000Z"))
+	require.NoError(t, err)
+	task, err := mem.Get(repeating)
+	require.NoError(t, err)
+	assert.Equal(t, "20990107", task.Date)
+
+	// Выполненная разовая задача удаляется
+	_, err = client.PutCalendarObject(ctx, testCollection+"task-2.ics", todo(
+		"UID:task-2@scheduler", "SUMMARY:Разовая", "DTSTART;VALUE=DATE:20990105", "STATUS:COMPLETED"))
+	require.NoError(t, err)
+	_, err = mem.Get(single)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Новая, но уже выполненная задача не сохраняется
+	_, err = client.PutCalendarObject(ctx, testCollection+"done.ics", todo(
+		"UID:done@test", "SUMMARY:Готово", "STATUS:COMPLETED"))
+	require.NoError(t, err)
+	tasks, err := mem.List(0)
+	require.NoError(t, err)
+	assert.Len(t, tasks, 1)
+}
+
+func TestCalDAVTags(t *testing.T) {
+	_, client, mem := startCalDAV(t)
+	ctx := context.Background()
+
+	_, err := client.PutCalendarObject(ctx, testCollection+"phone-1.ics", todo(
+		"UID:phone-1@test", "SUMMARY:Отчёт", "DTSTART;VALUE=DATE:20990105", "CATEGORIES:работа,срочно"))
+	require.NoError(t, err)
+	task, err := mem.GetByObjectName("phone-1.ics")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"работа", "срочно"}, task.Tags)
+
+	// Клиент убрал все категории: PUT заменяет запись целиком, и метки снимаются
+	_, err = client.PutCalendarObject(ctx, testCollection+"phone-1.ics", todo(
+		"UID:phone-1@test", "SUMMARY:Отчёт", "DTSTART;VALUE=DATE:20990105"))
+	require.NoError(t, err)
+	task, err = mem.GetByObjectName("phone-1.ics")
+	require.NoError(t, err)
+	assert.Empty(t, task.Tags)
+}
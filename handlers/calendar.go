@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"final_project/apierror"
+)
+
+// Максимальная длина диапазона /api/calendar в днях
+const MaxCalendarDays = 366
+
+// CalendarOccurrence — задача в конкретный день календаря. Для повторяющихся
+// задач Date содержит дату вхождения, а Virtual показывает, что вхождение
+// вычислено по правилу повторения и в базе не хранится
+type CalendarOccurrence struct {
+	Task
+	Virtual bool `json:"virtual,omitempty"`
+}
+
+// CalendarDay — задачи, приходящиеся на один день
+type CalendarDay struct {
+	Date  string               `json:"date"`
+	Tasks []CalendarOccurrence `json:"tasks"`
+}
+
+// CalendarHandler() обрабатывает GET-запросы по адресу /api/calendar?from=&to=
+// и возвращает все дни диапазона с задачами, раскрывая повторяющиеся задачи
+func CalendarHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodGet) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	from, to := r.FormValue("from"), r.FormValue("to")
+	fromDate, err := time.Parse("20060102", from)
+	if err != nil {
+		apierror.Write(rw, apierror.Field("from", "некорректный диапазон дат from..to"))
+		return
+	}
+	toDate, err := time.Parse("20060102", to)
+	if err != nil {
+		apierror.Write(rw, apierror.Field("to", "некорректный диапазон дат from..to"))
+		return
+	}
+	if toDate.Before(fromDate) || toDate.After(fromDate.AddDate(0, 0, MaxCalendarDays-1)) {
+		apierror.Write(rw, apierror.Field("to", fmt.Sprintf("диапазон дат должен быть не длиннее %d дней", MaxCalendarDays)))
+		return
+	}
+
+	// Задачи с датой позже to в диапазон не попадут: повторения идут только вперёд
+	tasks, err := tasksOf(r.Context()).ListUntil(to)
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+
+	days := []CalendarDay{}
+	index := map[string]int{}
+	for d := fromDate; !d.After(toDate); d = d.AddDate(0, 0, 1) {
+		index[d.Format("20060102")] = len(days)
+		days = append(days, CalendarDay{Date: d.Format("20060102"), Tasks: []CalendarOccurrence{}})
+	}
+
+	for _, task := range tasks {
+		for _, date := range taskOccurrences(task, fromDate, to) {
+			i, ok := index[date]
+			if !ok {
+				continue
+			}
+			occurrence := CalendarOccurrence{Task: task, Virtual: date != task.Date}
+			occurrence.Date = date
+			days[i].Tasks = append(days[i].Tasks, occurrence)
+		}
+	}
+
+	respondWithJSON(rw, struct {
+		From string        `json:"from"`
+		To   string        `json:"to"`
+		Days []CalendarDay `json:"days"`
+	}{From: from, To: to, Days: days})
+}
+
+// taskOccurrences возвращает даты задачи от from до to включительно: сохранённую
+// дату и вычисленные по правилу повторения с учётом условий окончания серии.
+// Задачи с некорректным правилом показываются только в сохранённую дату
+func taskOccurrences(task Task, from time.Time, to string) []string {
+	dates := []string{task.Date}
+	if task.Repeat == "" {
+		return dates
+	}
+
+	if task.RepeatUntil != "" && task.RepeatUntil < to {
+		to = task.RepeatUntil
+	}
+
+	// Пропускаем вхождения до начала диапазона. Если число повторений ограничено,
+	// перебираем серию с самого начала, чтобы не превысить его
+	now, err := time.Parse("20060102", task.Date)
+	if err != nil {
+		return dates
+	}
+	limit := MaxCalendarDays
+	if task.RepeatCount > 0 {
+		limit = task.RepeatCount - 1
+	} else if start := from.AddDate(0, 0, -1); start.After(now) {
+		now = start
+	}
+	if limit == 0 {
+		return dates
+	}
+
+	next, err := NextDates(now, task.Date, task.Repeat, limit, to)
+	if err != nil {
+		return dates
+	}
+	return append(dates, next...)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// calendarTitles() возвращает заголовки задач календаря по дням.
+func calendarTitles(t *testing.T, m map[string]any) map[string][]string {
+	t.Helper()
+	require.NotContains(t, m, "error")
+	days, ok := m["days"].([]any)
+	require.True(t, ok)
+
+	titles := map[string][]string{}
+	for _, d := range days {
+		day := d.(map[string]any)
+		for _, task := range day["tasks"].([]any) {
+			titles[day["date"].(string)] = append(titles[day["date"].(string)], task.(map[string]any)["title"].(string))
+		}
+	}
+	return titles
+}
+
+func TestCalendarHandler(t *testing.T) {
+	mem := useMemoryStore(t)
+	for _, task := range []Task{
+		{Date: "20240129", Title: "Зарядка", Repeat: "d 1"},
+		{Date: "20240125", Title: "Планёрка", Repeat: "w 1,3"},
+		{Date: "20240131", Title: "Отчёт"},
+		{Date: "20240201", Title: "Сдать анализы"},
+		{Date: "20240130", Title: "Полив", Repeat: "d 2", RepeatCount: 2},
+		{Date: "20240101", Title: "Бассейн", Repeat: "FREQ=WEEKLY;BYDAY=SU", RepeatUntil: "20240201"},
+	} {
+		_, err := mem.Create(task)
+		require.NoError(t, err)
+	}
+
+	m := doRequest(t, CalendarHandler, http.MethodGet, "/api/calendar?from=20240129&to=20240204", nil)
+	assert.Equal(t, "20240129", m["from"])
+	assert.Len(t, m["days"], 7)
+	assert.Equal(t, map[string][]string{
+		"20240129": {"Планёрка", "Зарядка"},
+		"20240130": {"Зарядка", "Полив"},
+		"20240131": {"Планёрка", "Зарядка", "Отчёт"},
+		"20240201": {"Зарядка", "Полив", "Сдать анализы"},
+		"20240202": {"Зарядка"},
+		"20240203": {"Зарядка"},
+		"20240204": {"Зарядка"},
+	}, calendarTitles(t, m))
+
+	// Хранимое вхождение не помечено как виртуальное, вычисленные — помечены
+	day := m["days"].([]any)[1].(map[string]any)
+	tasks := day["tasks"].([]any)
+	assert.Equal(t, "20240130", tasks[0].(map[string]any)["date"])
+	assert.Equal(t, true, tasks[0].(map[string]any)["virtual"])
+	assert.NotContains(t, tasks[1].(map[string]any), "virtual")
+}
+
+func TestTaskOccurrences(t *testing.T) {
+	from := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, []string{"20240101", "20240201", "20240208"},
+		taskOccurrences(Task{Date: "20240101", Repeat: "w 4"}, from, "20240210"))
+	assert.Equal(t, []string{"20240101", "20240108"},
+		taskOccurrences(Task{Date: "20240101", Repeat: "w 1", RepeatCount: 2}, from, "20240210"))
+	assert.Equal(t, []string{"20240101"},
+		taskOccurrences(Task{Date: "20240101", Repeat: "d 1", RepeatCount: 1}, from, "20240210"))
+	assert.Equal(t, []string{"20240101"},
+		taskOccurrences(Task{Date: "20240101", Repeat: "ooops"}, from, "20240210"))
+	assert.Equal(t, []string{"20240101", "20240201", "20240202"},
+		taskOccurrences(Task{Date: "20240101", Repeat: "d 1", RepeatUntil: "20240202"}, from, "20240210"))
+}
+
+func TestCalendarHandlerValidation(t *testing.T) {
+	useMemoryStore(t)
+
+	for _, target := range []string{
+		"/api/calendar",
+		"/api/calendar?from=20240101",
+		"/api/calendar?from=20240201&to=20240101",
+		"/api/calendar?from=20240101&to=20250201",
+		"/api/calendar?from=2024-01-01&to=20240201",
+	} {
+		m := doRequest(t, CalendarHandler, http.MethodGet, target, nil)
+		assert.Contains(t, m, "error", target)
+	}
+
+	m := doRequest(t, CalendarHandler, http.MethodGet, "/api/calendar?from=20240101&to=20240101", nil)
+	assert.Equal(t, []any{map[string]any{"date": "20240101", "tasks": []any{}}}, m["days"])
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"final_project/apierror"
+	"final_project/auth"
+	"final_project/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenStore — хранилище, задачи которого недоступны, как при сбое БД.
+type brokenStore struct {
+	*database.MemoryStore
+}
+
+func (s brokenStore) ForUser(userID int64) database.TaskStore {
+	return brokenTasks{s.MemoryStore.ForUser(userID)}
+}
+
+type brokenTasks struct {
+	database.TaskStore
+}
+
+var errBroken = errors.New("database is locked")
+
+func (brokenTasks) Get(int64) (Task, error)                           { return Task{}, errBroken }
+func (brokenTasks) List(int) ([]Task, error)                          { return nil, errBroken }
+func (brokenTasks) Search(database.SearchFilter, int) ([]Task, error) { return nil, errBroken }
+
+// apiError() выполняет запрос к обработчику от имени администратора
+// и возвращает статус и ошибку из ответа.
+func apiError(t *testing.T, h http.HandlerFunc, method, target string, body any) (int, apierror.Error) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	req = req.WithContext(auth.WithUser(req.Context(), auth.DefaultUser))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	assert.Equal(t, "application/json; charset=UTF-8", rec.Header().Get("Content-Type"))
+	var e apierror.Error
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e), rec.Body.String())
+	assert.NotEmpty(t, e.Message)
+	return rec.Code, e
+}
+
+func TestErrorStatuses(t *testing.T) {
+	useMemoryStore(t)
+	today := time.Now().Format("20060102")
+
+	for _, tc := range []struct {
+		name   string
+		h      http.HandlerFunc
+		method string
+		target string
+		body   any
+		status int
+		code   string
+		field  string
+	}{
+		{"задача без заголовка", TaskHandler, http.MethodPost, "/api/task", map[string]any{"date": today}, 400, apierror.CodeValidationFailed, "title"},
+		{"некорректная дата", TaskHandler, http.MethodPost, "/api/task", map[string]any{"title": "x", "date": "завтра"}, 400, apierror.CodeValidationFailed, "date"},
+		{"некорректное правило", TaskHandler, http.MethodPut, "/api/task", map[string]any{"id": "1", "title": "x", "repeat": "q 1"}, 400, apierror.CodeValidationFailed, "repeat"},
+		{"не JSON", TaskHandler, http.MethodPost, "/api/task", "{", 400, apierror.CodeValidationFailed, ""},
+		{"без id", TaskHandler, http.MethodGet, "/api/task", nil, 400, apierror.CodeValidationFailed, "id"},
+		{"некорректный id", TaskHandler, http.MethodDelete, "/api/task?id=abc", nil, 400, apierror.CodeValidationFailed, "id"},
+		{"нет задачи", TaskHandler, http.MethodGet, "/api/task?id=404", nil, 404, apierror.CodeNotFound, ""},
+		{"изменение несуществующей", TaskHandler, http.MethodPut, "/api/task", map[string]any{"id": "404", "date": today, "title": "x"}, 404, apierror.CodeNotFound, ""},
+		{"удаление несуществующей", TaskHandler, http.MethodDelete, "/api/task?id=404", nil, 404, apierror.CodeNotFound, ""},
+		{"выполнение несуществующей", TaskDoneHandler, http.MethodPost, "/api/task/done?id=404", nil, 404, apierror.CodeNotFound, ""},
+		{"метод задачи", TaskHandler, http.MethodPatch, "/api/task", nil, 405, apierror.CodeMethodNotAllowed, ""},
+		{"метод списка", TasksHandler, http.MethodPost, "/api/tasks", nil, 405, apierror.CodeMethodNotAllowed, ""},
+		{"правило nextdate", NextDateHandler, http.MethodGet, "/api/nextdate?now=20240126&date=20240126&repeat=q", nil, 400, apierror.CodeValidationFailed, "repeat"},
+		{"диапазон календаря", CalendarHandler, http.MethodGet, "/api/calendar?from=20240101&to=x", nil, 400, apierror.CodeValidationFailed, "to"},
+		{"компонент ics", TasksICSHandler, http.MethodGet, "/api/tasks.ics?component=vjournal", nil, 400, apierror.CodeValidationFailed, "component"},
+		{"короткий пароль", UsersHandler, http.MethodPost, "/api/users", map[string]any{"login": "ivan", "password": "short"}, 400, apierror.CodeValidationFailed, "password"},
+		{"нет пользователя", UsersHandler, http.MethodDelete, "/api/users?id=404", nil, 404, apierror.CodeNotFound, ""},
+		{"нет токена", APITokensHandler, http.MethodDelete, "/api/tokens?id=404", nil, 404, apierror.CodeNotFound, ""},
+		{"неверный пароль", SignInHandler, http.MethodPost, "/api/signin", map[string]any{"password": "wrong"}, 401, apierror.CodeUnauthorized, ""},
+		{"метод входа", SignInHandler, http.MethodGet, "/api/signin", nil, 405, apierror.CodeMethodNotAllowed, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TODO_PASSWORD", "secret-password")
+			status, e := apiError(t, tc.h, tc.method, tc.target, tc.body)
+			assert.Equal(t, tc.status, status)
+			assert.Equal(t, tc.code, e.Code)
+			if tc.field != "" {
+				assert.Contains(t, e.Details, tc.field)
+			} else {
+				assert.Empty(t, e.Details)
+			}
+		})
+	}
+}
+
+func TestErrorMethodNotAllowed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPatch, "/api/task", nil)
+	rec := httptest.NewRecorder()
+	TaskHandler(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, POST, PUT, DELETE", rec.Header().Get("Allow"))
+}
+
+func TestErrorUserExists(t *testing.T) {
+	mem := useMemoryStore(t)
+	addUser(t, mem, "ivan", "ivan-password")
+
+	status, e := apiError(t, UsersHandler, http.MethodPost, "/api/users", map[string]any{"login": "ivan", "password": "ivan-password"})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, apierror.CodeConflict, e.Code)
+	assert.Contains(t, e.Details, "login")
+}
+
+func TestErrorDatabase(t *testing.T) {
+	useMemoryStore(t)
+	SetStore(brokenStore{database.NewMemoryStore()})
+
+	for target, h := range map[string]http.HandlerFunc{
+		"/api/tasks":              TasksHandler,
+		"/api/tasks?search=отчёт": TasksHandler,
+		"/api/task?id=1":          TaskHandler,
+	} {
+		status, e := apiError(t, h, http.MethodGet, target, nil)
+		assert.Equal(t, http.StatusInternalServerError, status, target)
+		assert.Equal(t, apierror.CodeDBError, e.Code, target)
+		assert.Contains(t, e.Message, errBroken.Error(), target)
+	}
+}
+
+func TestErrorAuthMiddleware(t *testing.T) {
+	t.Setenv("TODO_PASSWORD", "secret-password")
+	h := auth.Auth(func(rw http.ResponseWriter, r *http.Request) {
+		t.Fatal("запрос без токена не должен доходить до обработчика")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	var e apierror.Error
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e), rec.Body.String())
+	assert.Equal(t, apierror.CodeUnauthorized, e.Code)
+}
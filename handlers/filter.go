@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"final_project/apierror"
+	"final_project/database"
+)
+
+// taskFilter() строит фильтр /api/tasks из параметров запроса:
+//
+//	search     — запрос поиска (см. database.ParseQuery) либо дата в формате 02.01.2006
+//	from, to   — диапазон дат 20060102 включительно
+//	overdue    — true: только просроченные задачи (с датой раньше сегодняшней)
+//	today      — true: только задачи на сегодня
+//	repeating  — true: только повторяющиеся задачи, false: только разовые
+//	repeat     — тип правила повторения: d, w, m или y
+//	tag        — метка задачи; если параметров tag несколько, нужны все метки
+//	sort       — порядок: date, date_desc, title, id или relevance;
+//	             по умолчанию relevance, если в запросе поиска есть текст, и date в остальных случаях
+//	cursor     — позиция, с которой продолжается список, из next_cursor
+//
+// Условия объединяются через И
+func taskFilter(r *http.Request, now time.Time) (database.SearchFilter, error) {
+	filter := buildSearchFilter(r.FormValue("search"))
+	var ranked bool
+	if filter.Text != "" {
+		q, err := database.ParseQuery(filter.Text)
+		if err != nil {
+			return filter, apierror.Field("search", err.Error())
+		}
+		ranked = q.Ranked()
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *string
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		v := r.FormValue(p.name)
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse("20060102", v); err != nil {
+			return filter, apierror.Field(p.name, fmt.Sprintf("%s должна быть датой в формате 20060102", p.name))
+		}
+		*p.dst = v
+	}
+	if filter.From != "" && filter.To != "" && filter.To < filter.From {
+		return filter, apierror.Field("to", "дата to раньше from")
+	}
+
+	overdue, err := boolParam(r, "overdue")
+	if err != nil {
+		return filter, err
+	}
+	today, err := boolParam(r, "today")
+	if err != nil {
+		return filter, err
+	}
+	if overdue != nil && today != nil && *overdue && *today {
+		return filter, apierror.Field("today", "overdue и today несовместимы")
+	}
+	// Просроченные и сегодняшние задачи сужают диапазон дат
+	if overdue != nil && *overdue {
+		filter.To = minDate(filter.To, now.AddDate(0, 0, -1).Format("20060102"))
+	}
+	if today != nil && *today {
+		day := now.Format("20060102")
+		filter.From = max(filter.From, day)
+		filter.To = minDate(filter.To, day)
+	}
+
+	filter.Repeating, err = boolParam(r, "repeating")
+	if err != nil {
+		return filter, err
+	}
+	if v := r.FormValue("repeat"); v != "" {
+		if _, ok := database.RepeatTypes[v]; !ok {
+			return filter, apierror.Field("repeat", "repeat должен быть d, w, m или y")
+		}
+		if filter.Repeating != nil && !*filter.Repeating {
+			return filter, apierror.Field("repeat", "у разовых задач нет правила повторения")
+		}
+		filter.RepeatType = v
+	}
+
+	for _, tag := range r.Form["tag"] {
+		name, err := tagName("tag", tag)
+		if err != nil {
+			return filter, err
+		}
+		filter.Tags = append(filter.Tags, name)
+	}
+
+	switch v := r.FormValue("sort"); v {
+	case "":
+		filter.Sort = database.SortDate
+		if ranked {
+			filter.Sort = database.SortRelevance
+		}
+	case database.SortDate, database.SortDateDesc, database.SortTitle, database.SortID:
+		filter.Sort = v
+	case database.SortRelevance:
+		if !ranked {
+			return filter, apierror.Field("sort", "порядок relevance возможен только при поиске по тексту")
+		}
+		filter.Sort = v
+	default:
+		return filter, apierror.Field("sort", "sort должен быть date, date_desc, title, id или relevance")
+	}
+
+	if v := r.FormValue("cursor"); v != "" {
+		after, err := decodeCursor(v, filter.Sort)
+		if err != nil {
+			return filter, apierror.Field("cursor", "некорректный курсор")
+		}
+		filter.After = &after
+	}
+	return filter, nil
+}
+
+// boolParam() возвращает значение логического параметра запроса или nil, если его нет
+func boolParam(r *http.Request, name string) (*bool, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, apierror.Field(name, fmt.Sprintf("%s должен быть true или false", name))
+	}
+	return &b, nil
+}
+
+// minDate() возвращает более раннюю из дат; пустая дата означает отсутствие границы
+func minDate(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return min(a, b)
+}
+
+// buildSearchFilter строит фильтр поиска задач: строка в формате 02.01.2006
+// ищется как дата, любая другая — как запрос поиска
+func buildSearchFilter(toSearch string) database.SearchFilter {
+	searchTime, err := time.Parse("02.01.2006", toSearch)
+	if err == nil {
+		return database.SearchFilter{Date: searchTime.Format("20060102")}
+	}
+	return database.SearchFilter{Text: toSearch}
+}
+
+// cursor — содержимое курсора страницы: порядок списка, ключ сортировки,
+// ранг в полнотекстовом поиске и id задачи
+type cursor struct {
+	Sort string  `json:"s"`
+	Key  string  `json:"k,omitempty"`
+	Rank float64 `json:"r,omitempty"`
+	ID   int64   `json:"i"`
+}
+
+// encodeCursor() возвращает курсор страницы, следующей за задачей task в списке
+// с порядком sort. Клиенту курсор непрозрачен
+func encodeCursor(task Task, sort string) string {
+	c := database.CursorOf(task, sort)
+	data, _ := json.Marshal(cursor{Sort: sort, Key: c.Key, Rank: c.Rank, ID: c.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor() разбирает курсор, выданный encodeCursor(). Курсор списка
+// с другим порядком не принимается
+func decodeCursor(value, sort string) (database.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return database.Cursor{}, err
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return database.Cursor{}, err
+	}
+	if c.Sort != sort {
+		return database.Cursor{}, errors.New("курсор выдан для другого порядка")
+	}
+	return database.Cursor{Key: c.Key, Rank: c.Rank, ID: c.ID}, nil
+}
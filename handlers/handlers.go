@@ -1,69 +1,179 @@
 package handlers
 
 import (
-	"crypto/sha256"
-	"database/sql"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
+	"final_project/apierror"
+	"final_project/auth"
 	"final_project/database"
 	"fmt"
 	"net/http"
-	"os"
+	"slices"
 	"strconv"
 	"time"
+)
+
+// Размер страницы /api/tasks по умолчанию и наибольший
+const (
+	TaskLimit    = 50
+	MaxTaskLimit = 500
+)
 
-	"github.com/golang-jwt/jwt"
-	_ "modernc.org/sqlite"
+// Ограничения /api/nextdates: количество дат по умолчанию и максимум
+// для режима limit, максимум дат и длина диапазона для режима from..to
+const (
+	DefaultNextDates  = 10
+	MaxNextDates      = 100
+	MaxRangeDates     = 1000
+	MaxNextDatesYears = 5
 )
 
-// Лимит задач, которые будут возвращаться при поиске
-const TaskLimit = 50
+type Task = database.Task
+
+// store — хранилище задач и учётных записей, с которым работают обработчики
+var store database.Store
 
-type Task struct {
-	ID      string `json:"id"`
-	Date    string `json:"date"`
-	Title   string `json:"title"`
-	Comment string `json:"comment"`
-	Repeat  string `json:"repeat"`
+// SetStore() задаёт хранилище задач и учётных записей для обработчиков
+func SetStore(s database.Store) {
+	store = s
 }
 
+// tasksOf() возвращает хранилище задач пользователя, от имени которого выполняется запрос
+func tasksOf(ctx context.Context) database.TaskStore {
+	return store.ForUser(auth.UserFromContext(ctx).ID)
+}
+
+// respondWithError() отправляет ошибку проверки запроса с кодом 400
 func respondWithError(rw http.ResponseWriter, msg string) {
-	rw.Write([]byte(fmt.Sprintf(`{"error":"%v"}`, msg)))
-	rw.WriteHeader(http.StatusBadRequest)
+	apierror.Write(rw, apierror.Validation(msg))
+}
+
+// respondWithStatus() отправляет ошибку с указанным HTTP-статусом
+func respondWithStatus(rw http.ResponseWriter, status int, msg string) {
+	apierror.Write(rw, apierror.New(status, msg))
 }
 
+// handledbError() отправляет ошибку хранилища с кодом 500. Отсутствующие
+// задачи, пользователи и токены отправляются с кодом 404, а ошибки API — как есть
 func handledbError(rw http.ResponseWriter, err error) {
-	respondWithError(rw, fmt.Sprintf("ошибка работы с БД %w", err))
+	var apiErr *apierror.Error
+	switch {
+	case errors.As(err, &apiErr):
+		apierror.Write(rw, apiErr)
+	case errors.Is(err, database.ErrNotFound), errors.Is(err, database.ErrUserNotFound),
+		errors.Is(err, database.ErrAPITokenNotFound), errors.Is(err, database.ErrTagNotFound):
+		apierror.Write(rw, apierror.NotFound(err.Error()))
+	default:
+		apierror.Write(rw, apierror.DB(err))
+	}
 }
 
-func queryRows(db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+// requireMethod() проверяет, что запрос выполнен одним из методов methods,
+// иначе отвечает ошибкой 405
+func requireMethod(rw http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if slices.Contains(methods, r.Method) {
+		return true
 	}
-	return rows, nil
+	apierror.MethodNotAllowed(rw, methods...)
+	return false
 }
 
 // NextDateHandler() обрабатывает GET-запросы по адресу /api/nextdate
 func NextDateHandler(rw http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if !requireMethod(rw, r, http.MethodGet) {
 		return
 	}
 	date, repeat := r.FormValue("date"), r.FormValue("repeat")
 	now, err := time.Parse("20060102", r.FormValue("now"))
 	if err != nil {
-		respondWithError(rw, err.Error())
+		apierror.Write(rw, apierror.Field("now", err.Error()))
 		return
 	}
 
 	newDate, err := NextDate(now, date, repeat)
 	if err != nil {
-		respondWithError(rw, err.Error())
+		apierror.Write(rw, apierror.Field("repeat", err.Error()))
 	} else {
 		rw.Write([]byte(newDate))
 	}
 }
 
+// NextDatesHandler() обрабатывает GET-запросы по адресу /api/nextdates:
+// возвращает ближайшие limit дат серии date+repeat после now (по умолчанию — сегодня)
+// или все даты серии в диапазоне from..to
+func NextDatesHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodGet) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	date, repeat := r.FormValue("date"), r.FormValue("repeat")
+	if repeat == "" {
+		apierror.Write(rw, apierror.Field("repeat", "не указано правило повторения"))
+		return
+	}
+
+	now := time.Now()
+	if v := r.FormValue("now"); v != "" {
+		parsed, err := time.Parse("20060102", v)
+		if err != nil {
+			apierror.Write(rw, apierror.Field("now", "некорректная дата now"))
+			return
+		}
+		now = parsed
+	}
+
+	limit := DefaultNextDates
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxNextDates {
+			apierror.Write(rw, apierror.Field("limit", fmt.Sprintf("limit должен быть от 1 до %d", MaxNextDates)))
+			return
+		}
+		limit = n
+	}
+
+	from, to := r.FormValue("from"), r.FormValue("to")
+	if from != "" || to != "" {
+		fromDate, err := time.Parse("20060102", from)
+		if err != nil {
+			apierror.Write(rw, apierror.Field("from", "некорректный диапазон дат from..to"))
+			return
+		}
+		toDate, err := time.Parse("20060102", to)
+		if err != nil {
+			apierror.Write(rw, apierror.Field("to", "некорректный диапазон дат from..to"))
+			return
+		}
+		if toDate.Before(fromDate) || toDate.After(fromDate.AddDate(MaxNextDatesYears, 0, 0)) {
+			apierror.Write(rw, apierror.Field("to", fmt.Sprintf("диапазон дат должен быть не длиннее %d лет", MaxNextDatesYears)))
+			return
+		}
+		// Даты ищутся строго после now, поэтому начинаем с предыдущего дня
+		now = fromDate.AddDate(0, 0, -1)
+		if r.FormValue("limit") == "" {
+			limit = MaxRangeDates
+		}
+	}
+
+	// Запрашиваем на одну дату больше, чтобы сообщить об обрезанном списке
+	dates, err := NextDates(now, date, repeat, limit+1, to)
+	if err != nil {
+		apierror.Write(rw, apierror.Field("repeat", err.Error()))
+		return
+	}
+	truncated := len(dates) > limit
+	if truncated {
+		dates = dates[:limit]
+	}
+
+	respondWithJSON(rw, struct {
+		Dates     []string `json:"dates"`
+		Truncated bool     `json:"truncated,omitempty"`
+	}{Dates: dates, Truncated: truncated})
+}
+
 // TaskHandler() обрабатывает запросы по адресу /api/task
 func TaskHandler(rw http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -76,219 +186,173 @@ func TaskHandler(rw http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete:
 		deleteTaskHandler(rw, r)
 	default:
-		rw.WriteHeader(http.StatusMethodNotAllowed)
+		apierror.MethodNotAllowed(rw, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
 	}
 
 }
 
-// TasksHandler обрабатывает GET-запросы по адресу /api/tasks
+// TasksHandler обрабатывает GET-запросы по адресу /api/tasks. Условия отбора
+// и порядок задач задаются параметрами, которые разбирает taskFilter(). Задачи
+// возвращаются страницами по limit штук (по умолчанию TaskLimit); если задачи остались,
+// в ответе есть next_cursor, который передаётся параметром cursor за следующей страницей
 func TasksHandler(rw http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if !requireMethod(rw, r, http.MethodGet) {
 		return
 	}
 	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	toSearch := r.FormValue("search")
-	db := database.DBconn
-
-	var (
-		query string
-		rows  *sql.Rows
-		err   error
-	)
-
-	if toSearch == "" {
-		query = `SELECT id, date, title, comment, repeat FROM scheduler ORDER BY date LIMIT :limit`
-		rows, err = queryRows(db, query, sql.Named("limit", TaskLimit))
-	} else {
-		query, rows, err = buildSearchQuery(db, toSearch)
+	limit := TaskLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxTaskLimit {
+			apierror.Write(rw, apierror.Field("limit", fmt.Sprintf("limit должен быть от 1 до %d", MaxTaskLimit)))
+			return
+		}
+		limit = n
 	}
 
+	filter, err := taskFilter(r, time.Now())
 	if err != nil {
-		handledbError(rw, err)
+		apierror.Write(rw, err)
 		return
 	}
-	defer rows.Close()
 
-	var tasks []Task
-	for rows.Next() {
-		var t Task
-		if err := rows.Scan(&t.ID, &t.Date, &t.Title, &t.Comment, &t.Repeat); err != nil {
-			handledbError(rw, err)
-			return
-		}
-		tasks = append(tasks, t)
+	// Запрашиваем на одну задачу больше, чтобы узнать, есть ли следующая страница
+	tasks, err := tasksOf(r.Context()).Search(filter, limit+1)
+	if err != nil {
+		handledbError(rw, err)
+		return
 	}
 
 	// Если задач нет, вернуть пустой массив
 	if tasks == nil {
 		tasks = []Task{}
 	}
-
-	respondWithJSON(rw, struct {
-		Tasks []Task `json:"tasks"`
-	}{Tasks: tasks})
-}
-
-// buildSearchQuery строит SQL-запрос для поиска задач
-func buildSearchQuery(db *sql.DB, toSearch string) (string, *sql.Rows, error) {
-	var query string
-	var rows *sql.Rows
-	var err error
-
-	searchTime, err := time.Parse("02.01.2006", toSearch)
-	if err == nil {
-		timeToFind := searchTime.Format("20060102")
-		query = `SELECT * FROM scheduler WHERE date = :date LIMIT :limit`
-		rows, err = queryRows(db, query, sql.Named("limit", TaskLimit), sql.Named("date", timeToFind))
-	} else {
-		query = `SELECT * FROM scheduler WHERE title LIKE :search OR comment LIKE :search ORDER BY date LIMIT :limit`
-		rows, err = queryRows(db, query, sql.Named("limit", TaskLimit), sql.Named("search", "%"+toSearch+"%"))
+	var next string
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+		next = encodeCursor(tasks[limit-1], filter.Sort)
 	}
 
-	return query, rows, err
+	respondWithJSON(rw, struct {
+		Tasks      []Task `json:"tasks"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}{Tasks: tasks, NextCursor: next})
 }
 
 // respondWithJSON отправляет ответ в формате JSON
 func respondWithJSON(rw http.ResponseWriter, data interface{}) {
-	response, err := json.Marshal(data)
-	if err != nil {
-		respondWithError(rw, fmt.Sprintf("ошибка сериализации: %w", err))
-		return
-	}
-	rw.WriteHeader(http.StatusOK)
-	rw.Write(response)
+	apierror.JSON(rw, http.StatusOK, data)
 }
 
 // TaskDoneHandler() обрабатывает POST-запросы по адресу /api/task/done
 func TaskDoneHandler(rw http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	if !requireMethod(rw, r, http.MethodPost) {
 		return
 	}
 	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	id := r.FormValue("id")
-	if id == "" {
-		respondWithError(rw, "не указан идентификатор")
-		return
-	}
-
-	db := database.DBconn
-
-	idInt, err := strconv.Atoi(id)
+	id, err := taskID(r)
 	if err != nil {
-		respondWithError(rw, fmt.Sprintf("ошибка преобразования id: %w", err))
+		apierror.Write(rw, err)
 		return
 	}
 
-	task, err := getTaskByID(db, idInt)
+	task, err := tasksOf(r.Context()).Get(id)
 	if err != nil {
-		respondWithError(rw, err.Error())
+		handledbError(rw, err)
 		return
 	}
 
-	if len(task.Repeat) == 0 {
-		deleteTaskByID(db, idInt, rw)
-	} else {
-		updateTaskDate(db, idInt, task, rw)
-	}
-
-	rw.WriteHeader(http.StatusOK)
-	rw.Write([]byte(`{}`))
-}
-
-func getTaskByID(db *sql.DB, id int) (Task, error) {
-	query := `SELECT id, date, title, comment, repeat FROM scheduler WHERE id = :id`
-	row := db.QueryRow(query, sql.Named("id", id))
-
-	var task Task
-	err := row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
+	nextDate, err := completionDate(time.Now(), task)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return task, fmt.Errorf("запись не найдена")
-		}
-		return task, fmt.Errorf("ошибка работы с БД: %w", err)
+		apierror.Write(rw, apierror.Field("repeat", fmt.Sprintf("ошибка обновления даты: %v", err)))
+		return
 	}
-	return task, nil
-}
 
-func deleteTaskByID(db *sql.DB, id int, rw http.ResponseWriter) {
-	query := `DELETE FROM scheduler WHERE id = :id`
-	res, err := db.Exec(query, sql.Named("id", id))
-	if err != nil {
+	if err := tasksOf(r.Context()).Complete(id, nextDate); err != nil {
 		handledbError(rw, err)
 		return
 	}
-	if rows, err := res.RowsAffected(); err != nil || rows == 0 {
-		respondWithError(rw, "задача не найдена")
-		return
-	}
+	respondWithJSON(rw, struct{}{})
 }
 
-func updateTaskDate(db *sql.DB, id int, task Task, rw http.ResponseWriter) {
-	nextDate, err := NextDate(time.Now(), task.Date, task.Repeat)
-	if err != nil {
-		respondWithError(rw, fmt.Sprintf("ошибка обновления даты: %w", err))
-		return
-	}
-
-	query := `UPDATE scheduler SET date = :date WHERE id = :id`
-	res, err := db.Exec(query, sql.Named("id", id), sql.Named("date", nextDate))
-	if err != nil {
-		handledbError(rw, err)
-		return
+// completionDate() возвращает дату, на которую переносится выполненная задача,
+// или пустую строку, если задачу нужно удалить
+func completionDate(now time.Time, task Task) (string, error) {
+	if len(task.Repeat) == 0 {
+		return "", nil
 	}
-	if rows, err := res.RowsAffected(); err != nil || rows == 0 {
-		respondWithError(rw, "задача не найдена")
-		return
+	nextDate, err := NextTaskDate(now, task)
+	// Если серия повторений закончилась, задача удаляется как разовая
+	if errors.Is(err, ErrSeriesEnded) {
+		return "", nil
 	}
+	return nextDate, err
 }
 
-// SignInHandler() обрабатывает POST-запросы по адресу /api/signin
+// SignInHandler() обрабатывает POST-запросы по адресу /api/signin.
+// Без логина входит администратор, как до появления учётных записей.
+// Частые неудачные попытки с одного адреса ограничиваются ответом 429.
+// Если пользователю нужен код второго фактора, а он не передан, вместо токенов
+// возвращается {"mfa_required": true, "mfa_token"}
 func SignInHandler(rw http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	if !requireMethod(rw, r, http.MethodPost) {
 		return
 	}
 	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	var p struct {
+		Login    string `json:"login"`
 		Password string `json:"password"`
+		Code     string `json:"code"`
+		MFAToken string `json:"mfa_token"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&p)
 	if err != nil {
-		respondWithError(rw, fmt.Sprintf("ошибка десериализации: %w", err))
+		respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
 		return
 	}
 	defer r.Body.Close()
 
-	if p.Password == os.Getenv("TODO_PASSWORD") {
-		token, err := generateJWTToken()
-		if err != nil {
-			respondWithError(rw, fmt.Sprintf("ошибка генерации токена: %w", err))
-			return
-		}
-		rw.WriteHeader(http.StatusOK)
-		rw.Write([]byte(fmt.Sprintf(`{"token":"%v"}`, token)))
-
+	// Пользователь с двухфакторной аутентификацией передаёт код вместе с паролем
+	// или вторым запросом с токеном mfa_token, выданным в ответ на верный пароль
+	var user database.User
+	ip := auth.ClientIP(r)
+	if p.MFAToken != "" {
+		user, err = auth.CompleteSignIn(p.MFAToken, p.Code, ip)
 	} else {
-		respondWithError(rw, "Неверный пароль")
+		user, err = auth.SignIn(p.Login, p.Password, ip)
+		if errors.Is(err, auth.ErrSecondFactorRequired) {
+			if p.Code == "" {
+				respondWithMFAToken(rw, user)
+				return
+			}
+			err = auth.SecondFactor(user, p.Code, ip)
+		}
 	}
-}
-
-func generateJWTToken() (string, error) {
-	secret := []byte(os.Getenv("TODO_PASSWORD"))
-	hash := sha256.Sum256([]byte(os.Getenv("TODO_PASSWORD")))
-
-	claims := jwt.MapClaims{
-		"hash": hex.EncodeToString(hash[:]),
+	var throttled *auth.ThrottleError
+	if errors.As(err, &throttled) {
+		auth.SetRetryAfter(rw, throttled.RetryAfter)
+		respondWithStatus(rw, http.StatusTooManyRequests, throttled.Error())
+		return
+	}
+	if errors.Is(err, auth.ErrBadCredentials) {
+		respondWithStatus(rw, http.StatusUnauthorized, "Неверный логин или пароль")
+		return
+	}
+	if errors.Is(err, auth.ErrBadCode) {
+		apierror.Write(rw, apierror.New(http.StatusUnauthorized, "Неверный код").For("code"))
+		return
+	}
+	if errors.Is(err, auth.ErrInvalidToken) {
+		respondWithStatus(rw, http.StatusUnauthorized, "время на ввод кода истекло, войдите заново")
+		return
 	}
-
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := jwtToken.SignedString(secret)
 	if err != nil {
-		return "", fmt.Errorf("не удалось подписать JWT: %v", err)
+		handledbError(rw, err)
+		return
 	}
-
-	return signedToken, nil
+	respondWithTokens(rw, user)
 }
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"final_project/auth"
+	"final_project/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useMemoryStore() подменяет хранилище обработчиков и учётных записей пустым хранилищем в памяти.
+func useMemoryStore(t *testing.T) *database.MemoryStore {
+	t.Helper()
+	mem := database.NewMemoryStore()
+	prev := store
+	SetStore(mem)
+	auth.SetUsers(mem)
+	t.Cleanup(func() {
+		SetStore(prev)
+		auth.SetUsers(prev)
+	})
+	return mem
+}
+
+// doRequest() выполняет запрос к обработчику и возвращает разобранный JSON-ответ.
+func doRequest(t *testing.T, h http.HandlerFunc, method, target string, body any) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &m), rec.Body.String())
+	return m
+}
+
+func TestTaskHandlerCRUD(t *testing.T) {
+	mem := useMemoryStore(t)
+	today := time.Now().Format("20060102")
+
+	m := doRequest(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{
+		"date": today, "title": "Созвон", "comment": "в 16:00", "repeat": "d 5",
+	})
+	require.NotContains(t, m, "error")
+	id, _ := m["id"].(string)
+	require.NotEmpty(t, id)
+
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+id, nil)
+	assert.Equal(t, id, m["id"])
+	assert.Equal(t, today, m["date"])
+	assert.Equal(t, "Созвон", m["title"])
+
+	m = doRequest(t, TaskHandler, http.MethodPut, "/api/task", map[string]any{
+		"id": id, "date": today, "title": "Созвон в 17:00", "comment": "", "repeat": "",
+	})
+	assert.Empty(t, m)
+	tasks, err := mem.List(10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "Созвон в 17:00", tasks[0].Title)
+
+	m = doRequest(t, TaskHandler, http.MethodDelete, "/api/task?id="+id, nil)
+	assert.Empty(t, m)
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+id, nil)
+	assert.Contains(t, m, "error")
+}
+
+func TestTaskHandlerValidation(t *testing.T) {
+	useMemoryStore(t)
+
+	for _, body := range []map[string]any{
+		{"date": "20240129", "title": ""},
+		{"date": "20240192", "title": "Qwerty"},
+		{"date": "28.01.2024", "title": "Заголовок"},
+		{"date": "20240212", "title": "Заголовок", "repeat": "ooops"},
+	} {
+		m := doRequest(t, TaskHandler, http.MethodPost, "/api/task", body)
+		assert.Contains(t, m, "error", "ожидается ошибка для %v", body)
+	}
+
+	m := doRequest(t, TaskHandler, http.MethodPut, "/api/task", map[string]any{"id": "7645346343", "title": "Тест"})
+	assert.Contains(t, m, "error")
+	m = doRequest(t, TaskHandler, http.MethodDelete, "/api/task?id=wjhgese", nil)
+	assert.Contains(t, m, "error")
+}
+
+func TestTaskDoneHandler(t *testing.T) {
+	mem := useMemoryStore(t)
+	now := time.Now()
+
+	id, err := mem.Create(Task{Date: now.Format("20060102"), Title: "Разовая"})
+	require.NoError(t, err)
+	m := doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+strconv.FormatInt(id, 10), nil)
+	assert.Empty(t, m)
+	_, err = mem.Get(id)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	id, err = mem.Create(Task{Date: now.Format("20060102"), Title: "Повтор", Repeat: "d 3"})
+	require.NoError(t, err)
+	m = doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+strconv.FormatInt(id, 10), nil)
+	assert.Empty(t, m)
+	task, err := mem.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 3).Format("20060102"), task.Date)
+}
+
+func TestTaskDoneSeriesEnd(t *testing.T) {
+	mem := useMemoryStore(t)
+	now := time.Now()
+	today := now.Format("20060102")
+
+	// Счётчик: два выполнения, после второго задача удаляется
+	m := doRequest(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{
+		"date": today, "title": "Дважды", "repeat": "d 1", "repeat_count": 2,
+	})
+	require.NotContains(t, m, "error")
+	id, err := strconv.ParseInt(m["id"].(string), 10, 64)
+	require.NoError(t, err)
+
+	m = doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+m["id"].(string), nil)
+	assert.Empty(t, m)
+	task, err := mem.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, task.RepeatCount)
+	assert.Equal(t, now.AddDate(0, 0, 1).Format("20060102"), task.Date)
+
+	doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+task.ID, nil)
+	_, err = mem.Get(id)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Дата окончания: следующая дата позже repeat_until, задача удаляется
+	id, err = mem.Create(Task{Date: today, Title: "До завтра", Repeat: "d 2", RepeatUntil: now.AddDate(0, 0, 1).Format("20060102")})
+	require.NoError(t, err)
+	doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+strconv.FormatInt(id, 10), nil)
+	_, err = mem.Get(id)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+task.ID, nil)
+	assert.Contains(t, m, "error")
+
+	// Условия окончания в правиле RFC 5545 хранилище переносит в поля: после
+	// последнего выполнения задача удаляется, а не переносится на следующую дату
+	id, err = mem.Create(Task{Date: today, Title: "Правило", Repeat: "FREQ=DAILY;COUNT=2"})
+	require.NoError(t, err)
+	doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+strconv.FormatInt(id, 10), nil)
+	task, err = mem.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 1).Format("20060102"), task.Date)
+	doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+task.ID, nil)
+	_, err = mem.Get(id)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	id, err = mem.Create(Task{Date: today, Title: "Правило до завтра", Repeat: "d 1"})
+	require.NoError(t, err)
+	task, err = mem.Get(id)
+	require.NoError(t, err)
+	task.Repeat = "RRULE:FREQ=DAILY;UNTIL=" + now.AddDate(0, 0, 1).Format("20060102") + "T235959Z"
+	require.NoError(t, mem.Update(task))
+	doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+task.ID, nil)
+	doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+task.ID, nil)
+	_, err = mem.Get(id)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestTaskDoneRRuleCount(t *testing.T) {
+	mem := useMemoryStore(t)
+	now := time.Now()
+	today := now.Format("20060102")
+
+	// Серия из трёх дат заканчивается ровно после трёх выполнений
+	m := doRequest(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{
+		"date": today, "title": "Трижды", "repeat": "FREQ=DAILY;COUNT=3",
+	})
+	require.NotContains(t, m, "error")
+	id := m["id"].(string)
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+id, nil)
+	assert.Equal(t, "FREQ=DAILY", m["repeat"])
+	assert.Equal(t, float64(3), m["repeat_count"])
+
+	done := 0
+	for ; done < 10; done++ {
+		if _, err := mem.Get(mustParseID(t, id)); err != nil {
+			require.ErrorIs(t, err, database.ErrNotFound)
+			break
+		}
+		m = doRequest(t, TaskDoneHandler, http.MethodPost, "/api/task/done?id="+id, nil)
+		require.Empty(t, m)
+	}
+	assert.Equal(t, 3, done)
+
+	// Прошедшие даты серии вычитаются из COUNT при переносе задачи на сегодня
+	m = doRequest(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{
+		"date": now.AddDate(0, 0, -2).Format("20060102"), "title": "Опоздала", "repeat": "RRULE:FREQ=DAILY;COUNT=3",
+	})
+	require.NotContains(t, m, "error")
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+m["id"].(string), nil)
+	assert.Equal(t, today, m["date"])
+	assert.Equal(t, "RRULE:FREQ=DAILY", m["repeat"])
+	assert.Equal(t, float64(1), m["repeat_count"])
+
+	m = doRequest(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{
+		"date": now.AddDate(0, 0, -2).Format("20060102"), "title": "Закончилась", "repeat": "FREQ=DAILY;COUNT=2",
+	})
+	assert.Contains(t, m, "error")
+}
+
+// mustParseID() разбирает идентификатор задачи из ответа API.
+func mustParseID(t *testing.T, id string) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(id, 10, 64)
+	require.NoError(t, err)
+	return n
+}
+
+func TestTaskRepeatEndValidation(t *testing.T) {
+	useMemoryStore(t)
+	today := time.Now().Format("20060102")
+
+	for _, body := range []map[string]any{
+		{"date": today, "title": "Без повтора", "repeat_count": 3},
+		{"date": today, "title": "Без повтора", "repeat_until": "20990101"},
+		{"date": today, "title": "Плохая дата", "repeat": "d 1", "repeat_until": "2099-01-01"},
+		{"date": today, "title": "В прошлом", "repeat": "d 1", "repeat_until": "20000101"},
+		{"date": today, "title": "Отрицательный", "repeat": "d 1", "repeat_count": -1},
+	} {
+		m := doRequest(t, TaskHandler, http.MethodPost, "/api/task", body)
+		assert.Contains(t, m, "error", "ожидается ошибка для %v", body)
+	}
+
+	m := doRequest(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{
+		"date": today, "title": "Ок", "repeat": "w 1,3", "repeat_until": "20990101", "repeat_count": 5,
+	})
+	require.NotContains(t, m, "error")
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+m["id"].(string), nil)
+	assert.Equal(t, "20990101", m["repeat_until"])
+	assert.Equal(t, float64(5), m["repeat_count"])
+}
+
+func TestTasksHandlerSearch(t *testing.T) {
+	mem := useMemoryStore(t)
+
+	for _, task := range []Task{
+		{Date: "20240203", Title: "Позвонить в УК"},
+		{Date: "20240201", Title: "Бассейн"},
+		{Date: "20240203", Title: "Встреча"},
+	} {
+		_, err := mem.Create(task)
+		require.NoError(t, err)
+	}
+
+	count := func(target string) int {
+		m := doRequest(t, TasksHandler, http.MethodGet, target, nil)
+		tasks, ok := m["tasks"].([]any)
+		require.True(t, ok, "ожидается массив tasks: %v", m)
+		return len(tasks)
+	}
+
+	assert.Equal(t, 3, count("/api/tasks"))
+	assert.Equal(t, 1, count("/api/tasks?search="+url.QueryEscape("УК")))
+	assert.Equal(t, 2, count("/api/tasks?search=03.02.2024"))
+	assert.Equal(t, 0, count("/api/tasks?search="+url.QueryEscape("ничего")))
+}
+
+func TestTasksHandlerPages(t *testing.T) {
+	mem := useMemoryStore(t)
+	for i := 0; i < 7; i++ {
+		_, err := mem.Create(Task{Date: "2024020" + strconv.Itoa(i%3+1), Title: "Отчёт " + strconv.Itoa(i)})
+		require.NoError(t, err)
+	}
+	_, err := mem.Create(Task{Date: "20240201", Title: "Бассейн"})
+	require.NoError(t, err)
+
+	// walk() проходит все страницы и возвращает id задач и число страниц
+	walk := func(query string) ([]string, int) {
+		var ids []string
+		pages := 0
+		target := "/api/tasks?limit=3" + query
+		for {
+			m := doRequest(t, TasksHandler, http.MethodGet, target, nil)
+			require.NotContains(t, m, "error")
+			pages++
+			for _, task := range m["tasks"].([]any) {
+				ids = append(ids, task.(map[string]any)["id"].(string))
+			}
+			next, ok := m["next_cursor"].(string)
+			if !ok {
+				return ids, pages
+			}
+			require.NotEmpty(t, next)
+			target = "/api/tasks?limit=3" + query + "&cursor=" + url.QueryEscape(next)
+		}
+	}
+
+	all, err := mem.Search(database.SearchFilter{}, 0)
+	require.NoError(t, err)
+	var want []string
+	for _, task := range all {
+		want = append(want, task.ID)
+	}
+	ids, pages := walk("")
+	assert.Equal(t, want, ids)
+	assert.Equal(t, 3, pages)
+
+	ids, pages = walk("&search=" + url.QueryEscape("Отчёт"))
+	assert.Len(t, ids, 7)
+	assert.Equal(t, 3, pages)
+
+	// Задачи одной даты делятся между страницами по id
+	ids, pages = walk("&search=01.02.2024")
+	assert.Len(t, ids, 4)
+	assert.Equal(t, 2, pages)
+
+	for _, target := range []string{"/api/tasks?limit=0", "/api/tasks?limit=501", "/api/tasks?cursor=%21%21", "/api/tasks?cursor=MjAyNA"} {
+		m := doRequest(t, TasksHandler, http.MethodGet, target, nil)
+		assert.Contains(t, m, "error", target)
+	}
+}
+
+func TestTasksHandlerFilters(t *testing.T) {
+	mem := useMemoryStore(t)
+	now := time.Now()
+	day := func(offset int) string { return now.AddDate(0, 0, offset).Format("20060102") }
+	for _, task := range []Task{
+		{Date: day(-3), Title: "Просроченный отчёт"},
+		{Date: day(-1), Title: "Полить цветы", Repeat: "d 3"},
+		{Date: day(0), Title: "Созвон", Repeat: "w 1,2,3,4,5,6,7"},
+		{Date: day(0), Title: "Отчёт за неделю"},
+		{Date: day(2), Title: "Квартплата", Repeat: "m 10"},
+		{Date: day(5), Title: "Отпуск"},
+	} {
+		_, err := mem.Create(task)
+		require.NoError(t, err)
+	}
+
+	titles := func(query string) []string {
+		t.Helper()
+		m := doRequest(t, TasksHandler, http.MethodGet, "/api/tasks?"+query, nil)
+		require.NotContains(t, m, "error", query)
+		titles := []string{}
+		for _, task := range m["tasks"].([]any) {
+			titles = append(titles, task.(map[string]any)["title"].(string))
+		}
+		return titles
+	}
+
+	for query, want := range map[string][]string{
+		"overdue=true":                                         {"Просроченный отчёт", "Полить цветы"},
+		"today=true":                                           {"Созвон", "Отчёт за неделю"},
+		"today=true&repeating=false":                           {"Отчёт за неделю"},
+		"overdue=true&repeat=d":                                {"Полить цветы"},
+		"repeating=true&sort=date_desc":                        {"Квартплата", "Созвон", "Полить цветы"},
+		"repeating=false&sort=title":                           {"Отпуск", "Отчёт за неделю", "Просроченный отчёт"},
+		"from=" + day(0) + "&to=" + day(5):                     {"Созвон", "Отчёт за неделю", "Квартплата", "Отпуск"},
+		"from=" + day(-2) + "&overdue=true":                    {"Полить цветы"},
+		"search=" + url.QueryEscape("ОТЧЕТ"):                   {"Просроченный отчёт", "Отчёт за неделю"},
+		"search=" + url.QueryEscape(`"отчёт за"`):              {"Отчёт за неделю"},
+		"search=" + url.QueryEscape("отч") + "&sort=date_desc": {"Отчёт за неделю", "Просроченный отчёт"},
+		"search=" + url.QueryEscape("repeat:none -title:отпуск OR repeat:m"): {"Просроченный отчёт", "Отчёт за неделю", "Квартплата"},
+		"sort=id&to=" + day(-1):                 {"Просроченный отчёт", "Полить цветы"},
+		"from=" + day(3) + "&today=true":        {},
+		"overdue=false&repeat=m&sort=date_desc": {"Квартплата"},
+	} {
+		assert.Equal(t, want, titles(query), query)
+	}
+
+	// Курсор действует только для того порядка, в котором выдан
+	m := doRequest(t, TasksHandler, http.MethodGet, "/api/tasks?sort=title&limit=2", nil)
+	next := m["next_cursor"].(string)
+	m = doRequest(t, TasksHandler, http.MethodGet, "/api/tasks?sort=title&limit=2&cursor="+next, nil)
+	require.NotContains(t, m, "error")
+	assert.Equal(t, "Отчёт за неделю", m["tasks"].([]any)[0].(map[string]any)["title"])
+	code, e := apiError(t, TasksHandler, http.MethodGet, "/api/tasks?sort=date&cursor="+next, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, e.Details, "cursor")
+
+	for query, field := range map[string]string{
+		"from=2024-01-01":                "from",
+		"from=20240201&to=20240101":      "to",
+		"overdue=yes":                    "overdue",
+		"overdue=true&today=true":        "today",
+		"repeat=q":                       "repeat",
+		"repeating=false&repeat=w":       "repeat",
+		"sort=comment":                   "sort",
+		"sort=relevance":                 "sort",
+		"search=repeat:w&sort=relevance": "sort",
+		"search=title:":                  "search",
+		"search=before:2024-12-01":       "search",
+		"repeating=maybe&sort=title":     "repeating",
+	} {
+		code, e := apiError(t, TasksHandler, http.MethodGet, "/api/tasks?"+query, nil)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.Contains(t, e.Details, field, query)
+	}
+}
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"final_project/apierror"
+
+	"github.com/emersion/go-ical"
+)
+
+// Идентификатор программы в экспортируемом календаре и домен UID задач
+const (
+	icsProdID    = "-//go_final_project//scheduler//RU"
+	icsUIDDomain = "scheduler"
+)
+
+// Свойства с исходным правилом повторения задачи и условиями окончания серии,
+// чтобы при импорте восстановить их без потерь, даже если RRULE передаёт правило
+// лишь приблизительно или не передаёт вовсе
+const (
+	PropSchedulerRepeat      = "X-SCHEDULER-REPEAT"
+	PropSchedulerRepeatUntil = "X-SCHEDULER-REPEAT-UNTIL"
+	PropSchedulerRepeatCount = "X-SCHEDULER-REPEAT-COUNT"
+)
+
+// Номера дней недели краткого синтаксиса (1 — понедельник) в нотации RFC 5545
+var icsWeekdays = [...]string{1: "MO", 2: "TU", 3: "WE", 4: "TH", 5: "FR", 6: "SA", 7: "SU"}
+
+// TasksICSHandler() обрабатывает GET-запросы по адресу /api/tasks.ics и отдаёт все задачи
+// в формате iCalendar. По умолчанию задачи выгружаются как VTODO, с параметром
+// component=vevent — как события на весь день, которые показывают все календари
+func TasksICSHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodGet) {
+		return
+	}
+
+	var kind string
+	switch strings.ToLower(r.FormValue("component")) {
+	case "", "vtodo":
+		kind = ical.CompToDo
+	case "vevent":
+		kind = ical.CompEvent
+	default:
+		apierror.Write(rw, apierror.Field("component", "component должен быть vtodo или vevent"))
+		return
+	}
+
+	tasks, err := tasksOf(r.Context()).List(0)
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+
+	// Календарь формируется целиком до ответа, чтобы при ошибке вернуть её с кодом 500
+	body, err := encodeCalendar(tasksCalendar(tasks, kind, time.Now()))
+	if err != nil {
+		respondWithStatus(rw, http.StatusInternalServerError, fmt.Sprintf("ошибка формирования календаря: %v", err))
+		return
+	}
+	rw.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	rw.Write(body)
+}
+
+// encodeCalendar() сериализует календарь. Календарь без задач go-ical не сериализует,
+// поэтому он записывается вручную — только с обязательными свойствами
+func encodeCalendar(cal *ical.Calendar) ([]byte, error) {
+	if len(cal.Children) == 0 {
+		return []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:" + icsProdID + "\r\nEND:VCALENDAR\r\n"), nil
+	}
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tasksCalendar собирает календарь из задач, каждая задача — компонент kind (VTODO или VEVENT)
+func tasksCalendar(tasks []Task, kind string, stamp time.Time) *ical.Calendar {
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, icsProdID)
+
+	for _, task := range tasks {
+		comp, err := taskComponent(task, kind, stamp)
+		if err != nil {
+			log.Println("задача не выгружена в календарь: ", err)
+			continue
+		}
+		cal.Children = append(cal.Children, comp)
+	}
+	return cal
+}
+
+// taskComponent преобразует задачу в компонент календаря на весь день
+func taskComponent(task Task, kind string, stamp time.Time) (*ical.Component, error) {
+	date, err := time.Parse("20060102", task.Date)
+	if err != nil {
+		return nil, fmt.Errorf("задача %s: некорректная дата %q", task.ID, task.Date)
+	}
+
+	comp := ical.NewComponent(kind)
+	comp.Props.SetText(ical.PropUID, taskUID(task))
+	comp.Props.SetDateTime(ical.PropDateTimeStamp, stamp.UTC())
+	comp.Props.SetDate(ical.PropDateTimeStart, date)
+	comp.Props.SetText(ical.PropSummary, task.Title)
+	if task.Comment != "" {
+		comp.Props.SetText(ical.PropDescription, task.Comment)
+	}
+	if kind == ical.CompToDo {
+		comp.Props.SetText(ical.PropStatus, "NEEDS-ACTION")
+	}
+	if len(task.Tags) > 0 {
+		prop := ical.NewProp(ical.PropCategories)
+		prop.SetTextList(task.Tags)
+		comp.Props.Set(prop)
+	}
+
+	if task.Repeat != "" {
+		comp.Props.SetText(PropSchedulerRepeat, task.Repeat)
+		if task.RepeatUntil != "" {
+			comp.Props.SetText(PropSchedulerRepeatUntil, task.RepeatUntil)
+		}
+		if task.RepeatCount > 0 {
+			comp.Props.SetText(PropSchedulerRepeatCount, strconv.Itoa(task.RepeatCount))
+		}
+		if rule, rdate, err := taskRRule(task); err == nil {
+			if rule != "" {
+				prop := ical.NewProp(ical.PropRecurrenceRule)
+				prop.Value = rule
+				comp.Props.Set(prop)
+			}
+			if rdate {
+				comp.Props.SetDate(ical.PropRecurrenceDates, date)
+			}
+		}
+	}
+	return comp, nil
+}
+
+// taskUID возвращает постоянный UID задачи в календаре: заданный клиентом CalDAV
+// или построенный по id
+func taskUID(task Task) string {
+	if task.UID != "" {
+		return task.UID
+	}
+	return fmt.Sprintf("task-%s@%s", task.ID, icsUIDDomain)
+}
+
+// taskRRule возвращает правило RFC 5545 для повторения задачи с учётом условий
+// окончания серии. Условия из правила RRULE имеют приоритет над полями задачи.
+//
+// Дата задачи не обязана подходить под краткое правило (w, m), а календари
+// такую DTSTART в серию не включают. Тогда rdate равен true: дату задачи нужно
+// добавить в серию через RDATE, а COUNT уменьшается на одно выполнение
+func taskRRule(task Task) (rule string, rdate bool, err error) {
+	rule, err = repeatToRRule(task.Repeat)
+	if err != nil {
+		return "", false, err
+	}
+
+	r, err := parseRRule(rule)
+	if err != nil {
+		return "", false, err
+	}
+
+	count := task.RepeatCount
+	if !isRRule(task.Repeat) && !startMatchesRepeat(task) {
+		rdate = true
+		if count == 1 {
+			// Кроме даты задачи выполнений не осталось
+			return "", false, nil
+		}
+		if count > 0 {
+			count--
+		}
+	}
+
+	switch {
+	case r.count > 0 || !r.until.IsZero():
+	case count > 0:
+		rule += ";COUNT=" + strconv.Itoa(count)
+	case task.RepeatUntil != "":
+		rule += ";UNTIL=" + task.RepeatUntil
+	}
+	return rule, rdate, nil
+}
+
+// startMatchesRepeat проверяет, подходит ли дата задачи под её краткое правило
+// повторения. Правила d и y отсчитываются от даты задачи и подходят всегда
+func startMatchesRepeat(task Task) bool {
+	if task.Repeat[0] != 'w' && task.Repeat[0] != 'm' {
+		return true
+	}
+	date, err := time.Parse("20060102", task.Date)
+	if err != nil {
+		return false
+	}
+	// Для w и m ищется ближайший подходящий день после предыдущего
+	prev := date.AddDate(0, 0, -1)
+	next, err := NextDate(prev, prev.Format("20060102"), task.Repeat)
+	return err == nil && next == task.Date
+}
+
+// repeatToRRule переводит правило повторения в формат RFC 5545 (без префикса RRULE:).
+// Сочетание чисел месяца с днями недели (m 1,w5:-1) одним RRULE не выражается
+func repeatToRRule(repeat string) (string, error) {
+	if isRRule(repeat) {
+		rule := strings.ToUpper(strings.TrimSpace(repeat))
+		return strings.TrimPrefix(rule, "RRULE:"), nil
+	}
+
+	repeatError := fmt.Errorf("[repeatToRRule]: правило %q не переводится в RRULE", repeat)
+	parts := strings.Split(repeat, " ")
+
+	switch parts[0] {
+	case "d":
+		if len(parts) != 2 {
+			return "", repeatError
+		}
+		d, err := strconv.Atoi(parts[1])
+		if err != nil || d <= 0 || d > 400 {
+			return "", repeatError
+		}
+		return "FREQ=DAILY;INTERVAL=" + strconv.Itoa(d), nil
+	case "y":
+		if len(parts) != 1 {
+			return "", repeatError
+		}
+		return "FREQ=YEARLY", nil
+	case "w":
+		if len(parts) != 2 {
+			return "", repeatError
+		}
+		weekdays := parseWeekdays(parts[1], repeatError)
+		if weekdays == nil {
+			return "", repeatError
+		}
+		var days []string
+		for wd := 1; wd <= 7; wd++ {
+			if weekdays[wd] {
+				days = append(days, icsWeekdays[wd])
+			}
+		}
+		return "FREQ=WEEKLY;BYDAY=" + strings.Join(days, ","), nil
+	case "m":
+		return monthlyToRRule(parts, repeatError)
+	default:
+		return "", repeatError
+	}
+}
+
+func monthlyToRRule(parts []string, repeatError error) (string, error) {
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", repeatError
+	}
+
+	numbers, weekdays, ok := parseMonthWeekdays(parts[1])
+	if !ok || (numbers != "" && len(weekdays) > 0) {
+		return "", repeatError
+	}
+
+	rule := "FREQ=MONTHLY"
+	if numbers != "" {
+		monthDays, last, prelast := parseMonthDays(numbers, repeatError)
+		if monthDays == nil {
+			return "", repeatError
+		}
+		var days []string
+		for _, md := range sortedKeys(monthDays) {
+			days = append(days, strconv.Itoa(md))
+		}
+		if prelast {
+			days = append(days, "-2")
+		}
+		if last {
+			days = append(days, "-1")
+		}
+		rule += ";BYMONTHDAY=" + strings.Join(days, ",")
+	} else {
+		var days []string
+		for _, mw := range weekdays {
+			days = append(days, strconv.Itoa(mw.n)+icsWeekdays[mw.weekday])
+		}
+		rule += ";BYDAY=" + strings.Join(days, ",")
+	}
+
+	if len(parts) == 3 {
+		months := parseMonths(parts, repeatError)
+		if months == nil {
+			return "", repeatError
+		}
+		var list []string
+		for _, m := range sortedKeys(months) {
+			list = append(list, strconv.Itoa(m))
+		}
+		rule += ";BYMONTH=" + strings.Join(list, ",")
+	}
+	return rule, nil
+}
+
+// sortedKeys возвращает ключи множества по возрастанию
+func sortedKeys(set map[int]bool) []int {
+	keys := make([]int, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// rruleToRepeat переводит правило RFC 5545 в правило повторения планировщика.
+// COUNT и UNTIL возвращаются отдельно как условия окончания серии. Если правило
+// выражается кратким синтаксисом (d, w, m, y), возвращается он, иначе — само RRULE
+func rruleToRepeat(value string, start time.Time) (repeat, until string, count int, err error) {
+	r, err := parseRRule(value)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if !r.until.IsZero() {
+		until = r.until.Format("20060102")
+	}
+	if short, ok := shortRepeat(r, start); ok {
+		return short, until, r.count, nil
+	}
+
+	var parts []string
+	for _, part := range strings.Split(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "RRULE:"), ";") {
+		if part != "" && !strings.HasPrefix(part, "COUNT=") && !strings.HasPrefix(part, "UNTIL=") {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ";"), until, r.count, nil
+}
+
+// shortRepeat подбирает краткое правило, дающее те же даты, что и r с началом start
+func shortRepeat(r *rrule, start time.Time) (string, bool) {
+	if len(r.bySetPos) > 0 {
+		return "", false
+	}
+
+	switch r.freq {
+	case "DAILY":
+		if len(r.byDay) > 0 || len(r.byMonthDay) > 0 || len(r.byMonth) > 0 || r.interval > 400 {
+			return "", false
+		}
+		return "d " + strconv.Itoa(r.interval), true
+	case "WEEKLY":
+		if len(r.byMonth) > 0 {
+			return "", false
+		}
+		if r.interval == 1 {
+			weekdays := map[int]bool{isoWeekday(start): true}
+			if len(r.byDay) > 0 {
+				weekdays = map[int]bool{}
+				for _, wd := range r.byDay {
+					weekdays[isoWeekdayNumber(wd.weekday)] = true
+				}
+			}
+			var days []string
+			for _, wd := range sortedKeys(weekdays) {
+				days = append(days, strconv.Itoa(wd))
+			}
+			return "w " + strings.Join(days, ","), true
+		}
+		// Раз в несколько недель в день начала серии — это повтор через 7*INTERVAL дней
+		if (len(r.byDay) == 0 || (len(r.byDay) == 1 && r.byDay[0].weekday == start.Weekday())) && 7*r.interval <= 400 {
+			return "d " + strconv.Itoa(7*r.interval), true
+		}
+		return "", false
+	case "MONTHLY":
+		if r.interval != 1 {
+			return "", false
+		}
+		return monthlyRepeat(r, start)
+	case "YEARLY":
+		if r.interval != 1 {
+			return "", false
+		}
+		if len(r.byMonth) > 0 {
+			return monthlyRepeat(r, start)
+		}
+		// Краткое правило y переносит 29 февраля на 1 марта, а RRULE пропускает невисокосные годы
+		if len(r.byDay) > 0 || len(r.byMonthDay) > 0 || (start.Month() == time.February && start.Day() == 29) {
+			return "", false
+		}
+		return "y", true
+	}
+	return "", false
+}
+
+// monthlyRepeat строит правило m по дням месяца, n-м дням недели и месяцам правила r
+func monthlyRepeat(r *rrule, start time.Time) (string, bool) {
+	var days []string
+	switch {
+	case len(r.byMonthDay) > 0 && len(r.byDay) > 0:
+		// В RRULE это пересечение условий, а в кратком синтаксисе — объединение
+		return "", false
+	case len(r.byMonthDay) > 0:
+		for _, md := range r.byMonthDay {
+			if md < -2 {
+				return "", false
+			}
+			days = append(days, strconv.Itoa(md))
+		}
+	case len(r.byDay) > 0:
+		for _, wd := range r.byDay {
+			if wd.n == 0 || wd.n < -5 || wd.n > 5 {
+				return "", false
+			}
+			weekday := isoWeekdayNumber(wd.weekday)
+			days = append(days, fmt.Sprintf("w%d:%d", weekday, wd.n))
+		}
+	default:
+		days = append(days, strconv.Itoa(start.Day()))
+	}
+
+	repeat := "m " + strings.Join(days, ",")
+	if len(r.byMonth) > 0 {
+		months := map[int]bool{}
+		for _, m := range r.byMonth {
+			months[m] = true
+		}
+		var list []string
+		for _, m := range sortedKeys(months) {
+			list = append(list, strconv.Itoa(m))
+		}
+		repeat += " " + strings.Join(list, ",")
+	}
+	return repeat, true
+}
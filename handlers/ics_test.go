@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepeatToRRule(t *testing.T) {
+	for repeat, want := range map[string]string{
+		"d 1":                        "FREQ=DAILY;INTERVAL=1",
+		"d 14":                       "FREQ=DAILY;INTERVAL=14",
+		"y":                          "FREQ=YEARLY",
+		"w 7,1,3":                    "FREQ=WEEKLY;BYDAY=MO,WE,SU",
+		"m 15,1":                     "FREQ=MONTHLY;BYMONTHDAY=1,15",
+		"m -1,-2,10":                 "FREQ=MONTHLY;BYMONTHDAY=10,-2,-1",
+		"m 1 12,3":                   "FREQ=MONTHLY;BYMONTHDAY=1;BYMONTH=3,12",
+		"m w2:2":                     "FREQ=MONTHLY;BYDAY=2TU",
+		"m w4:-1 11":                 "FREQ=MONTHLY;BYDAY=-1TH;BYMONTH=11",
+		"rrule:freq=weekly;byday=mo": "FREQ=WEEKLY;BYDAY=MO",
+	} {
+		got, err := repeatToRRule(repeat)
+		require.NoError(t, err, repeat)
+		assert.Equal(t, want, got, repeat)
+	}
+
+	for _, repeat := range []string{"", "d", "d 0", "y 1", "w 8", "m 1,w5:-1", "m 32", "x 1"} {
+		_, err := repeatToRRule(repeat)
+		assert.Error(t, err, repeat)
+	}
+}
+
+func TestTaskRRuleEndConditions(t *testing.T) {
+	rule, rdate, err := taskRRule(Task{Date: "20240101", Repeat: "d 2", RepeatCount: 3})
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=DAILY;INTERVAL=2;COUNT=3", rule)
+	assert.False(t, rdate)
+
+	rule, rdate, err = taskRRule(Task{Date: "20240101", Repeat: "w 1", RepeatUntil: "20240301"})
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO;UNTIL=20240301", rule)
+	assert.False(t, rdate)
+
+	// Собственные условия RRULE не дополняются полями задачи
+	rule, _, err = taskRRule(Task{Date: "20240101", Repeat: "FREQ=DAILY;COUNT=5", RepeatUntil: "20240301"})
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=DAILY;COUNT=5", rule)
+
+	// Вторник не подходит под правило: дата задачи уходит в RDATE и не учитывается в COUNT
+	rule, rdate, err = taskRRule(Task{Date: "20240102", Repeat: "w 1", RepeatCount: 3})
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO;COUNT=2", rule)
+	assert.True(t, rdate)
+
+	rule, rdate, err = taskRRule(Task{Date: "20240102", Repeat: "m 1", RepeatCount: 1})
+	require.NoError(t, err)
+	assert.Empty(t, rule)
+	assert.False(t, rdate)
+}
+
+// decodeICS() разбирает ответ обработчика сторонним парсером iCalendar.
+func decodeICS(t *testing.T, target string) (*ical.Calendar, *httptest.ResponseRecorder) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	rec := httptest.NewRecorder()
+	TasksICSHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	cal, err := ical.NewDecoder(strings.NewReader(rec.Body.String())).Decode()
+	require.NoError(t, err, rec.Body.String())
+	return cal, rec
+}
+
+func TestTasksICSHandlerEmpty(t *testing.T) {
+	useMemoryStore(t)
+
+	cal, _ := decodeICS(t, "/api/tasks.ics")
+	assert.Equal(t, ical.CompCalendar, cal.Name)
+	assert.Empty(t, cal.Children)
+	prodID, err := cal.Props.Text(ical.PropProductID)
+	require.NoError(t, err)
+	assert.Equal(t, icsProdID, prodID)
+}
+
+func TestTasksICSHandler(t *testing.T) {
+	mem := useMemoryStore(t)
+	for _, task := range []Task{
+		{Date: "20240126", Title: "Созвон", Comment: "Обсудить отчёт; привезти ноутбук, зарядку", Repeat: "w 1,5"},
+		{Date: "20240201", Title: "Сдать анализы"},
+		{Date: "20240105", Title: "Уборка", Repeat: "m 1,w5:-1"},
+	} {
+		_, err := mem.Create(task)
+		require.NoError(t, err)
+	}
+
+	cal, rec := decodeICS(t, "/api/tasks.ics")
+	assert.Equal(t, "text/calendar; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "\r\n")
+
+	todos := cal.Children
+	require.Len(t, todos, 3)
+	for _, comp := range todos {
+		assert.Equal(t, ical.CompToDo, comp.Name)
+	}
+
+	// Задачи упорядочены по дате: Уборка, Созвон, Сдать анализы
+	uborka, sozvon, analyzes := todos[0], todos[1], todos[2]
+
+	summary, err := sozvon.Props.Text(ical.PropSummary)
+	require.NoError(t, err)
+	assert.Equal(t, "Созвон", summary)
+	description, err := sozvon.Props.Text(ical.PropDescription)
+	require.NoError(t, err)
+	assert.Equal(t, "Обсудить отчёт; привезти ноутбук, зарядку", description)
+	start, err := sozvon.Props.DateTime(ical.PropDateTimeStart, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, "20240126", start.Format("20060102"))
+	assert.Equal(t, ical.ValueDate, sozvon.Props.Get(ical.PropDateTimeStart).ValueType())
+	assert.Equal(t, "task-1@scheduler", sozvon.Props.Get(ical.PropUID).Value)
+	repeat, err := sozvon.Props.Text(PropSchedulerRepeat)
+	require.NoError(t, err)
+	assert.Equal(t, "w 1,5", repeat)
+
+	rule, err := sozvon.Props.RecurrenceRule()
+	require.NoError(t, err)
+	require.NotNil(t, rule)
+	assert.Len(t, rule.Byweekday, 2)
+
+	assert.Nil(t, analyzes.Props.Get(ical.PropRecurrenceRule))
+	assert.Nil(t, analyzes.Props.Get(ical.PropDescription))
+
+	// Правило без аналога в RFC 5545 сохраняется только в X-SCHEDULER-REPEAT
+	assert.Nil(t, uborka.Props.Get(ical.PropRecurrenceRule))
+	repeat, err = uborka.Props.Text(PropSchedulerRepeat)
+	require.NoError(t, err)
+	assert.Equal(t, "m 1,w5:-1", repeat)
+
+	cal, _ = decodeICS(t, "/api/tasks.ics?component=vevent")
+	require.Len(t, cal.Events(), 3)
+
+	m := doRequest(t, TasksICSHandler, http.MethodGet, "/api/tasks.ics?component=vjournal", nil)
+	assert.Contains(t, m, "error")
+}
+
+// Даты, которые календарь вычислит по выгруженному RRULE, должны совпадать
+// с датами планировщика.
+func TestTasksICSRecurrenceRoundTrip(t *testing.T) {
+	mem := useMemoryStore(t)
+	tasks := []Task{
+		{Date: "20240126", Title: "d", Repeat: "d 3"},
+		{Date: "20240126", Title: "w", Repeat: "w 1,3,7"},
+		{Date: "20240131", Title: "m", Repeat: "m 31,-2"},
+		{Date: "20240101", Title: "m months", Repeat: "m 1,15 2,8"},
+		{Date: "20240109", Title: "m weekday", Repeat: "m w2:2,w5:-1"},
+		{Date: "20230615", Title: "y", Repeat: "y"},
+		{Date: "20240130", Title: "rrule", Repeat: "FREQ=MONTHLY;BYDAY=MO,TU;BYSETPOS=-1"},
+		{Date: "20240101", Title: "count", Repeat: "w 2", RepeatCount: 4},
+		{Date: "20240103", Title: "synced", Repeat: "w 3", RepeatCount: 3},
+	}
+	for _, task := range tasks {
+		_, err := mem.Create(task)
+		require.NoError(t, err)
+	}
+
+	cal, _ := decodeICS(t, "/api/tasks.ics?component=vevent")
+	require.Len(t, cal.Children, len(tasks))
+
+	for _, comp := range cal.Children {
+		title, err := comp.Props.Text(ical.PropSummary)
+		require.NoError(t, err)
+		var task Task
+		for _, tk := range tasks {
+			if tk.Title == title {
+				task = tk
+			}
+		}
+
+		set, err := comp.RecurrenceSet(time.UTC)
+		require.NoError(t, err, title)
+		require.NotNil(t, set, title)
+		// go-ical не переносит RDATE в набор дат, добавляем их сами
+		for _, prop := range comp.Props[ical.PropRecurrenceDates] {
+			rdate, err := prop.DateTime(time.UTC)
+			require.NoError(t, err)
+			set.RDate(rdate)
+		}
+
+		var got []string
+		next := set.Iterator()
+		for len(got) < 13 {
+			date, ok := next()
+			if !ok {
+				break
+			}
+			got = append(got, date.Format("20060102"))
+		}
+
+		start, err := time.Parse("20060102", task.Date)
+		require.NoError(t, err)
+		limit := 12
+		if task.RepeatCount > 0 {
+			limit = task.RepeatCount - 1
+		}
+		want, err := NextDates(start, task.Date, task.Repeat, limit, "")
+		require.NoError(t, err, title)
+
+		assert.Equal(t, append([]string{task.Date}, want...), got, title)
+	}
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"final_project/apierror"
+
+	"github.com/emersion/go-ical"
+)
+
+// Максимальный размер импортируемого файла iCalendar
+const MaxImportSize = 10 << 20
+
+// Результаты импорта отдельной записи календаря
+const (
+	ImportImported = "imported"
+	ImportSkipped  = "skipped"
+	ImportFailed   = "failed"
+)
+
+// ImportItem — результат импорта одной записи VEVENT или VTODO
+type ImportItem struct {
+	Index    int      `json:"index"`
+	Type     string   `json:"type"`
+	UID      string   `json:"uid,omitempty"`
+	Title    string   `json:"title"`
+	Status   string   `json:"status"`
+	ID       string   `json:"id,omitempty"`
+	Date     string   `json:"date,omitempty"`
+	Repeat   string   `json:"repeat,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ImportReport — итог импорта файла
+type ImportReport struct {
+	Imported int          `json:"imported"`
+	Skipped  int          `json:"skipped"`
+	Failed   int          `json:"failed"`
+	Items    []ImportItem `json:"items"`
+}
+
+// ImportICSHandler() обрабатывает POST-запросы по адресу /api/import/ics.
+// Файл iCalendar передаётся телом запроса или полем file формы multipart/form-data.
+// Записи без ошибок добавляются в одной транзакции, по каждой записи возвращается результат
+func ImportICSHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodPost) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	r.Body = http.MaxBytesReader(rw, r.Body, MaxImportSize)
+	body, err := importBody(r)
+	if err != nil {
+		apierror.Write(rw, apierror.Field("file", err.Error()))
+		return
+	}
+	defer body.Close()
+
+	cal, err := ical.NewDecoder(body).Decode()
+	if errors.Is(err, io.EOF) {
+		apierror.Write(rw, apierror.Field("file", "файл iCalendar пуст"))
+		return
+	}
+	if err != nil {
+		apierror.Write(rw, apierror.Field("file", fmt.Sprintf("не удалось разобрать файл iCalendar: %v", err)))
+		return
+	}
+
+	now := time.Now()
+	report := ImportReport{Items: []ImportItem{}}
+	var tasks []Task
+	var pending []int
+
+	for _, comp := range cal.Children {
+		if comp.Name != ical.CompEvent && comp.Name != ical.CompToDo {
+			continue
+		}
+		task, item := importComponent(comp, now)
+		item.Index = len(report.Items)
+		if item.Status == "" {
+			tasks = append(tasks, task)
+			pending = append(pending, item.Index)
+		}
+		report.Items = append(report.Items, item)
+	}
+
+	ids, err := tasksOf(r.Context()).CreateMany(tasks)
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+	for i, idx := range pending {
+		report.Items[idx].Status = ImportImported
+		report.Items[idx].ID = strconv.FormatInt(ids[i], 10)
+	}
+
+	for _, item := range report.Items {
+		switch item.Status {
+		case ImportImported:
+			report.Imported++
+		case ImportSkipped:
+			report.Skipped++
+		case ImportFailed:
+			report.Failed++
+		}
+	}
+	respondWithJSON(rw, report)
+}
+
+// importBody возвращает содержимое файла из поля file формы или тело запроса
+func importBody(r *http.Request) (io.ReadCloser, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.Body, nil
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("не передан файл iCalendar: %v", err)
+	}
+	return file, nil
+}
+
+// importComponent преобразует запись календаря в задачу. Если задачу добавлять
+// не нужно, в результате заполнены Status и причина
+func importComponent(comp *ical.Component, now time.Time) (Task, ImportItem) {
+	item := ImportItem{Type: comp.Name}
+	item.UID, _ = comp.Props.Text(ical.PropUID)
+	item.Title, _ = comp.Props.Text(ical.PropSummary)
+
+	fail := func(err error) (Task, ImportItem) {
+		item.Status = ImportFailed
+		item.Error = err.Error()
+		return Task{}, item
+	}
+	skip := func(reason string) (Task, ImportItem) {
+		item.Status = ImportSkipped
+		item.Reason = reason
+		return Task{}, item
+	}
+
+	if componentCompleted(comp) {
+		return skip("запись выполнена или отменена")
+	}
+
+	task, warnings, err := taskFromComponent(comp, now)
+	item.Warnings = warnings
+	if err != nil {
+		return fail(err)
+	}
+
+	if task.Date != "" && task.Date < now.Format("20060102") {
+		if task.Repeat == "" {
+			return skip("дата записи уже прошла")
+		}
+		upcoming, err := upcomingTask(task, now)
+		if errors.Is(err, ErrSeriesEnded) {
+			return skip("серия повторений уже закончилась")
+		}
+		if err != nil {
+			return fail(err)
+		}
+		task = upcoming
+	}
+
+	task, err = prepareTask(task, now)
+	if err != nil {
+		return fail(err)
+	}
+	item.Date, item.Repeat = task.Date, task.Repeat
+	return task, item
+}
+
+// componentCompleted проверяет, отмечена ли запись календаря выполненной или отменённой
+func componentCompleted(comp *ical.Component) bool {
+	status, _ := comp.Props.Text(ical.PropStatus)
+	return status == "COMPLETED" || status == "CANCELLED" || comp.Props.Get(ical.PropCompleted) != nil
+}
+
+// taskFromComponent читает из записи VEVENT или VTODO дату (DTSTART, для VTODO
+// также DUE), заголовок, комментарий, метки (CATEGORIES) и правило повторения.
+// Дата не проверяется на соответствие правилам планировщика. Возвращает
+// предупреждения о свойствах, которые планировщик не поддерживает
+func taskFromComponent(comp *ical.Component, now time.Time) (Task, []string, error) {
+	var warnings []string
+	title, _ := comp.Props.Text(ical.PropSummary)
+	comment, _ := comp.Props.Text(ical.PropDescription)
+	task := Task{Title: title, Comment: comment}
+	for _, prop := range comp.Props.Values(ical.PropCategories) {
+		categories, err := prop.TextList()
+		if err != nil {
+			return task, warnings, fmt.Errorf("некорректное свойство CATEGORIES: %w", err)
+		}
+		task.Tags = append(task.Tags, categories...)
+	}
+
+	start := now
+	dateProp := ical.PropDateTimeStart
+	if comp.Props.Get(dateProp) == nil && comp.Name == ical.CompToDo {
+		dateProp = ical.PropDue
+	}
+	if comp.Props.Get(dateProp) != nil {
+		date, err := comp.Props.DateTime(dateProp, time.Local)
+		if err != nil {
+			return task, warnings, fmt.Errorf("некорректная дата %s: %v", dateProp, err)
+		}
+		start = date
+		task.Date = date.Format("20060102")
+	}
+
+	rules := comp.Props.Values(ical.PropRecurrenceRule)
+	if len(rules) > 1 {
+		return task, warnings, fmt.Errorf("несколько правил RRULE в одной записи не поддерживаются")
+	}
+	if len(rules) == 1 {
+		repeat, until, count, err := rruleToRepeat(rules[0].Value, start)
+		if err != nil {
+			return task, warnings, fmt.Errorf("правило повторения не поддерживается: %v", err)
+		}
+		task.Repeat, task.RepeatUntil, task.RepeatCount = repeat, until, count
+	}
+
+	// Записи, выгруженные планировщиком, хранят исходное правило и условия окончания.
+	// Если клиент изменил RRULE, исходное правило устарело и не используется
+	rdates := comp.Props.Values(ical.PropRecurrenceDates)
+	if original, err := comp.Props.Text(PropSchedulerRepeat); err == nil && original != "" {
+		saved := Task{Date: task.Date, Repeat: original}
+		saved.RepeatUntil, _ = comp.Props.Text(PropSchedulerRepeatUntil)
+		if count, err := comp.Props.Text(PropSchedulerRepeatCount); err == nil && count != "" {
+			if saved.RepeatCount, err = strconv.Atoi(count); err != nil {
+				return task, warnings, fmt.Errorf("некорректное значение %s", PropSchedulerRepeatCount)
+			}
+		}
+		if savedRepeatMatches(saved, rules) {
+			task.Repeat, task.RepeatUntil, task.RepeatCount = saved.Repeat, saved.RepeatUntil, saved.RepeatCount
+			// Дата задачи, добавленная в серию через RDATE, уже учтена в правиле
+			if len(rdates) == 1 && rdates[0].Value == start.Format("20060102") {
+				rdates = nil
+			}
+		}
+	}
+	if len(rdates) > 0 {
+		warnings = append(warnings, "дополнительные даты RDATE не поддерживаются и пропущены")
+	}
+	if len(comp.Props.Values(ical.PropExceptionDates)) > 0 {
+		warnings = append(warnings, "исключения EXDATE не поддерживаются и пропущены")
+	}
+	return task, warnings, nil
+}
+
+// savedRepeatMatches проверяет, что правило планировщика выгружается в то же RRULE,
+// что записано в записи календаря
+func savedRepeatMatches(saved Task, rules []ical.Prop) bool {
+	rule, _, err := taskRRule(saved)
+	if len(rules) == 0 {
+		return err != nil || rule == ""
+	}
+	return err == nil && strings.EqualFold(rule, rules[0].Value)
+}
+
+// upcomingTask переносит повторяющуюся задачу с прошедшей датой на первое выполнение
+// не раньше now. Прошедшие выполнения вычитаются из RepeatCount. Если выполнений
+// не осталось, возвращается ErrSeriesEnded
+func upcomingTask(task Task, now time.Time) (Task, error) {
+	today := now.Format("20060102")
+
+	after := now.AddDate(0, 0, -1)
+	limit := 1
+	if task.RepeatCount > 0 {
+		// Перебираем серию с начала, чтобы сосчитать прошедшие выполнения
+		start, err := time.Parse("20060102", task.Date)
+		if err != nil {
+			return task, fmt.Errorf("некорректная дата")
+		}
+		after, limit = start, task.RepeatCount-1
+	}
+
+	dates, err := NextDates(after, task.Date, task.Repeat, limit, task.RepeatUntil)
+	if err != nil {
+		return task, err
+	}
+	for i, date := range dates {
+		if date >= today {
+			task.Date = date
+			if task.RepeatCount > 0 {
+				task.RepeatCount -= i + 1
+			}
+			return task, nil
+		}
+	}
+	return task, ErrSeriesEnded
+}
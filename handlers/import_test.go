@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// importICS() отправляет файл iCalendar в ImportICSHandler и возвращает разобранный ответ.
+func importICS(t *testing.T, lines ...string) map[string]any {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/import/ics", strings.NewReader(strings.Join(lines, "\r\n")+"\r\n"))
+	req.Header.Set("Content-Type", "text/calendar")
+	rec := httptest.NewRecorder()
+	ImportICSHandler(rec, req)
+
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &m), rec.Body.String())
+	return m
+}
+
+// vcalendar() оборачивает записи в VCALENDAR.
+func vcalendar(components ...string) []string {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//test//test//EN"}
+	for _, c := range components {
+		lines = append(lines, strings.Split(c, "\n")...)
+	}
+	return append(lines, "END:VCALENDAR")
+}
+
+func TestRRuleToRepeat(t *testing.T) {
+	// 2099-01-05 — понедельник
+	start := time.Date(2099, 1, 5, 0, 0, 0, 0, time.UTC)
+
+	for rule, want := range map[string]string{
+		"FREQ=DAILY":                                 "d 1",
+		"FREQ=DAILY;INTERVAL=3":                      "d 3",
+		"FREQ=WEEKLY":                                "w 1",
+		"FREQ=WEEKLY;BYDAY=SU,MO,WE;WKST=SU":         "w 1,3,7",
+		"FREQ=WEEKLY;INTERVAL=2":                     "d 14",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO":            "d 14",
+		"FREQ=MONTHLY":                               "m 5",
+		"FREQ=MONTHLY;BYMONTHDAY=1,-1":               "m 1,-1",
+		"FREQ=MONTHLY;BYDAY=2TU,-1FR":                "m w2:2,w5:-1",
+		"FREQ=MONTHLY;BYMONTHDAY=10;BYMONTH=6,1":     "m 10 1,6",
+		"FREQ=YEARLY":                                "y",
+		"FREQ=YEARLY;BYMONTH=11;BYDAY=4TH":           "m w4:4 11",
+		"FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=8":         "m 8 3",
+		"RRULE:FREQ=MONTHLY;BYDAY=MO,TU;BYSETPOS=-1": "FREQ=MONTHLY;BYDAY=MO,TU;BYSETPOS=-1",
+		"FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13":        "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH":         "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+		"FREQ=MONTHLY;INTERVAL=3":                    "FREQ=MONTHLY;INTERVAL=3",
+		"FREQ=YEARLY;BYDAY=20MO":                     "FREQ=YEARLY;BYDAY=20MO",
+	} {
+		repeat, _, _, err := rruleToRepeat(rule, start)
+		require.NoError(t, err, rule)
+		assert.Equal(t, want, repeat, rule)
+
+		// Краткое правило должно давать те же даты, что и исходное RRULE
+		now := start.AddDate(0, 0, -1)
+		got, err := NextDates(now, start.Format("20060102"), repeat, 10, "")
+		require.NoError(t, err, repeat)
+		orig, err := NextDates(now, start.Format("20060102"), rule, 10, "")
+		require.NoError(t, err, rule)
+		assert.Equal(t, orig, got, rule)
+	}
+
+	repeat, until, count, err := rruleToRepeat("FREQ=DAILY;COUNT=5", start)
+	require.NoError(t, err)
+	assert.Equal(t, "d 1", repeat)
+	assert.Empty(t, until)
+	assert.Equal(t, 5, count)
+
+	repeat, until, count, err = rruleToRepeat("FREQ=WEEKLY;INTERVAL=2;COUNT=8;BYDAY=MO,TH", start)
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", repeat)
+	assert.Equal(t, 8, count)
+
+	repeat, until, _, err = rruleToRepeat("FREQ=WEEKLY;UNTIL=20990301T000000Z;BYDAY=FR", start)
+	require.NoError(t, err)
+	assert.Equal(t, "w 5", repeat)
+	assert.Equal(t, "20990301", until)
+
+	// 29 февраля краткое правило y переносит на 1 марта, поэтому остаётся RRULE
+	repeat, _, _, err = rruleToRepeat("FREQ=YEARLY", time.Date(2096, 2, 29, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=YEARLY", repeat)
+
+	_, _, _, err = rruleToRepeat("FREQ=HOURLY", start)
+	assert.Error(t, err)
+}
+
+func TestUpcomingTask(t *testing.T) {
+	now := time.Date(2024, 1, 26, 0, 0, 0, 0, time.UTC)
+
+	task, err := upcomingTask(Task{Date: "20240101", Repeat: "d 7"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, "20240129", task.Date)
+
+	// Прошли выполнения 1, 8, 15 и 22 января, осталось два
+	task, err = upcomingTask(Task{Date: "20240101", Repeat: "d 7", RepeatCount: 6}, now)
+	require.NoError(t, err)
+	assert.Equal(t, "20240129", task.Date)
+	assert.Equal(t, 2, task.RepeatCount)
+
+	_, err = upcomingTask(Task{Date: "20240101", Repeat: "d 7", RepeatCount: 4}, now)
+	assert.ErrorIs(t, err, ErrSeriesEnded)
+
+	_, err = upcomingTask(Task{Date: "20240101", Repeat: "d 7", RepeatUntil: "20240125"}, now)
+	assert.ErrorIs(t, err, ErrSeriesEnded)
+}
+
+func TestImportICSHandler(t *testing.T) {
+	mem := useMemoryStore(t)
+
+	m := importICS(t, vcalendar(
+		"BEGIN:VTIMEZONE\nTZID:Europe/Moscow\nBEGIN:STANDARD\nDTSTART:19700101T000000\nTZOFFSETFROM:+0300\nTZOFFSETTO:+0300\nEND:STANDARD\nEND:VTIMEZONE",
+		"BEGIN:VEVENT\nUID:1@test\nDTSTAMP:20240101T000000Z\nDTSTART;VALUE=DATE:20990105\nSUMMARY:Планёрка\nDESCRIPTION:Переговорная 3\\, второй этаж\nRRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10\nEXDATE;VALUE=DATE:20990107\nEND:VEVENT",
+		"BEGIN:VTODO\nUID:2@test\nDTSTAMP:20240101T000000Z\nDUE;VALUE=DATE:20990110\nSUMMARY:Сдать отчёт\nEND:VTODO",
+		"BEGIN:VEVENT\nUID:3@test\nDTSTAMP:20240101T000000Z\nDTSTART:20990105T100000Z\nSUMMARY:Каждый час\nRRULE:FREQ=HOURLY\nEND:VEVENT",
+		"BEGIN:VEVENT\nUID:4@test\nDTSTAMP:20240101T000000Z\nDTSTART;VALUE=DATE:20200105\nSUMMARY:Прошедшая встреча\nEND:VEVENT",
+		"BEGIN:VTODO\nUID:5@test\nDTSTAMP:20240101T000000Z\nDTSTART;VALUE=DATE:20990105\nSUMMARY:Готово\nSTATUS:COMPLETED\nEND:VTODO",
+		"BEGIN:VEVENT\nUID:6@test\nDTSTAMP:20240101T000000Z\nDTSTART;VALUE=DATE:20990105\nEND:VEVENT",
+		"BEGIN:VEVENT\nUID:7@test\nDTSTAMP:20240101T000000Z\nDTSTART;VALUE=DATE:20990131\nSUMMARY:Последний рабочий день\nRRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1\nEND:VEVENT",
+	)...)
+	require.NotContains(t, m, "error")
+	assert.EqualValues(t, 3, m["imported"])
+	assert.EqualValues(t, 2, m["skipped"])
+	assert.EqualValues(t, 2, m["failed"])
+
+	items := m["items"].([]any)
+	require.Len(t, items, 7)
+	status := func(i int) map[string]any { return items[i].(map[string]any) }
+
+	assert.Equal(t, "imported", status(0)["status"])
+	assert.Equal(t, "1@test", status(0)["uid"])
+	assert.Equal(t, "w 1,3", status(0)["repeat"])
+	assert.Len(t, status(0)["warnings"], 1)
+	assert.Equal(t, "imported", status(1)["status"])
+	assert.Equal(t, "VTODO", status(1)["type"])
+	assert.Equal(t, "20990110", status(1)["date"])
+	assert.Equal(t, "failed", status(2)["status"])
+	assert.Contains(t, status(2)["error"], "HOURLY")
+	assert.Equal(t, "skipped", status(3)["status"])
+	assert.Equal(t, "skipped", status(4)["status"])
+	assert.Equal(t, "failed", status(5)["status"])
+	assert.Equal(t, "imported", status(6)["status"])
+	assert.Equal(t, "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", status(6)["repeat"])
+
+	tasks, err := mem.List(0)
+	require.NoError(t, err)
+	require.Len(t, tasks, 3)
+	assert.Equal(t, Task{ID: status(0)["id"].(string), Date: "20990105", Title: "Планёрка",
+		Comment: "Переговорная 3, второй этаж", Repeat: "w 1,3", RepeatCount: 10, Tags: []string{}}, tasks[0])
+}
+
+func TestImportICSHandlerErrors(t *testing.T) {
+	mem := useMemoryStore(t)
+
+	m := importICS(t, "")
+	assert.Contains(t, m, "error")
+	m = importICS(t, "BEGIN:VCALENDAR", "VERSION:2.0", "BEGIN:VEVENT")
+	assert.Contains(t, m, "error")
+
+	rec := httptest.NewRecorder()
+	ImportICSHandler(rec, httptest.NewRequest(http.MethodGet, "/api/import/ics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	tasks, err := mem.List(0)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+}
+
+func TestImportICSMultipart(t *testing.T) {
+	mem := useMemoryStore(t)
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "calendar.ics")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(strings.Join(vcalendar(
+		"BEGIN:VEVENT\nUID:1@test\nDTSTAMP:20240101T000000Z\nDTSTART;VALUE=DATE:20990105\nSUMMARY:Из файла\nEND:VEVENT",
+	), "\r\n") + "\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/import/ics", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	ImportICSHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	tasks, err := mem.List(0)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "Из файла", tasks[0].Title)
+}
+
+// Выгруженные задачи должны импортироваться обратно без изменений.
+func TestICSExportImportRoundTrip(t *testing.T) {
+	original := []Task{
+		{Date: "20990105", Title: "Ежедневно", Comment: "a;b,c\\d", Repeat: "d 2", RepeatCount: 5, Tags: []string{"дом", "спорт, зал"}},
+		{Date: "20990106", Title: "По понедельникам", Repeat: "w 1", RepeatCount: 3, Tags: []string{"работа"}},
+		{Date: "20990107", Title: "Сочетание", Repeat: "m 1,w5:-1", RepeatUntil: "20991231", Tags: []string{}},
+		{Date: "20990108", Title: "RRULE", Repeat: "FREQ=MONTHLY;BYDAY=2TH", Tags: []string{}},
+		{Date: "20990109", Title: "Разовая", Tags: []string{}},
+	}
+
+	mem := useMemoryStore(t)
+	for _, task := range original {
+		_, err := mem.Create(task)
+		require.NoError(t, err)
+	}
+	rec := httptest.NewRecorder()
+	TasksICSHandler(rec, httptest.NewRequest(http.MethodGet, "/api/tasks.ics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	imported := useMemoryStore(t)
+	m := importICS(t, strings.TrimSuffix(rec.Body.String(), "\r\n"))
+	require.NotContains(t, m, "error")
+	assert.EqualValues(t, len(original), m["imported"], m)
+
+	tasks, err := imported.List(0)
+	require.NoError(t, err)
+	require.Len(t, tasks, len(original))
+	for i, task := range tasks {
+		task.ID = ""
+		assert.Equal(t, original[i], task)
+	}
+}
+
+func TestTaskFromComponentStaleSchedulerRepeat(t *testing.T) {
+	now := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)
+	parse := func(rule string) Task {
+		cal := todo("UID:task-1@scheduler", "SUMMARY:Полив", "DTSTART;VALUE=DATE:20990105", rule,
+			PropSchedulerRepeat+":d 2", PropSchedulerRepeatCount+":5")
+		task, _, err := taskFromComponent(cal.Children[0], now)
+		require.NoError(t, err)
+		return task
+	}
+
+	// Правило не менялось — используется исходное правило планировщика
+	task := parse("RRULE:FREQ=DAILY;INTERVAL=2;COUNT=5")
+	assert.Equal(t, "d 2", task.Repeat)
+	assert.Equal(t, 5, task.RepeatCount)
+
+	// Клиент изменил RRULE — исходное правило устарело
+	task = parse("RRULE:FREQ=WEEKLY")
+	assert.Equal(t, "w 1", task.Repeat)
+	assert.Zero(t, task.RepeatCount)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"final_project/auth"
+)
+
+// oidcStateCookie — имя cookie, связывающей начатый вход через OpenID Connect
+// с браузером, чтобы чужую ссылку на возврат от поставщика нельзя было подсунуть
+const oidcStateCookie = "oidc_state"
+
+// OIDCLoginHandler() обрабатывает GET-запросы по адресу /api/oidc/login
+// и перенаправляет пользователя на страницу входа поставщика OpenID Connect
+func OIDCLoginHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodGet) {
+		return
+	}
+	provider := auth.OIDC()
+	if provider == nil {
+		respondWithStatus(rw, http.StatusNotFound, auth.ErrOIDCDisabled.Error())
+		return
+	}
+
+	authURL, state, err := provider.AuthURL(r.Context())
+	if err != nil {
+		respondWithStatus(rw, http.StatusBadGateway, err.Error())
+		return
+	}
+	// Поставщик возвращает пользователя переходом с другого сайта,
+	// поэтому cookie нужна с SameSite=Lax
+	http.SetCookie(rw, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(rw, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler() обрабатывает возвращение пользователя от поставщика
+// OpenID Connect по адресу /api/oidc/callback. После входа токены сохраняются
+// в cookie и пользователь перенаправляется на главную страницу. Пользователю
+// со вторым фактором, как и в SignInHandler(), возвращается {"mfa_required", "mfa_token"}
+func OIDCCallbackHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodGet) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	q := r.URL.Query()
+	// Поставщик сообщает об отказе во входе параметром error
+	if q.Get("error") != "" {
+		respondWithStatus(rw, http.StatusUnauthorized, auth.ErrOIDCFailed.Error())
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value == "" || cookie.Value != q.Get("state") {
+		respondWithStatus(rw, http.StatusUnauthorized, "вход начат в другом браузере или время входа истекло")
+		return
+	}
+	http.SetCookie(rw, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc/", MaxAge: -1})
+
+	user, err := auth.OIDCSignIn(r.Context(), q.Get("code"), q.Get("state"), auth.ClientIP(r))
+	if errors.Is(err, auth.ErrSecondFactorRequired) {
+		respondWithMFAToken(rw, user)
+		return
+	}
+	if errors.Is(err, auth.ErrOIDCDisabled) {
+		respondWithStatus(rw, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, auth.ErrOIDCFailed) {
+		respondWithStatus(rw, http.StatusUnauthorized, auth.ErrOIDCFailed.Error())
+		return
+	}
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+
+	tokens, err := auth.IssueTokens(user)
+	if err != nil {
+		respondWithStatus(rw, http.StatusInternalServerError, fmt.Sprintf("ошибка генерации токена: %v", err))
+		return
+	}
+	auth.SetRefreshCookie(rw, tokens.RefreshToken, int(auth.RefreshTTL.Seconds()))
+	// Веб-интерфейс читает access-токен из cookie token, как после входа по паролю
+	auth.SetTokenCookie(rw, tokens.Token)
+	http.Redirect(rw, r, "/", http.StatusFound)
+}
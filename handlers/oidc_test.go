@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"final_project/auth"
+	"final_project/auth/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCHandlers(t *testing.T) {
+	useMemoryStore(t)
+	t.Setenv("TODO_PASSWORD", "secret-password")
+
+	rec := httptest.NewRecorder()
+	OIDCLoginHandler(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "вход через OpenID Connect не настроен")
+
+	idp := oidctest.NewProvider("scheduler", "")
+	t.Cleanup(idp.Close)
+	auth.SetOIDC(auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:      idp.Issuer(),
+		ClientID:    "scheduler",
+		RedirectURL: "http://scheduler.example/api/oidc/callback",
+	}))
+	t.Cleanup(func() { auth.SetOIDC(nil) })
+
+	// Начало входа: перенаправление к поставщику и cookie со state
+	rec = httptest.NewRecorder()
+	OIDCLoginHandler(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	require.Equal(t, http.StatusFound, rec.Code)
+	var stateCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			stateCookie = c
+		}
+	}
+	require.NotNil(t, stateCookie)
+	callback, err := idp.Login(rec.Header().Get("Location"))
+	require.NoError(t, err)
+
+	// Возвращение в другой браузер без cookie отклоняется
+	rec = httptest.NewRecorder()
+	OIDCCallbackHandler(rec, httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(stateCookie)
+	OIDCCallbackHandler(rec, req)
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	assert.Equal(t, "/", rec.Header().Get("Location"))
+
+	cookies := map[string]string{}
+	for _, c := range rec.Result().Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	assert.NotEmpty(t, cookies[auth.RefreshCookie])
+	require.NotEmpty(t, cookies["token"])
+
+	// Выданный токен принимается API
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.AddCookie(&http.Cookie{Name: "token", Value: cookies["token"]})
+	rec = httptest.NewRecorder()
+	auth.Auth(TasksHandler)(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Отказ поставщика
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/oidc/callback?error=access_denied&state="+stateCookie.Value, nil)
+	req.AddCookie(stateCookie)
+	OIDCCallbackHandler(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"error"`)
+}
@@ -1,18 +1,42 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
+
+	"final_project/apierror"
 )
 
+// ErrSeriesEnded возвращается, если у серии повторений больше не осталось дат:
+// исчерпаны COUNT или UNTIL правила RFC 5545 либо условия окончания задачи.
+var ErrSeriesEnded = errors.New("[NextDate]: у правила повторения больше нет дат")
+
+// Сколько лет вперёд просматривается календарь в поисках даты для редких правил
+const maxRepeatYears = 100
+
 // daysInMonth возвращает количество дней в месяце для заданной даты
 func daysInMonth(t time.Time) int {
 	// Возвращаем количество дней в месяце, используя стандартную библиотеку
 	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
 }
 
+// isoWeekday возвращает номер дня недели от 1 (понедельник) до 7 (воскресенье)
+func isoWeekday(t time.Time) int {
+	// В time.Weekday воскресенье имеет номер 0
+	return isoWeekdayNumber(t.Weekday())
+}
+
+// isoWeekdayNumber переводит time.Weekday в номер от 1 (понедельник) до 7 (воскресенье)
+func isoWeekdayNumber(wd time.Weekday) int {
+	if wd == time.Sunday {
+		return 7
+	}
+	return int(wd)
+}
+
 // timeDiff проверяет, что дата first больше, чем дата sec
 func timeDiff(first, sec time.Time) bool {
 	// Сравниваем года, месяцы и дни, чтобы определить, какая дата раньше
@@ -48,6 +72,11 @@ func NextDate(now time.Time, date, repeat string) (string, error) {
 		return "", fmt.Errorf("[NextDate]: wrong date: %w", err)
 	}
 
+	// Правила RFC 5545 (FREQ=...;BYDAY=...) разбираются отдельно от краткого синтаксиса
+	if isRRule(repeat) {
+		return handleRRuleRepeat(nowDate, now, repeat)
+	}
+
 	repeatError := fmt.Errorf("[NextDate]: wrong repeat format")
 
 	switch repeat[0] {
@@ -64,6 +93,78 @@ func NextDate(now time.Time, date, repeat string) (string, error) {
 	}
 }
 
+// NextTaskDate вычисляет следующую дату повторяющейся задачи с учётом условий
+// окончания серии (RepeatUntil и RepeatCount). Возвращает ErrSeriesEnded,
+// если выполнений больше не будет
+func NextTaskDate(now time.Time, task Task) (string, error) {
+	if task.RepeatCount == 1 {
+		return "", ErrSeriesEnded
+	}
+
+	next, err := NextDate(now, task.Date, task.Repeat)
+	if err != nil {
+		return "", err
+	}
+
+	if task.RepeatUntil != "" && next > task.RepeatUntil {
+		return "", ErrSeriesEnded
+	}
+	return next, nil
+}
+
+// NextDates возвращает не более limit дат серии date+repeat, следующих после now.
+// Если задан to (20060102), возвращаются только даты не позже to.
+// Перебор прекращается, когда у серии заканчиваются даты
+func NextDates(now time.Time, date, repeat string, limit int, to string) ([]string, error) {
+	dates := []string{}
+	for len(dates) < limit {
+		next, err := NextDate(now, date, repeat)
+		if errors.Is(err, ErrSeriesEnded) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if to != "" && next > to {
+			break
+		}
+		dates = append(dates, next)
+
+		now, err = time.Parse("20060102", next)
+		if err != nil {
+			return nil, err
+		}
+		// Краткие правила не зависят от начала серии, поэтому следующую дату
+		// считаем от найденной. Для RRULE начало серии нужно для COUNT и INTERVAL
+		if !isRRule(repeat) {
+			date = next
+		}
+	}
+	return dates, nil
+}
+
+// checkRepeatEnd проверяет условия окончания серии для задачи с датой date
+func checkRepeatEnd(date, repeat, until string, count int) error {
+	if until == "" && count == 0 {
+		return nil
+	}
+	if repeat == "" {
+		return apierror.Field("repeat", "условия окончания повторений задаются только для повторяющихся задач")
+	}
+	if count < 0 {
+		return apierror.Field("repeat_count", "количество повторений не может быть отрицательным")
+	}
+	if until != "" {
+		if _, err := time.Parse("20060102", until); err != nil {
+			return apierror.Field("repeat_until", "некорректная дата окончания повторений")
+		}
+		if until < date {
+			return apierror.Field("repeat_until", "серия повторений заканчивается раньше даты задачи")
+		}
+	}
+	return nil
+}
+
 func handleDailyRepeat(nowDate, now time.Time, repeat string, repeatError error) (string, error) {
 	days := strings.Split(repeat, " ")
 	if len(days) != 2 {
@@ -71,7 +172,7 @@ func handleDailyRepeat(nowDate, now time.Time, repeat string, repeatError error)
 	}
 
 	d, err := strconv.Atoi(days[1])
-	if err != nil || d > 400 {
+	if err != nil || d <= 0 || d > 400 {
 		return "", repeatError
 	}
 
@@ -103,7 +204,7 @@ func handleWeeklyRepeat(nowDate, now time.Time, repeat string, repeatError error
 
 	nowDate = nowDate.AddDate(0, 0, 1)
 	for {
-		if timeDiff(nowDate, now) && weekdays[int(nowDate.Weekday())] {
+		if timeDiff(nowDate, now) && weekdays[isoWeekday(nowDate)] {
 			break
 		}
 		nowDate = nowDate.AddDate(0, 0, 1)
@@ -117,22 +218,42 @@ func handleMonthlyRepeat(nowDate, now time.Time, repeat string, repeatError erro
 		return "", repeatError
 	}
 
-	monthDays, last, prelast := parseMonthDays(days[1], repeatError)
-	if monthDays == nil {
+	numbers, weekdays, ok := parseMonthWeekdays(days[1])
+	if !ok {
 		return "", repeatError
 	}
 
+	monthDays, last, prelast := map[int]bool{}, false, false
+	if numbers != "" {
+		monthDays, last, prelast = parseMonthDays(numbers, repeatError)
+		if monthDays == nil {
+			return "", repeatError
+		}
+	}
+
 	months := parseMonths(days, repeatError)
 	if len(days) == 3 && months == nil {
 		return "", repeatError
 	}
 
+	// Некоторые сочетания дней и месяцев (например, m 31 2) не встречаются никогда,
+	// поэтому поиск ограничен maxRepeatYears лет после даты задачи или текущей даты
+	limit := now
+	if timeDiff(nowDate, now) {
+		limit = nowDate
+	}
+	limit = limit.AddDate(maxRepeatYears, 0, 0)
+
 	nowDate = nowDate.AddDate(0, 0, 1)
 	for {
+		if nowDate.After(limit) {
+			return "", repeatError
+		}
 		if timeDiff(nowDate, now) {
 			if (len(months) == 0 || months[int(nowDate.Month())]) &&
 				(monthDays[nowDate.Day()] || (last && nowDate.Day() == daysInMonth(nowDate)) ||
-					(prelast && nowDate.Day() == daysInMonth(nowDate)-1)) {
+					(prelast && nowDate.Day() == daysInMonth(nowDate)-1) ||
+					matchMonthWeekday(weekdays, nowDate)) {
 				break
 			}
 		}
@@ -153,6 +274,62 @@ func parseWeekdays(input string, repeatError error) map[int]bool {
 	return weekdays
 }
 
+// monthWeekday описывает правило «n-й день недели месяца»: w2:2 — второй вторник,
+// w5:-1 — последняя пятница. Дни недели нумеруются с 1 (понедельник) до 7 (воскресенье)
+type monthWeekday struct {
+	weekday int
+	n       int
+}
+
+// parseMonthWeekdays отделяет в списке дней месяца элементы вида wД:N от обычных чисел.
+// Возвращает оставшиеся числа через запятую и разобранные дни недели
+func parseMonthWeekdays(input string) (string, []monthWeekday, bool) {
+	var numbers []string
+	var weekdays []monthWeekday
+
+	for _, item := range strings.Split(input, ",") {
+		if item == "" {
+			return "", nil, false
+		}
+		if !strings.HasPrefix(item, "w") {
+			numbers = append(numbers, item)
+			continue
+		}
+
+		wdPart, nPart, found := strings.Cut(item[1:], ":")
+		if !found {
+			return "", nil, false
+		}
+		wd, err := strconv.Atoi(wdPart)
+		if err != nil || wd < 1 || wd > 7 {
+			return "", nil, false
+		}
+		n, err := strconv.Atoi(nPart)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return "", nil, false
+		}
+		weekdays = append(weekdays, monthWeekday{weekday: wd, n: n})
+	}
+	return strings.Join(numbers, ","), weekdays, true
+}
+
+// matchMonthWeekday проверяет, подходит ли дата под одно из правил «n-й день недели месяца»
+func matchMonthWeekday(weekdays []monthWeekday, date time.Time) bool {
+	wd := isoWeekday(date)
+	for _, mw := range weekdays {
+		if mw.weekday != wd {
+			continue
+		}
+		if mw.n > 0 && (date.Day()-1)/7+1 == mw.n {
+			return true
+		}
+		if mw.n < 0 && (daysInMonth(date)-date.Day())/7+1 == -mw.n {
+			return true
+		}
+	}
+	return false
+}
+
 func parseMonthDays(input string, repeatError error) (map[int]bool, bool, bool) {
 	monthDays := make(map[int]bool)
 	last, prelast := false, false
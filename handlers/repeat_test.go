@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextDateMonthWeekday(t *testing.T) {
+	now := time.Date(2024, 1, 26, 0, 0, 0, 0, time.UTC)
+
+	tbl := []struct {
+		date, repeat, want string
+	}{
+		{"20240101", "m w2:2", "20240213"},
+		{"20240101", "m w5:-1", "20240223"},
+		{"20240101", "m w1:1,w3:3", "20240205"},
+		{"20240101", "m w4:-1 11", "20241128"},
+		{"20240101", "m 10,w7:-1", "20240128"},
+		{"20240101", "m w1:5", "20240129"},
+		{"20240201", "m w1:5", "20240429"},
+		{"20240101", "m w6:-2,-1", "20240131"},
+		{"20240101", "m w8:1", ""},
+		{"20240101", "m w2:0", ""},
+		{"20240101", "m w2:6", ""},
+		{"20240101", "m w2:-6", ""},
+		{"20240101", "m w2", ""},
+		{"20240101", "m wx:1", ""},
+		{"20240101", "m w2:2,", ""},
+	}
+	for _, v := range tbl {
+		got, err := NextDate(now, v.date, v.repeat)
+		if v.want == "" {
+			assert.Error(t, err, v.repeat)
+			continue
+		}
+		assert.NoError(t, err, v.repeat)
+		assert.Equal(t, v.want, got, "%s %s", v.date, v.repeat)
+	}
+}
+
+func TestNextDateSparseRules(t *testing.T) {
+	now := time.Date(2024, 1, 26, 0, 0, 0, 0, time.UTC)
+
+	// Правила, которые никогда не срабатывают, не должны зацикливаться
+	for _, repeat := range []string{"m 31 2", "m 30,31 2", "d 0", "d -3"} {
+		_, err := NextDate(now, "20240101", repeat)
+		assert.Error(t, err, repeat)
+	}
+
+	// Воскресенье в правиле w — день 7, а не 0, как в time.Weekday
+	got, err := NextDate(now, "20240126", "w 7")
+	require.NoError(t, err)
+	assert.Equal(t, "20240128", got)
+	got, err = NextDate(now, "20240128", "w 1,7")
+	require.NoError(t, err)
+	assert.Equal(t, "20240129", got)
+	_, err = NextDate(now, "20240126", "w 0")
+	assert.Error(t, err)
+}
+
+func TestNextDates(t *testing.T) {
+	now := time.Date(2024, 1, 26, 0, 0, 0, 0, time.UTC)
+
+	dates, err := NextDates(now, "20240101", "d 7", 3, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240129", "20240205", "20240212"}, dates)
+
+	dates, err = NextDates(now, "20240101", "m w2:2", 3, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240213", "20240312", "20240409"}, dates)
+
+	dates, err = NextDates(now, "20240101", "FREQ=WEEKLY;BYDAY=MO;COUNT=6", 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240129", "20240205"}, dates)
+
+	dates, err = NextDates(now, "20240101", "FREQ=DAILY;INTERVAL=10", 10, "20240301")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240131", "20240210", "20240220", "20240301"}, dates)
+
+	_, err = NextDates(now, "20240101", "ooops", 10, "")
+	assert.Error(t, err)
+}
+
+func TestNextDatesHandler(t *testing.T) {
+	m := doRequest(t, NextDatesHandler, http.MethodGet, "/api/nextdates?now=20240126&date=20240101&repeat=d+7&limit=2", nil)
+	assert.Equal(t, []any{"20240129", "20240205"}, m["dates"])
+	assert.Equal(t, true, m["truncated"])
+
+	m = doRequest(t, NextDatesHandler, http.MethodGet, "/api/nextdates?date=20240101&repeat=w+1&from=20240201&to=20240215", nil)
+	assert.Equal(t, []any{"20240205", "20240212"}, m["dates"])
+	assert.NotContains(t, m, "truncated")
+
+	m = doRequest(t, NextDatesHandler, http.MethodGet, "/api/nextdates?date=20240101&repeat=d+1&from=20240101&to=20240301", nil)
+	assert.Len(t, m["dates"], 60)
+
+	for _, target := range []string{
+		"/api/nextdates?date=20240101",
+		"/api/nextdates?date=20240101&repeat=d+1&limit=1000",
+		"/api/nextdates?date=20240101&repeat=d+1&limit=0",
+		"/api/nextdates?date=20240101&repeat=d+1&from=20240101&to=20400101",
+		"/api/nextdates?date=20240101&repeat=d+1&from=20240301&to=20240101",
+		"/api/nextdates?date=20240101&repeat=d+1&from=20240101",
+		"/api/nextdates?date=20240101&repeat=d+1&now=tomorrow",
+	} {
+		m := doRequest(t, NextDatesHandler, http.MethodGet, target, nil)
+		assert.Contains(t, m, "error", target)
+	}
+}
@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var periodsPerYear = map[string]int{
+	"DAILY":   366,
+	"WEEKLY":  53,
+	"MONTHLY": 12,
+	"YEARLY":  1,
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// weekdayNum — элемент BYDAY: день недели с необязательным порядковым номером
+// (2MO — второй понедельник, -1FR — последняя пятница, n == 0 — каждый).
+type weekdayNum struct {
+	n       int
+	weekday time.Weekday
+}
+
+// rrule — правило повторения RFC 5545. Поддерживаются FREQ (DAILY, WEEKLY,
+// MONTHLY, YEARLY), INTERVAL, BYDAY, BYMONTHDAY, BYMONTH, BYSETPOS, COUNT, UNTIL и WKST.
+type rrule struct {
+	freq       string
+	interval   int
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []int
+	bySetPos   []int
+	count      int
+	until      time.Time
+	wkst       time.Weekday
+}
+
+// isRRule проверяет, записано ли правило repeat в формате RFC 5545
+func isRRule(repeat string) bool {
+	upper := strings.ToUpper(repeat)
+	return strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "FREQ=")
+}
+
+// parseRRule разбирает строку вида [RRULE:]FREQ=MONTHLY;BYDAY=2MO;COUNT=5
+func parseRRule(repeat string) (*rrule, error) {
+	value := strings.ToUpper(strings.TrimSpace(repeat))
+	value = strings.TrimPrefix(value, "RRULE:")
+
+	r := &rrule{interval: 1, wkst: time.Monday}
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("[parseRRule]: неверная часть правила %q", part)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("[parseRRule]: %s указан несколько раз", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			switch val {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.freq = val
+			default:
+				return nil, fmt.Errorf("[parseRRule]: частота %s не поддерживается", val)
+			}
+		case "INTERVAL":
+			r.interval, err = parseRRuleInt(val, 1, 1000)
+		case "COUNT":
+			r.count, err = parseRRuleInt(val, 1, 10000)
+		case "UNTIL":
+			r.until, err = parseRRuleDate(val)
+		case "WKST":
+			wd, ok := rruleWeekdays[val]
+			if !ok {
+				return nil, fmt.Errorf("[parseRRule]: неверный WKST %q", val)
+			}
+			r.wkst = wd
+		case "BYDAY":
+			r.byDay, err = parseByDay(val)
+		case "BYMONTHDAY":
+			r.byMonthDay, err = parseRRuleList(val, -31, 31)
+		case "BYMONTH":
+			r.byMonth, err = parseRRuleList(val, 1, 12)
+		case "BYSETPOS":
+			r.bySetPos, err = parseRRuleList(val, -366, 366)
+		default:
+			return nil, fmt.Errorf("[parseRRule]: параметр %s не поддерживается", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// validate проверяет сочетания параметров, недопустимые по RFC 5545
+func (r *rrule) validate() error {
+	if r.freq == "" {
+		return fmt.Errorf("[parseRRule]: не указан FREQ")
+	}
+	if r.count > 0 && !r.until.IsZero() {
+		return fmt.Errorf("[parseRRule]: COUNT и UNTIL нельзя указывать одновременно")
+	}
+	if len(r.bySetPos) > 0 && len(r.byDay) == 0 && len(r.byMonthDay) == 0 && len(r.byMonth) == 0 {
+		return fmt.Errorf("[parseRRule]: BYSETPOS используется только вместе с другими BYxxx")
+	}
+	if r.freq == "WEEKLY" && len(r.byMonthDay) > 0 {
+		return fmt.Errorf("[parseRRule]: BYMONTHDAY нельзя использовать с FREQ=WEEKLY")
+	}
+	for _, wd := range r.byDay {
+		if wd.n == 0 {
+			continue
+		}
+		switch {
+		case r.freq != "MONTHLY" && r.freq != "YEARLY":
+			return fmt.Errorf("[parseRRule]: номер дня недели допустим только для MONTHLY и YEARLY")
+		case r.freq == "MONTHLY" && (wd.n < -5 || wd.n > 5):
+			return fmt.Errorf("[parseRRule]: неверный номер дня недели %d", wd.n)
+		case r.freq == "YEARLY" && len(r.byMonth) > 0 && (wd.n < -5 || wd.n > 5):
+			return fmt.Errorf("[parseRRule]: неверный номер дня недели %d", wd.n)
+		}
+	}
+	return nil
+}
+
+func parseRRuleInt(val string, min, max int) (int, error) {
+	n, err := strconv.Atoi(val)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("[parseRRule]: неверное значение %q", val)
+	}
+	return n, nil
+}
+
+// parseRRuleList разбирает список целых чисел через запятую, 0 не допускается
+func parseRRuleList(val string, min, max int) ([]int, error) {
+	var list []int
+	for _, item := range strings.Split(val, ",") {
+		n, err := parseRRuleInt(strings.TrimPrefix(item, "+"), min, max)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("[parseRRule]: неверное значение %q", item)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// parseRRuleDate разбирает UNTIL в форме 20060102 или 20060102T150405[Z], время отбрасывается
+func parseRRuleDate(val string) (time.Time, error) {
+	if len(val) < 8 {
+		return time.Time{}, fmt.Errorf("[parseRRule]: неверная дата UNTIL %q", val)
+	}
+	if len(val) > 8 && val[8] != 'T' {
+		return time.Time{}, fmt.Errorf("[parseRRule]: неверная дата UNTIL %q", val)
+	}
+	until, err := time.Parse("20060102", val[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("[parseRRule]: неверная дата UNTIL %q", val)
+	}
+	return until, nil
+}
+
+// parseByDay разбирает BYDAY вида MO,2TU,-1FR
+func parseByDay(val string) ([]weekdayNum, error) {
+	var days []weekdayNum
+	for _, item := range strings.Split(val, ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("[parseRRule]: неверный BYDAY %q", item)
+		}
+		wd, ok := rruleWeekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("[parseRRule]: неверный BYDAY %q", item)
+		}
+		n := 0
+		if prefix := item[:len(item)-2]; prefix != "" {
+			var err error
+			n, err = strconv.Atoi(strings.TrimPrefix(prefix, "+"))
+			if err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, fmt.Errorf("[parseRRule]: неверный BYDAY %q", item)
+			}
+		}
+		days = append(days, weekdayNum{n: n, weekday: wd})
+	}
+	return days, nil
+}
+
+// handleRRuleRepeat вычисляет следующую дату по правилу RFC 5545,
+// считая date началом серии (DTSTART)
+func handleRRuleRepeat(nowDate, now time.Time, repeat string) (string, error) {
+	r, err := parseRRule(repeat)
+	if err != nil {
+		return "", err
+	}
+
+	after := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if nowDate.After(after) {
+		after = nowDate
+	}
+
+	next, err := r.nextAfter(nowDate, after)
+	if err != nil {
+		return "", err
+	}
+	return next.Format("20060102"), nil
+}
+
+// nextAfter возвращает первую дату серии, начинающейся с dtstart, которая позже after
+func (r *rrule) nextAfter(dtstart, after time.Time) (time.Time, error) {
+	period := 0
+	// Без COUNT можно не перебирать периоды, которые заведомо раньше after
+	if r.count == 0 {
+		period = r.periodsBefore(dtstart, after)
+	}
+
+	// Правило может не срабатывать никогда, например FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30,
+	// поэтому поиск ограничен maxRepeatYears лет после after
+	horizon := r.periodStart(after, maxRepeatYears*periodsPerYear[r.freq]+1)
+	emitted := 0
+	for ; ; period++ {
+		start := r.periodStart(dtstart, period)
+		if !r.until.IsZero() && start.After(r.until) {
+			return time.Time{}, ErrSeriesEnded
+		}
+		if start.After(horizon) {
+			break
+		}
+
+		for _, d := range r.expand(start, dtstart) {
+			if d.Before(dtstart) {
+				continue
+			}
+			if !r.until.IsZero() && d.After(r.until) {
+				return time.Time{}, ErrSeriesEnded
+			}
+			emitted++
+			if r.count > 0 && emitted > r.count {
+				return time.Time{}, ErrSeriesEnded
+			}
+			if d.After(after) {
+				return d, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("[NextDate]: правило повторения не даёт ни одной даты")
+}
+
+// periodStart возвращает первый день period-го периода серии
+func (r *rrule) periodStart(dtstart time.Time, period int) time.Time {
+	step := period * r.interval
+	switch r.freq {
+	case "DAILY":
+		return dtstart.AddDate(0, 0, step)
+	case "WEEKLY":
+		return weekStart(dtstart, r.wkst).AddDate(0, 0, 7*step)
+	case "MONTHLY":
+		return time.Date(dtstart.Year(), dtstart.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(dtstart.Year()+step, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// periodsBefore оценивает, сколько целых периодов серии заканчиваются раньше after
+func (r *rrule) periodsBefore(dtstart, after time.Time) int {
+	if !after.After(dtstart) {
+		return 0
+	}
+	var units int
+	switch r.freq {
+	case "DAILY":
+		units = int(after.Sub(dtstart).Hours() / 24)
+	case "WEEKLY":
+		units = int(after.Sub(weekStart(dtstart, r.wkst)).Hours() / 24 / 7)
+	case "MONTHLY":
+		units = (after.Year()-dtstart.Year())*12 + int(after.Month()) - int(dtstart.Month())
+	default:
+		units = after.Year() - dtstart.Year()
+	}
+	periods := units/r.interval - 1
+	if periods < 0 {
+		return 0
+	}
+	return periods
+}
+
+// weekStart возвращает первый день недели, содержащей t, с учётом WKST
+func weekStart(t time.Time, wkst time.Weekday) time.Time {
+	shift := (int(t.Weekday()) - int(wkst) + 7) % 7
+	return t.AddDate(0, 0, -shift)
+}
+
+// expand возвращает упорядоченные даты периода, начинающегося со start
+func (r *rrule) expand(start, dtstart time.Time) []time.Time {
+	var dates []time.Time
+
+	switch r.freq {
+	case "DAILY":
+		if r.matchMonth(start) && r.matchMonthDay(start) && r.matchWeekday(start) {
+			dates = append(dates, start)
+		}
+	case "WEEKLY":
+		for i := 0; i < 7; i++ {
+			d := start.AddDate(0, 0, i)
+			// Даты первой недели раньше DTSTART отбрасывает nextAfter: по RFC 5545
+			// BYSETPOS выбирает позиции из всей недели, начиная с WKST
+			if !r.matchMonth(d) {
+				continue
+			}
+			if len(r.byDay) == 0 && d.Weekday() != dtstart.Weekday() {
+				continue
+			}
+			if r.matchWeekday(d) {
+				dates = append(dates, d)
+			}
+		}
+	case "MONTHLY":
+		if r.matchMonth(start) {
+			dates = r.expandMonth(start, dtstart)
+		}
+	default:
+		dates = r.expandYear(start, dtstart)
+	}
+
+	return r.applySetPos(dates)
+}
+
+// expandMonth возвращает даты месяца, начинающегося с first, подходящие под BYMONTHDAY и BYDAY
+func (r *rrule) expandMonth(first, dtstart time.Time) []time.Time {
+	var dates []time.Time
+	if len(r.byMonthDay) == 0 && len(r.byDay) == 0 {
+		if dtstart.Day() <= daysInMonth(first) {
+			dates = append(dates, first.AddDate(0, 0, dtstart.Day()-1))
+		}
+		return dates
+	}
+
+	for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+		if r.matchMonthDay(d) && matchWeekdayIn(r.byDay, d, d.Day(), daysInMonth(d)) {
+			dates = append(dates, d)
+		}
+	}
+	return dates
+}
+
+// expandYear возвращает даты года, начинающегося с first, по правилам FREQ=YEARLY
+func (r *rrule) expandYear(first, dtstart time.Time) []time.Time {
+	var dates []time.Time
+
+	switch {
+	case len(r.byMonth) == 0 && len(r.byMonthDay) == 0 && len(r.byDay) == 0:
+		// Дата DTSTART каждый год; 29 февраля пропускается в невисокосные годы
+		d := time.Date(first.Year(), dtstart.Month(), dtstart.Day(), 0, 0, 0, 0, time.UTC)
+		if d.Day() == dtstart.Day() {
+			dates = append(dates, d)
+		}
+	case len(r.byMonth) == 0 && len(r.byDay) > 0:
+		// BYDAY без BYMONTH: номера дней недели считаются в пределах года
+		yearLen := time.Date(first.Year(), time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		for d := first; d.Year() == first.Year(); d = d.AddDate(0, 0, 1) {
+			if r.matchMonthDay(d) && matchWeekdayIn(r.byDay, d, d.YearDay(), yearLen) {
+				dates = append(dates, d)
+			}
+		}
+	default:
+		for m := time.January; m <= time.December; m++ {
+			monthStart := time.Date(first.Year(), m, 1, 0, 0, 0, 0, time.UTC)
+			if !r.matchMonth(monthStart) {
+				continue
+			}
+			if len(r.byMonthDay) == 0 && len(r.byDay) == 0 {
+				if dtstart.Day() <= daysInMonth(monthStart) {
+					dates = append(dates, monthStart.AddDate(0, 0, dtstart.Day()-1))
+				}
+				continue
+			}
+			dates = append(dates, r.expandMonth(monthStart, dtstart)...)
+		}
+	}
+	return dates
+}
+
+func (r *rrule) matchMonth(d time.Time) bool {
+	if len(r.byMonth) == 0 {
+		return true
+	}
+	for _, m := range r.byMonth {
+		if time.Month(m) == d.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rrule) matchMonthDay(d time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	last := daysInMonth(d)
+	for _, md := range r.byMonthDay {
+		if md == d.Day() || (md < 0 && last+md+1 == d.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWeekday проверяет день недели без учёта порядковых номеров (для DAILY и WEEKLY)
+func (r *rrule) matchWeekday(d time.Time) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, wd := range r.byDay {
+		if wd.weekday == d.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWeekdayIn проверяет BYDAY с порядковыми номерами в пределах периода длиной length,
+// где pos — номер дня d в этом периоде, начиная с 1
+func matchWeekdayIn(byDay []weekdayNum, d time.Time, pos, length int) bool {
+	if len(byDay) == 0 {
+		return true
+	}
+	for _, wd := range byDay {
+		if wd.weekday != d.Weekday() {
+			continue
+		}
+		switch {
+		case wd.n == 0:
+			return true
+		case wd.n > 0 && (pos-1)/7+1 == wd.n:
+			return true
+		case wd.n < 0 && (length-pos)/7+1 == -wd.n:
+			return true
+		}
+	}
+	return false
+}
+
+// applySetPos оставляет из дат периода только позиции, перечисленные в BYSETPOS
+func (r *rrule) applySetPos(dates []time.Time) []time.Time {
+	if len(r.bySetPos) == 0 || len(dates) == 0 {
+		return dates
+	}
+
+	picked := make(map[int]bool)
+	for _, pos := range r.bySetPos {
+		idx := pos - 1
+		if pos < 0 {
+			idx = len(dates) + pos
+		}
+		if idx >= 0 && idx < len(dates) {
+			picked[idx] = true
+		}
+	}
+
+	indexes := make([]int, 0, len(picked))
+	for idx := range picked {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	result := make([]time.Time, 0, len(indexes))
+	for _, idx := range indexes {
+		result = append(result, dates[idx])
+	}
+	return result
+}
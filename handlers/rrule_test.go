@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRRule(t *testing.T) {
+	r, err := parseRRule("RRULE:FREQ=MONTHLY;INTERVAL=2;BYDAY=2MO,-1FR;BYMONTH=1,7;COUNT=5;WKST=SU")
+	require.NoError(t, err)
+	assert.Equal(t, "MONTHLY", r.freq)
+	assert.Equal(t, 2, r.interval)
+	assert.Equal(t, []weekdayNum{{2, time.Monday}, {-1, time.Friday}}, r.byDay)
+	assert.Equal(t, []int{1, 7}, r.byMonth)
+	assert.Equal(t, 5, r.count)
+	assert.Equal(t, time.Sunday, r.wkst)
+
+	r, err = parseRRule("FREQ=DAILY;UNTIL=20240301T235959Z")
+	require.NoError(t, err)
+	assert.Equal(t, "20240301", r.until.Format("20060102"))
+
+	for _, bad := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=SECONDLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20240101",
+		"FREQ=DAILY;BYDAY=2MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=MONTHLY;BYSETPOS=1",
+		"FREQ=YEARLY;BYYEARDAY=100",
+		"FREQ=YEARLY;UNTIL=2024",
+		"FREQ=WEEKLY;BYDAY=MON",
+	} {
+		_, err := parseRRule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNextDateRRule(t *testing.T) {
+	now := time.Date(2024, 1, 26, 15, 0, 0, 0, time.Local)
+
+	tbl := []struct {
+		date, repeat, want string
+	}{
+		{"20240101", "FREQ=DAILY", "20240127"},
+		{"20240126", "FREQ=DAILY", "20240127"},
+		{"20240101", "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH", "20240130"},
+		{"20240101", "FREQ=MONTHLY;BYDAY=1MO,3MO", "20240205"},
+		{"20240131", "FREQ=MONTHLY", "20240331"},
+		{"20240101", "FREQ=YEARLY;BYDAY=1MO", "20250106"},
+		{"20240101", "FREQ=YEARLY;BYMONTH=1,7;BYDAY=-1SU", "20240128"},
+		{"20200229", "FREQ=YEARLY", "20240229"},
+		{"20200229", "FREQ=YEARLY;INTERVAL=3", "20320229"},
+		{"16890220", "FREQ=YEARLY", "20240220"},
+		{"20240101", "FREQ=MONTHLY;BYMONTHDAY=1,15,-1;BYSETPOS=2", "20240215"},
+	}
+	for _, v := range tbl {
+		got, err := NextDate(now, v.date, v.repeat)
+		require.NoError(t, err, v.repeat)
+		assert.Equal(t, v.want, got, "%s %s", v.date, v.repeat)
+	}
+
+	_, err := NextDate(now, "20240101", "FREQ=DAILY;COUNT=26")
+	assert.ErrorIs(t, err, ErrSeriesEnded)
+	got, err := NextDate(now, "20240101", "FREQ=DAILY;COUNT=27")
+	require.NoError(t, err)
+	assert.Equal(t, "20240127", got)
+	_, err = NextDate(now, "20240101", "FREQ=DAILY;UNTIL=20240126")
+	assert.ErrorIs(t, err, ErrSeriesEnded)
+
+	// BYSETPOS выбирает из всей недели: серия со среды пропускает первую неделю,
+	// потому что её первый день по правилу — понедельник до DTSTART
+	got, err = NextDate(time.Date(2024, 1, 24, 0, 0, 0, 0, time.Local), "20240124", "FREQ=WEEKLY;BYDAY=MO,FR;BYSETPOS=1")
+	require.NoError(t, err)
+	assert.Equal(t, "20240129", got)
+	got, err = NextDate(time.Date(2024, 1, 24, 0, 0, 0, 0, time.Local), "20240124", "FREQ=WEEKLY;BYDAY=MO,FR;BYSETPOS=-1")
+	require.NoError(t, err)
+	assert.Equal(t, "20240126", got)
+}
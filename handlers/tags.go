@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"final_project/apierror"
+	"final_project/database"
+)
+
+// Наибольшая длина имени метки
+const maxTagName = 64
+
+// tagName() проверяет имя метки и возвращает его без пробелов по краям.
+// Кавычки в имени запрещены: иначе метку нельзя найти запросом tag:"имя"
+func tagName(field, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxTagName {
+		return "", apierror.Field(field, fmt.Sprintf("имя метки должно содержать от 1 до %d символов", maxTagName))
+	}
+	if strings.Contains(name, `"`) {
+		return "", apierror.Field(field, "имя метки не может содержать кавычки")
+	}
+	return name, nil
+}
+
+// prepareTags() проверяет метки задачи и убирает повторы. nil остаётся nil:
+// метки задачи при изменении не трогаются
+func prepareTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	names := []string{}
+	for _, tag := range tags {
+		name, err := tagName("tags", tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// tagID() разбирает идентификатор метки
+func tagID(field, value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, apierror.Field(field, "некорректный идентификатор метки")
+	}
+	return id, nil
+}
+
+// handleTagError() отвечает на ошибку хранилища меток: занятое имя — 409
+func handleTagError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrTagExists) {
+		apierror.Write(rw, apierror.New(http.StatusConflict, err.Error()).For("name"))
+		return
+	}
+	handledbError(rw, err)
+}
+
+// TagsHandler() обрабатывает запросы по адресу /api/tags к меткам текущего
+// пользователя: GET — список с числом задач, POST {"name"} — создание,
+// PUT {"id", "name"} — переименование, DELETE ?id= — удаление метки
+// и снятие её со всех задач
+func TagsHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	tags := tasksOf(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := tags.ListTags()
+		if err != nil {
+			handledbError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct {
+			Tags []database.Tag `json:"tags"`
+		}{Tags: list})
+	case http.MethodPost, http.MethodPut:
+		var req struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+			return
+		}
+		name, err := tagName("name", req.Name)
+		if err != nil {
+			apierror.Write(rw, err)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			id, err := tags.CreateTag(name)
+			if err != nil {
+				handleTagError(rw, err)
+				return
+			}
+			respondWithJSON(rw, struct {
+				ID string `json:"id"`
+			}{strconv.FormatInt(id, 10)})
+			return
+		}
+
+		id, err := tagID("id", req.ID)
+		if err != nil {
+			apierror.Write(rw, err)
+			return
+		}
+		if err := tags.RenameTag(id, name); err != nil {
+			handleTagError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct{}{})
+	case http.MethodDelete:
+		id, err := tagID("id", r.FormValue("id"))
+		if err != nil {
+			apierror.Write(rw, err)
+			return
+		}
+		if err := tags.DeleteTag(id); err != nil {
+			handledbError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct{}{})
+	default:
+		apierror.MethodNotAllowed(rw, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// TagsMergeHandler() обрабатывает POST-запросы по адресу /api/tags/merge
+// {"from", "to"}: задачи с меткой from получают метку to, а from удаляется
+func TagsMergeHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodPost) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+		return
+	}
+	from, err := tagID("from", req.From)
+	if err != nil {
+		apierror.Write(rw, err)
+		return
+	}
+	to, err := tagID("to", req.To)
+	if err != nil {
+		apierror.Write(rw, err)
+		return
+	}
+	if from == to {
+		apierror.Write(rw, apierror.Field("to", "метку нельзя объединить с самой собой"))
+		return
+	}
+
+	if err := tasksOf(r.Context()).MergeTags(from, to); err != nil {
+		handledbError(rw, err)
+		return
+	}
+	respondWithJSON(rw, struct{}{})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"final_project/apierror"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskTags(t *testing.T) {
+	useMemoryStore(t)
+	today := time.Now().Format("20060102")
+
+	m := doRequest(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{
+		"date": today, "title": "Тренировка", "tags": []string{" спорт ", "здоровье", "спорт"},
+	})
+	require.NotContains(t, m, "error")
+	id := m["id"].(string)
+
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+id, nil)
+	assert.Equal(t, []any{"здоровье", "спорт"}, m["tags"])
+
+	// Без поля tags метки задачи не меняются, пустой список снимает их
+	m = doRequest(t, TaskHandler, http.MethodPut, "/api/task", map[string]any{"id": id, "date": today, "title": "Бег"})
+	require.NotContains(t, m, "error")
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+id, nil)
+	assert.Equal(t, []any{"здоровье", "спорт"}, m["tags"])
+	m = doRequest(t, TaskHandler, http.MethodPut, "/api/task", map[string]any{"id": id, "date": today, "title": "Бег", "tags": []string{}})
+	require.NotContains(t, m, "error")
+	m = doRequest(t, TaskHandler, http.MethodGet, "/api/task?id="+id, nil)
+	assert.Equal(t, []any{}, m["tags"])
+
+	for _, tags := range [][]string{{""}, {`"цитата"`}, {string(make([]rune, maxTagName+1))}} {
+		code, e := apiError(t, TaskHandler, http.MethodPost, "/api/task", map[string]any{"date": today, "title": "x", "tags": tags})
+		assert.Equal(t, http.StatusBadRequest, code, tags)
+		assert.Contains(t, e.Details, "tags", tags)
+	}
+}
+
+func TestTagsHandler(t *testing.T) {
+	useMemoryStore(t)
+	today := time.Now().Format("20060102")
+	for _, task := range []map[string]any{
+		{"date": today, "title": "Отчёт", "tags": []string{"работа", "срочно"}},
+		{"date": today, "title": "Созвон", "tags": []string{"работа"}},
+		{"date": today, "title": "Уборка", "tags": []string{"дом"}},
+	} {
+		require.NotContains(t, doRequest(t, TaskHandler, http.MethodPost, "/api/task", task), "error")
+	}
+
+	tags := func() map[string]string {
+		t.Helper()
+		m := doRequest(t, TagsHandler, http.MethodGet, "/api/tags", nil)
+		ids := map[string]string{}
+		for _, tag := range m["tags"].([]any) {
+			tag := tag.(map[string]any)
+			ids[tag["name"].(string)] = tag["id"].(string)
+		}
+		return ids
+	}
+	titles := func(query string) []string {
+		t.Helper()
+		m := doRequest(t, TasksHandler, http.MethodGet, "/api/tasks?"+query, nil)
+		require.NotContains(t, m, "error", query)
+		titles := []string{}
+		for _, task := range m["tasks"].([]any) {
+			titles = append(titles, task.(map[string]any)["title"].(string))
+		}
+		return titles
+	}
+
+	m := doRequest(t, TagsHandler, http.MethodGet, "/api/tags", nil)
+	require.Len(t, m["tags"], 3)
+	assert.Equal(t, map[string]any{"id": tags()["работа"], "name": "работа", "tasks": float64(2)}, m["tags"].([]any)[1])
+
+	assert.Equal(t, []string{"Отчёт", "Созвон"}, titles("tag="+url.QueryEscape("работа")))
+	assert.Equal(t, []string{"Отчёт"}, titles("tag="+url.QueryEscape("работа")+"&tag="+url.QueryEscape("срочно")))
+	assert.Equal(t, []string{"Созвон"}, titles("search="+url.QueryEscape("tag:работа -tag:срочно")))
+
+	m = doRequest(t, TagsHandler, http.MethodPost, "/api/tags", map[string]any{"name": "учёба"})
+	require.NotContains(t, m, "error")
+	code, e := apiError(t, TagsHandler, http.MethodPost, "/api/tags", map[string]any{"name": "дом"})
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Contains(t, e.Details, "name")
+
+	// Переименование и объединение сразу видны в задачах
+	ids := tags()
+	m = doRequest(t, TagsHandler, http.MethodPut, "/api/tags", map[string]any{"id": ids["срочно"], "name": "важно"})
+	require.NotContains(t, m, "error")
+	assert.Equal(t, []string{"Отчёт"}, titles("tag="+url.QueryEscape("важно")))
+	code, _ = apiError(t, TagsHandler, http.MethodPut, "/api/tags", map[string]any{"id": ids["дом"], "name": "работа"})
+	assert.Equal(t, http.StatusConflict, code)
+
+	m = doRequest(t, TagsMergeHandler, http.MethodPost, "/api/tags/merge", map[string]any{"from": ids["дом"], "to": ids["работа"]})
+	require.NotContains(t, m, "error")
+	assert.Equal(t, []string{"Отчёт", "Созвон", "Уборка"}, titles("tag="+url.QueryEscape("работа")))
+	assert.NotContains(t, tags(), "дом")
+
+	m = doRequest(t, TagsHandler, http.MethodDelete, "/api/tags?id="+ids["работа"], nil)
+	require.NotContains(t, m, "error")
+	assert.Empty(t, titles("tag="+url.QueryEscape("работа")))
+	assert.Equal(t, []string{"Отчёт"}, titles("tag="+url.QueryEscape("важно")))
+
+	for _, tc := range []struct {
+		h      http.HandlerFunc
+		method string
+		target string
+		body   any
+		status int
+		field  string
+	}{
+		{TagsHandler, http.MethodPost, "/api/tags", map[string]any{"name": " "}, 400, "name"},
+		{TagsHandler, http.MethodPut, "/api/tags", map[string]any{"id": "x", "name": "a"}, 400, "id"},
+		{TagsHandler, http.MethodPut, "/api/tags", map[string]any{"id": "404", "name": "a"}, 404, ""},
+		{TagsHandler, http.MethodDelete, "/api/tags?id=404", nil, 404, ""},
+		{TagsHandler, http.MethodPatch, "/api/tags", nil, 405, ""},
+		{TagsMergeHandler, http.MethodPost, "/api/tags/merge", map[string]any{"from": ids["учёба"], "to": ids["учёба"]}, 400, "to"},
+		{TagsMergeHandler, http.MethodPost, "/api/tags/merge", map[string]any{"from": ids["учёба"], "to": "404"}, 404, ""},
+		{TagsMergeHandler, http.MethodGet, "/api/tags/merge", nil, 405, ""},
+		{TasksHandler, http.MethodGet, "/api/tasks?tag=", nil, 400, "tag"},
+	} {
+		code, e := apiError(t, tc.h, tc.method, tc.target, tc.body)
+		assert.Equal(t, tc.status, code, tc.target, tc.body)
+		if tc.field != "" {
+			assert.Contains(t, e.Details, tc.field, tc.target)
+			assert.Equal(t, apierror.CodeValidationFailed, e.Code)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"final_project/apierror"
+	"final_project/auth"
+	"final_project/database"
+)
+
+// respondWithMFAToken() просит пользователя, подтвердившего пароль, ввести код
+// второго фактора и выдаёт токен для второго шага входа
+func respondWithMFAToken(rw http.ResponseWriter, user database.User) {
+	token, err := auth.IssueMFAToken(user)
+	if err != nil {
+		respondWithStatus(rw, http.StatusInternalServerError, fmt.Sprintf("ошибка генерации токена: %v", err))
+		return
+	}
+	respondWithJSON(rw, struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{true, token, int(auth.MFATTL.Seconds())})
+}
+
+// TOTPHandler() обрабатывает запросы по адресу /api/user/totp к двухфакторной
+// аутентификации текущего пользователя: GET — состояние, POST — новый секрет
+// и URI для приложения, PUT {"code"} — подтверждение и коды восстановления,
+// DELETE {"password", "code"} — отключение (пользователю без пароля достаточно
+// кода). Запросы с API-токеном отклоняются
+func TOTPHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if _, ok := auth.APITokenFromContext(r.Context()); ok {
+		respondWithStatus(rw, http.StatusForbidden, "API-токеном нельзя управлять двухфакторной аутентификацией")
+		return
+	}
+	user, err := store.GetUser(auth.UserFromContext(r.Context()).ID)
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		codes, err := store.CountRecoveryCodes(user.ID)
+		if err != nil {
+			handledbError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct {
+			Enabled       bool `json:"enabled"`
+			RecoveryCodes int  `json:"recovery_codes"`
+		}{user.TOTPEnabled, codes})
+	case http.MethodPost:
+		secret, uri, err := auth.BeginTOTP(user)
+		if err != nil {
+			respondWithTOTPError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		}{secret, uri})
+	case http.MethodPut:
+		codes, err := auth.ConfirmTOTP(user, req.Code)
+		if err != nil {
+			respondWithTOTPError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}{codes})
+	case http.MethodDelete:
+		if err := auth.DisableTOTP(user, req.Password, req.Code); err != nil {
+			respondWithTOTPError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct{}{})
+	default:
+		apierror.MethodNotAllowed(rw, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func respondWithTOTPError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrBadCredentials):
+		apierror.Write(rw, apierror.Field("password", "Неверный пароль"))
+	case errors.Is(err, auth.ErrBadCode):
+		apierror.Write(rw, apierror.Field("code", err.Error()))
+	case errors.Is(err, auth.ErrTOTPEnabled), errors.Is(err, auth.ErrTOTPNotEnabled):
+		respondWithStatus(rw, http.StatusConflict, err.Error())
+	default:
+		handledbError(rw, err)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"final_project/auth"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPSignIn(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("TODO_PASSWORD", "secret-password")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	jwt.TimeFunc = func() time.Time { return now }
+	t.Cleanup(func() { jwt.TimeFunc = time.Now })
+	ivan := addUser(t, mem, "ivan", "ivan-password")
+
+	// Подключение: секрет, подтверждение кодом, коды восстановления
+	_, m := doUserRequest(t, TOTPHandler, ivan, http.MethodPost, "/api/user/totp", nil)
+	secret := m["secret"].(string)
+	assert.True(t, strings.HasPrefix(m["uri"].(string), "otpauth://totp/Scheduler:ivan?"))
+	_, m = doUserRequest(t, TOTPHandler, ivan, http.MethodPut, "/api/user/totp", map[string]string{"code": "000000"})
+	assert.Contains(t, m, "error")
+	code, err := auth.TOTPCode(secret, now)
+	require.NoError(t, err)
+	_, m = doUserRequest(t, TOTPHandler, ivan, http.MethodPut, "/api/user/totp", map[string]string{"code": code})
+	recovery := m["recovery_codes"].([]any)
+	require.Len(t, recovery, auth.RecoveryCodeCount)
+	_, m = doUserRequest(t, TOTPHandler, ivan, http.MethodGet, "/api/user/totp", nil)
+	assert.Equal(t, true, m["enabled"])
+	assert.EqualValues(t, auth.RecoveryCodeCount, m["recovery_codes"])
+
+	signIn := func(body map[string]string) (int, map[string]any) {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/signin", strings.NewReader(string(b)))
+		rec := httptest.NewRecorder()
+		SignInHandler(rec, req)
+		var m map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &m), rec.Body.String())
+		return rec.Code, m
+	}
+
+	// Верный пароль без кода даёт только токен второго шага
+	_, m = signIn(map[string]string{"login": "ivan", "password": "ivan-password"})
+	assert.Equal(t, true, m["mfa_required"])
+	assert.NotContains(t, m, "token")
+	mfa := m["mfa_token"].(string)
+
+	now = now.Add(30 * time.Second)
+	code, err = auth.TOTPCode(secret, now)
+	require.NoError(t, err)
+	_, m = signIn(map[string]string{"mfa_token": mfa, "code": "000000"})
+	assert.Equal(t, "Неверный код", m["error"])
+	_, m = signIn(map[string]string{"mfa_token": mfa, "code": code})
+	assert.NotEmpty(t, m["token"])
+	status, m := signIn(map[string]string{"mfa_token": mfa, "code": code})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Contains(t, m, "error")
+
+	// Код можно передать вместе с паролем, в том числе код восстановления
+	_, m = signIn(map[string]string{"login": "ivan", "password": "ivan-password", "code": code})
+	assert.Contains(t, m, "error", "код уже использован")
+	_, m = signIn(map[string]string{"login": "ivan", "password": "ivan-password", "code": recovery[0].(string)})
+	assert.NotEmpty(t, m["token"])
+
+	// Отключение требует пароль и код
+	_, m = doUserRequest(t, TOTPHandler, ivan, http.MethodDelete, "/api/user/totp",
+		map[string]string{"password": "wrong", "code": recovery[1].(string)})
+	assert.Equal(t, "Неверный пароль", m["error"])
+	_, m = doUserRequest(t, TOTPHandler, ivan, http.MethodDelete, "/api/user/totp",
+		map[string]string{"password": "ivan-password", "code": recovery[1].(string)})
+	assert.Empty(t, m)
+	_, m = signIn(map[string]string{"login": "ivan", "password": "ivan-password"})
+	assert.NotEmpty(t, m["token"])
+}
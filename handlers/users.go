@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"final_project/apierror"
+	"final_project/auth"
+	"final_project/database"
+)
+
+// Минимальная длина пароля новых пользователей
+const MinPasswordLength = 8
+
+// loginPattern — допустимые логины пользователей
+var loginPattern = regexp.MustCompile(`^[a-zA-Z0-9._@-]{3,64}$`)
+
+// userRequest — тело запросов на создание пользователя и смену пароля
+type userRequest struct {
+	Login       string `json:"login"`
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password"`
+	Admin       bool   `json:"admin"`
+	Role        string `json:"role"`
+}
+
+// SignUpHandler() обрабатывает POST-запросы по адресу /api/signup.
+// Самостоятельная регистрация доступна, только если TODO_REGISTRATION=open,
+// иначе пользователей добавляет администратор через /api/users
+func SignUpHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodPost) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if os.Getenv("TODO_REGISTRATION") != "open" {
+		respondWithStatus(rw, http.StatusForbidden, "регистрация закрыта, обратитесь к администратору")
+		return
+	}
+
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+		return
+	}
+	createUser(rw, req.Login, req.Password, false, database.RoleEditor)
+}
+
+// UsersHandler() обрабатывает запросы администратора по адресу /api/users:
+// GET — список пользователей, POST — добавление, PUT ?id= — изменение роли
+// и прав администратора, DELETE ?id= — удаление вместе с задачами
+func UsersHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	current := auth.UserFromContext(r.Context())
+	if !current.Admin {
+		respondWithStatus(rw, http.StatusForbidden, "недостаточно прав")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		users, err := store.ListUsers()
+		if err != nil {
+			handledbError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct {
+			Users []database.User `json:"users"`
+		}{Users: users})
+	case http.MethodPost:
+		var req userRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+			return
+		}
+		createUser(rw, req.Login, req.Password, req.Admin, req.Role)
+	case http.MethodPut:
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			apierror.Write(rw, apierror.Field("id", "некорректный идентификатор пользователя"))
+			return
+		}
+		var req userRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+			return
+		}
+		if !validRole(req.Role) {
+			apierror.Write(rw, apierror.Field("role", roleError))
+			return
+		}
+		if !req.Admin && (id == current.ID || id == database.DefaultUserID) {
+			apierror.Write(rw, apierror.Field("admin", "у этого пользователя нельзя отнять права администратора"))
+			return
+		}
+		if err := store.SetRole(id, req.Role, req.Admin); err != nil {
+			handledbError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct{}{})
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			apierror.Write(rw, apierror.Field("id", "некорректный идентификатор пользователя"))
+			return
+		}
+		if id == current.ID || id == database.DefaultUserID {
+			respondWithStatus(rw, http.StatusConflict, "этого пользователя удалить нельзя")
+			return
+		}
+		if err := store.DeleteUser(id); err != nil {
+			handledbError(rw, err)
+			return
+		}
+		respondWithJSON(rw, struct{}{})
+	default:
+		apierror.MethodNotAllowed(rw, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// Размер страницы журнала попыток входа по умолчанию и наибольший
+const (
+	signInLogLimit    = 100
+	maxSignInLogLimit = 1000
+)
+
+// SignInLogHandler() обрабатывает GET-запросы администратора по адресу /api/signins:
+// возвращает последние попытки входа, не более limit (по умолчанию 100)
+func SignInLogHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodGet) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if !auth.UserFromContext(r.Context()).Admin {
+		respondWithStatus(rw, http.StatusForbidden, "недостаточно прав")
+		return
+	}
+
+	limit := signInLogLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			apierror.Write(rw, apierror.Field("limit", "некорректный limit"))
+			return
+		}
+		limit = min(n, maxSignInLogLimit)
+	}
+	attempts, err := store.ListSignIns(limit)
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+	respondWithJSON(rw, struct {
+		Attempts []database.SignInAttempt `json:"attempts"`
+	}{Attempts: attempts})
+}
+
+// roleError — сообщение о недопустимой роли
+const roleError = "роль должна быть viewer или editor"
+
+// validRole() проверяет, что роль пользователя допустима
+func validRole(role string) bool {
+	return role == database.RoleViewer || role == database.RoleEditor
+}
+
+// createUser() проверяет логин, пароль и роль и добавляет пользователя.
+// Пустая роль означает редактора
+func createUser(rw http.ResponseWriter, login, password string, admin bool, role string) {
+	if !loginPattern.MatchString(login) {
+		apierror.Write(rw, apierror.Field("login", "логин должен состоять из 3–64 латинских букв, цифр и символов . _ @ -"))
+		return
+	}
+	if len(password) < MinPasswordLength {
+		apierror.Write(rw, apierror.Field("password", fmt.Sprintf("пароль должен быть не короче %d символов", MinPasswordLength)))
+		return
+	}
+	if role == "" {
+		role = database.RoleEditor
+	}
+	if !validRole(role) {
+		apierror.Write(rw, apierror.Field("role", roleError))
+		return
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		apierror.Write(rw, err)
+		return
+	}
+	id, err := store.CreateUser(database.User{Login: login, PasswordHash: hash, Admin: admin, Role: role})
+	if errors.Is(err, database.ErrUserExists) {
+		apierror.Write(rw, apierror.New(http.StatusConflict, err.Error()).For("login"))
+		return
+	}
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+	respondWithJSON(rw, struct {
+		ID string `json:"id"`
+	}{ID: strconv.FormatInt(id, 10)})
+}
+
+// PasswordHandler() обрабатывает POST-запросы по адресу /api/user/password:
+// пользователь меняет свой пароль, подтверждая его текущим
+func PasswordHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodPost) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(rw, fmt.Sprintf("ошибка десериализации: %v", err))
+		return
+	}
+
+	user, err := store.GetUser(auth.UserFromContext(r.Context()).ID)
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+	if !auth.CheckPassword(user, req.Password) {
+		apierror.Write(rw, apierror.Field("password", "Неверный пароль"))
+		return
+	}
+	if len(req.NewPassword) < MinPasswordLength {
+		apierror.Write(rw, apierror.Field("new_password", fmt.Sprintf("пароль должен быть не короче %d символов", MinPasswordLength)))
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		apierror.Write(rw, err)
+		return
+	}
+	if err := store.SetPassword(user.ID, hash); err != nil {
+		handledbError(rw, err)
+		return
+	}
+
+	// Смена пароля отзывает все токены пользователя, текущий сеанс получает новые
+	user, err = store.GetUser(user.ID)
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+	respondWithTokens(rw, user)
+}
+
+// respondWithTokens() выдаёт пользователю новую пару токенов в ответе и cookie
+func respondWithTokens(rw http.ResponseWriter, user database.User) {
+	tokens, err := auth.IssueTokens(user)
+	if err != nil {
+		respondWithStatus(rw, http.StatusInternalServerError, fmt.Sprintf("ошибка генерации токена: %v", err))
+		return
+	}
+	auth.SetRefreshCookie(rw, tokens.RefreshToken, int(auth.RefreshTTL.Seconds()))
+	respondWithJSON(rw, tokens)
+}
+
+// refreshToken() возвращает refresh-токен из тела запроса или cookie
+func refreshToken(r *http.Request) string {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.RefreshToken != "" {
+		return body.RefreshToken
+	}
+	if cookie, err := r.Cookie(auth.RefreshCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// RefreshHandler() обрабатывает POST-запросы по адресу /api/refresh: обменивает
+// refresh-токен на новую пару токенов. Каждый refresh-токен действует один раз
+func RefreshHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodPost) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	tokens, err := auth.Refresh(refreshToken(r))
+	if errors.Is(err, auth.ErrInvalidToken) {
+		respondWithStatus(rw, http.StatusUnauthorized, auth.ErrInvalidToken.Error())
+		return
+	}
+	if err != nil {
+		handledbError(rw, err)
+		return
+	}
+	auth.SetRefreshCookie(rw, tokens.RefreshToken, int(auth.RefreshTTL.Seconds()))
+	respondWithJSON(rw, tokens)
+}
+
+// SignOutHandler() обрабатывает POST-запросы по адресу /api/signout: отзывает
+// access-токен из cookie и refresh-токен из тела запроса или cookie
+func SignOutHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireMethod(rw, r, http.MethodPost) {
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	tokens := []string{refreshToken(r)}
+	if cookie, err := r.Cookie(auth.TokenCookie); err == nil {
+		tokens = append(tokens, cookie.Value)
+	}
+	for _, token := range tokens {
+		if err := auth.Revoke(token); err != nil {
+			handledbError(rw, err)
+			return
+		}
+	}
+
+	http.SetCookie(rw, &http.Cookie{Name: auth.TokenCookie, Path: "/", MaxAge: -1})
+	auth.SetRefreshCookie(rw, "", -1)
+	respondWithJSON(rw, struct{}{})
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"final_project/auth"
+	"final_project/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// doUserRequest() выполняет запрос к обработчику от имени пользователя
+// и возвращает статус и разобранный JSON-ответ.
+func doUserRequest(t *testing.T, h http.HandlerFunc, user database.User, method, target string, body any) (int, map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	req = req.WithContext(auth.WithUser(req.Context(), user))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &m), rec.Body.String())
+	return rec.Code, m
+}
+
+// addUser() добавляет в хранилище пользователя с паролем.
+func addUser(t *testing.T, mem *database.MemoryStore, login, password string) database.User {
+	t.Helper()
+	hash, err := auth.HashPassword(password)
+	require.NoError(t, err)
+	id, err := mem.CreateUser(database.User{Login: login, PasswordHash: hash})
+	require.NoError(t, err)
+	user, err := mem.GetUser(id)
+	require.NoError(t, err)
+	return user
+}
+
+func TestSignIn(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("TODO_PASSWORD", "secret-password")
+	hash, err := auth.HashPassword("secret-password")
+	require.NoError(t, err)
+	require.NoError(t, mem.SetPassword(database.DefaultUserID, hash))
+	addUser(t, mem, "ivan", "ivan-password")
+
+	for _, tc := range []struct {
+		body  map[string]any
+		valid bool
+	}{
+		{map[string]any{"password": "secret-password"}, true},
+		{map[string]any{"login": "admin", "password": "secret-password"}, true},
+		{map[string]any{"login": "ivan", "password": "ivan-password"}, true},
+		{map[string]any{"login": "ivan", "password": "secret-password"}, false},
+		{map[string]any{"password": "ivan-password"}, false},
+		{map[string]any{"login": "nobody", "password": "secret-password"}, false},
+	} {
+		m := doRequest(t, SignInHandler, http.MethodPost, "/api/signin", tc.body)
+		if tc.valid {
+			assert.NotEmpty(t, m["token"], tc.body)
+		} else {
+			assert.Contains(t, m, "error", tc.body)
+		}
+	}
+}
+
+func TestSignUp(t *testing.T) {
+	mem := useMemoryStore(t)
+
+	code, m := doUserRequest(t, SignUpHandler, auth.DefaultUser, http.MethodPost, "/api/signup",
+		map[string]any{"login": "ivan", "password": "ivan-password"})
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, m, "error")
+
+	t.Setenv("TODO_REGISTRATION", "open")
+	_, m = doUserRequest(t, SignUpHandler, auth.DefaultUser, http.MethodPost, "/api/signup",
+		map[string]any{"login": "ivan", "password": "ivan-password", "admin": true})
+	require.NotContains(t, m, "error")
+	user, err := mem.GetUserByLogin("ivan")
+	require.NoError(t, err)
+	assert.False(t, user.Admin, "при регистрации права администратора не выдаются")
+	assert.True(t, auth.CheckPassword(user, "ivan-password"))
+
+	for _, body := range []map[string]any{
+		{"login": "ivan", "password": "ivan-password"},
+		{"login": "i", "password": "ivan-password"},
+		{"login": "петя", "password": "ivan-password"},
+		{"login": "petr", "password": "short"},
+	} {
+		_, m = doUserRequest(t, SignUpHandler, auth.DefaultUser, http.MethodPost, "/api/signup", body)
+		assert.Contains(t, m, "error", body)
+	}
+}
+
+func TestUsersHandler(t *testing.T) {
+	mem := useMemoryStore(t)
+	ivan := addUser(t, mem, "ivan", "ivan-password")
+
+	code, m := doUserRequest(t, UsersHandler, ivan, http.MethodGet, "/api/users", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, m, "error")
+
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodPost, "/api/users",
+		map[string]any{"login": "petr", "password": "petr-password", "admin": true})
+	require.NotContains(t, m, "error")
+	petrID := m["id"].(string)
+
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodGet, "/api/users", nil)
+	users := m["users"].([]any)
+	require.Len(t, users, 3)
+	petr := users[2].(map[string]any)
+	assert.Equal(t, "petr", petr["login"])
+	assert.Equal(t, true, petr["admin"])
+	assert.Equal(t, "editor", petr["role"])
+	assert.NotContains(t, petr, "PasswordHash")
+
+	// Роль и права администратора меняет администратор
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodPut, "/api/users?id="+petrID,
+		map[string]any{"role": "viewer"})
+	require.NotContains(t, m, "error")
+	petrInt, _ := strconv.ParseInt(petrID, 10, 64)
+	user, err := mem.GetUser(petrInt)
+	require.NoError(t, err)
+	assert.Equal(t, database.RoleViewer, user.Role)
+	assert.False(t, user.Admin)
+	for _, tc := range []struct {
+		target string
+		body   map[string]any
+	}{
+		{"/api/users?id=" + petrID, map[string]any{"role": "owner"}},
+		{"/api/users?id=1", map[string]any{"role": "editor"}},
+		{"/api/users?id=999", map[string]any{"role": "editor"}},
+	} {
+		_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodPut, tc.target, tc.body)
+		assert.Contains(t, m, "error", tc.target)
+	}
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodPost, "/api/users",
+		map[string]any{"login": "reader", "password": "reader-password", "role": "viewer"})
+	require.NotContains(t, m, "error")
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodPost, "/api/users",
+		map[string]any{"login": "guest", "password": "guest-password", "role": "guest"})
+	assert.Contains(t, m, "error")
+
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodDelete, "/api/users?id=1", nil)
+	assert.Contains(t, m, "error", "администратора по умолчанию удалить нельзя")
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodDelete, "/api/users?id="+petrID, nil)
+	assert.Empty(t, m)
+	_, m = doUserRequest(t, UsersHandler, auth.DefaultUser, http.MethodDelete, "/api/users?id="+petrID, nil)
+	assert.Contains(t, m, "error")
+}
+
+func TestPasswordHandler(t *testing.T) {
+	mem := useMemoryStore(t)
+	ivan := addUser(t, mem, "ivan", "ivan-password")
+
+	_, m := doUserRequest(t, PasswordHandler, ivan, http.MethodPost, "/api/user/password",
+		map[string]any{"password": "wrong-password", "new_password": "new-password"})
+	assert.Contains(t, m, "error")
+	_, m = doUserRequest(t, PasswordHandler, ivan, http.MethodPost, "/api/user/password",
+		map[string]any{"password": "ivan-password", "new_password": "short"})
+	assert.Contains(t, m, "error")
+	_, m = doUserRequest(t, PasswordHandler, ivan, http.MethodPost, "/api/user/password",
+		map[string]any{"password": "ivan-password", "new_password": "new-password"})
+	assert.NotEmpty(t, m["token"], "после смены пароля выдаются новые токены")
+
+	user, err := mem.GetUser(ivan.ID)
+	require.NoError(t, err)
+	assert.True(t, auth.CheckPassword(user, "new-password"))
+	assert.False(t, auth.CheckPassword(user, "ivan-password"))
+}
+
+func TestRefreshAndSignOut(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("TODO_PASSWORD", "secret-password")
+	addUser(t, mem, "ivan", "ivan-password")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/signin", strings.NewReader(`{"login":"ivan","password":"ivan-password"}`))
+	rec := httptest.NewRecorder()
+	SignInHandler(rec, req)
+	var tokens auth.TokenPair
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	require.NotEmpty(t, tokens.Token)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "refresh_token", cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+
+	// Refresh-токен из cookie обменивается на новую пару один раз
+	refresh := func(cookie *http.Cookie) (int, map[string]any) {
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		RefreshHandler(rec, req)
+		var m map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &m), rec.Body.String())
+		return rec.Code, m
+	}
+	code, m := refresh(cookies[0])
+	assert.Equal(t, http.StatusOK, code)
+	newRefresh, newAccess := m["refresh_token"].(string), m["token"].(string)
+	code, m = refresh(cookies[0])
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Contains(t, m, "error")
+
+	// Выход отзывает оба токена
+	req = httptest.NewRequest(http.MethodPost, "/api/signout", strings.NewReader(`{"refresh_token":"`+newRefresh+`"}`))
+	req.AddCookie(&http.Cookie{Name: "token", Value: newAccess})
+	rec = httptest.NewRecorder()
+	SignOutHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	code, _ = refresh(&http.Cookie{Name: "refresh_token", Value: newRefresh})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTasksAreScopedToUser(t *testing.T) {
+	mem := useMemoryStore(t)
+	ivan := addUser(t, mem, "ivan", "ivan-password")
+
+	_, m := doUserRequest(t, TaskHandler, ivan, http.MethodPost, "/api/task",
+		map[string]any{"date": "20990105", "title": "Задача Ивана"})
+	require.NotContains(t, m, "error")
+	id := m["id"].(string)
+	_, m = doUserRequest(t, TaskHandler, auth.DefaultUser, http.MethodPost, "/api/task",
+		map[string]any{"date": "20990105", "title": "Задача администратора"})
+	require.NotContains(t, m, "error")
+
+	_, m = doUserRequest(t, TasksHandler, ivan, http.MethodGet, "/api/tasks", nil)
+	tasks := m["tasks"].([]any)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "Задача Ивана", tasks[0].(map[string]any)["title"])
+
+	// Чужая задача не видна и не изменяется
+	_, m = doUserRequest(t, TaskHandler, auth.DefaultUser, http.MethodGet, "/api/task?id="+id, nil)
+	assert.Contains(t, m, "error")
+	_, m = doUserRequest(t, TaskDoneHandler, auth.DefaultUser, http.MethodPost, "/api/task/done?id="+id, nil)
+	assert.Contains(t, m, "error")
+	_, m = doUserRequest(t, TaskHandler, auth.DefaultUser, http.MethodDelete, "/api/task?id="+id, nil)
+	assert.Contains(t, m, "error")
+
+	idInt, _ := strconv.ParseInt(id, 10, 64)
+	task, err := mem.ForUser(ivan.ID).Get(idInt)
+	require.NoError(t, err)
+	assert.Equal(t, "Задача Ивана", task.Title)
+}
+
+func TestSignInThrottle(t *testing.T) {
+	mem := useMemoryStore(t)
+	t.Setenv("TODO_PASSWORD", "secret-password")
+	auth.SetSignInLimiter(auth.NewSignInLimiter(auth.DefaultSignInLimits))
+	t.Cleanup(func() { auth.SetSignInLimiter(auth.NewSignInLimiter(auth.DefaultSignInLimits)) })
+	ivan := addUser(t, mem, "ivan", "ivan-password")
+
+	signIn := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/signin", strings.NewReader(`{"login":"ivan","password":"`+password+`"}`))
+		rec := httptest.NewRecorder()
+		SignInHandler(rec, req)
+		return rec
+	}
+	for i := 0; i < auth.DefaultSignInLimits.FreeAttempts; i++ {
+		rec := signIn("wrong-password")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Неверный логин или пароль")
+	}
+	rec := signIn("ivan-password")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), `"code":"too_many_requests"`)
+
+	// Журнал попыток доступен только администратору
+	code, m := doUserRequest(t, SignInLogHandler, ivan, http.MethodGet, "/api/signins", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, m, "error")
+	_, m = doUserRequest(t, SignInLogHandler, auth.DefaultUser, http.MethodGet, "/api/signins?limit=2", nil)
+	attempts := m["attempts"].([]any)
+	require.Len(t, attempts, 2)
+	last := attempts[0].(map[string]any)
+	assert.Equal(t, "ivan", last["login"])
+	assert.Equal(t, auth.ReasonRateLimited, last["reason"])
+	assert.Equal(t, false, last["success"])
+	assert.NotEmpty(t, last["ip"])
+}
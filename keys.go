@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"final_project/auth"
+	db "final_project/database"
+
+	"github.com/joho/godotenv"
+)
+
+const keysUsage = "использование: keys list | rotate [HS256|EdDSA|RS256] | retire KID"
+
+// runKeys() выполняет подкоманду keys: показывает ключи подписи токенов,
+// создаёт новый ключ или выводит ключ из оборота. Запущенный сервер узнаёт
+// об изменениях в течение минуты. Ошибку возвращает в main, как runMigrate().
+func runKeys(args []string) error {
+	if err := godotenv.Load(); err != nil {
+		log.Println("файл .env не загружен: ", err)
+	}
+
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	store, err := db.InitializeDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	auth.SetUsers(store)
+
+	switch args[0] {
+	case "list":
+		keys, err := store.SigningKeys()
+		if err != nil {
+			return err
+		}
+		active := true
+		for _, k := range keys {
+			state := "проверяет токены"
+			switch {
+			case !k.RetiredAt.IsZero():
+				state = "выведен из оборота " + k.RetiredAt.Format(time.DateTime)
+			case active:
+				state, active = "подписывает токены", false
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", k.KID, k.Algorithm, k.CreatedAt.Format(time.DateTime), state)
+		}
+	case "rotate":
+		var alg string
+		if len(args) > 1 {
+			alg = args[1]
+		}
+		kid, err := auth.RotateKey(alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("новый ключ подписи: %s\n", kid)
+	case "retire":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		if err := auth.RetireKey(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("ключ %s выведен из оборота\n", args[1])
+	default:
+		return errors.New(keysUsage)
+	}
+	return nil
+}
@@ -29,26 +29,84 @@ func startServer() {
 		log.Fatal("ошибка загрузки .env файла: ", err)
 	}
 
-	err = db.InitializeDB()
+	store, err := db.InitializeDB()
 	if err != nil {
 		log.Fatal("ошибка при инициализации ДБ: ", err)
 	}
-	defer db.DBconn.Close()
-
-	http.Handle("/", http.FileServer(http.Dir(webDir)))
-	http.HandleFunc("/api/nextdate", handlers.NextDateHandler)
-	http.HandleFunc("/api/task", auth.Auth(handlers.TaskHandler))
-	http.HandleFunc("/api/tasks", auth.Auth(handlers.TasksHandler))
-	http.HandleFunc("/api/task/done", auth.Auth(handlers.TaskDoneHandler))
-	http.HandleFunc("/api/signin", handlers.SignInHandler)
+	defer store.Close()
+	handlers.SetStore(store)
+	auth.SetUsers(store)
+	if err := auth.BootstrapAdmin(os.Getenv("TODO_PASSWORD")); err != nil {
+		log.Fatal("ошибка при создании администратора: ", err)
+	}
+	kid, err := auth.LoadKeys()
+	if err != nil {
+		log.Fatal("ошибка при загрузке ключей подписи: ", err)
+	}
+	log.Println("токены подписываются ключом ", kid)
+	if cfg := auth.OIDCConfigFromEnv(); cfg.Issuer != "" {
+		auth.SetOIDC(auth.NewOIDCProvider(cfg))
+		log.Println("вход через OpenID Connect у ", cfg.Issuer)
+	}
 
-	err = http.ListenAndServe(ports, nil)
+	err = http.ListenAndServe(ports, newRouter(webDir))
 	if err != nil {
 		log.Fatal(fmt.Errorf("can't start a server: %v", err))
 		return
 	}
 }
 
+// protect() требует аутентификацию и роль, достаточную для метода запроса
+func protect(p auth.Permissions, h http.HandlerFunc) http.HandlerFunc {
+	return auth.Auth(auth.Require(p, h))
+}
+
+// newRouter() регистрирует обработчики API вместе с правами доступа к ним:
+// читатели только просматривают задачи, редакторы также изменяют их,
+// управление пользователями доступно администраторам
+func newRouter(webDir string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(webDir)))
+	mux.HandleFunc("/api/nextdate", handlers.NextDateHandler)
+	mux.HandleFunc("/api/nextdates", handlers.NextDatesHandler)
+	mux.HandleFunc("/api/task", protect(auth.ReadAccess, handlers.TaskHandler))
+	mux.HandleFunc("/api/tasks", protect(auth.ReadAccess, handlers.TasksHandler))
+	mux.HandleFunc("/api/tags", protect(auth.ReadAccess, handlers.TagsHandler))
+	mux.HandleFunc("/api/tags/merge", protect(auth.EditorAccess, handlers.TagsMergeHandler))
+	mux.HandleFunc("/api/calendar", protect(auth.ReadAccess, handlers.CalendarHandler))
+	mux.HandleFunc("/api/tasks.ics", auth.FeedAuth(auth.Require(auth.ReadAccess, handlers.TasksICSHandler)))
+	mux.HandleFunc("/api/import/ics", protect(auth.EditorAccess, handlers.ImportICSHandler))
+	mux.HandleFunc("/api/task/done", protect(auth.EditorAccess, handlers.TaskDoneHandler))
+	mux.HandleFunc("/api/signin", handlers.SignInHandler)
+	mux.HandleFunc("/api/signup", handlers.SignUpHandler)
+	mux.HandleFunc("/api/refresh", handlers.RefreshHandler)
+	mux.HandleFunc("/api/signout", handlers.SignOutHandler)
+	mux.HandleFunc("/api/oidc/login", handlers.OIDCLoginHandler)
+	mux.HandleFunc("/api/oidc/callback", handlers.OIDCCallbackHandler)
+	mux.HandleFunc("/api/users", protect(auth.AdminAccess, handlers.UsersHandler))
+	mux.HandleFunc("/api/signins", protect(auth.AdminAccess, handlers.SignInLogHandler))
+	mux.HandleFunc("/api/tokens", protect(auth.ViewerAccess, handlers.APITokensHandler))
+	mux.HandleFunc("/api/user/password", protect(auth.ViewerAccess, handlers.PasswordHandler))
+	mux.HandleFunc("/api/user/totp", protect(auth.ViewerAccess, handlers.TOTPHandler))
+
+	dav := auth.DAVAuth(auth.Require(auth.ReadAccess, handlers.NewCalDAVHandler("/dav").ServeHTTP))
+	mux.Handle("/dav/", dav)
+	mux.Handle("/.well-known/caldav", dav)
+	return mux
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	startServer()
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"final_project/auth"
+	db "final_project/database"
+	"final_project/handlers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterPermissions(t *testing.T) {
+	mem := db.NewMemoryStore()
+	handlers.SetStore(mem)
+	auth.SetUsers(mem)
+	t.Cleanup(func() {
+		handlers.SetStore(nil)
+		auth.SetUsers(nil)
+	})
+	t.Setenv("TODO_PASSWORD", "secret-password")
+	router := newRouter("./web")
+
+	tokens := map[string]string{}
+	for _, u := range []db.User{
+		{Login: "viewer", Role: db.RoleViewer},
+		{Login: "editor", Role: db.RoleEditor},
+		{Login: "boss", Role: db.RoleViewer, Admin: true},
+	} {
+		id, err := mem.CreateUser(u)
+		require.NoError(t, err)
+		user, err := mem.GetUser(id)
+		require.NoError(t, err)
+		pair, err := auth.IssueTokens(user)
+		require.NoError(t, err)
+		tokens[u.Login] = pair.Token
+	}
+
+	const task = `{"id":"1","date":"20990101","title":"Задача"}`
+	const vtodo = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:test\r\nBEGIN:VTODO\r\nUID:x\r\nSUMMARY:x\r\nDTSTAMP:20240101T000000Z\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	for _, tc := range []struct {
+		method, target, body string
+		// Роли, которым разрешён запрос: viewer, editor, admin
+		viewer, editor, admin bool
+	}{
+		{http.MethodGet, "/api/tasks", "", true, true, true},
+		{http.MethodGet, "/api/task?id=1", "", true, true, true},
+		{http.MethodGet, "/api/nextdate?now=20240101&date=20240101&repeat=d%201", "", true, true, true},
+		{http.MethodGet, "/api/calendar?from=20240101&to=20240131", "", true, true, true},
+		{http.MethodGet, "/api/tasks.ics", "", true, true, true},
+		{http.MethodPost, "/api/task", task, false, true, true},
+		{http.MethodPut, "/api/task", task, false, true, true},
+		{http.MethodDelete, "/api/task?id=1", "", false, true, true},
+		{http.MethodPost, "/api/task/done?id=1", "", false, true, true},
+		{http.MethodPost, "/api/import/ics", vtodo, false, true, true},
+		{http.MethodGet, "/api/tokens", "", true, true, true},
+		{http.MethodGet, "/api/user/totp", "", true, true, true},
+		{http.MethodGet, "/api/users", "", false, false, true},
+		{http.MethodGet, "/api/signins", "", false, false, true},
+		{"PROPFIND", "/dav/scheduler/calendars/tasks/", "", true, true, true},
+		{http.MethodPut, "/dav/scheduler/calendars/tasks/x.ics", vtodo, false, true, true},
+		{http.MethodDelete, "/dav/scheduler/calendars/tasks/x.ics", "", false, true, true},
+	} {
+		for login, allowed := range map[string]bool{"viewer": tc.viewer, "editor": tc.editor, "boss": tc.admin} {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+tokens[login])
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			name := tc.method + " " + tc.target + " от " + login
+			assert.NotEqual(t, http.StatusUnauthorized, rec.Code, name)
+			if allowed {
+				assert.NotEqual(t, http.StatusForbidden, rec.Code, name)
+			} else {
+				assert.Equal(t, http.StatusForbidden, rec.Code, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	db "final_project/database"
+
+	"github.com/joho/godotenv"
+)
+
+const migrateUsage = "использование: migrate status | up | down [N]"
+
+// runMigrate() выполняет подкоманду migrate: показывает состояние схемы,
+// применяет или откатывает миграции. Ошибку возвращает в main, чтобы
+// соединение с БД было закрыто до завершения программы.
+func runMigrate(args []string) error {
+	if err := godotenv.Load(); err != nil {
+		log.Println("файл .env не загружен: ", err)
+	}
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	store, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	conn, dialect := store.DB(), store.Dialect()
+
+	switch args[0] {
+	case "status":
+		states, err := db.MigrationStatus(conn, dialect)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			applied := "не применена"
+			if s.AppliedAt != "" {
+				applied = "применена " + s.AppliedAt
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+	case "up":
+		n, err := db.MigrateUp(conn, dialect)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("применено миграций: %d\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return errors.New(migrateUsage)
+			}
+		}
+		n, err := db.MigrateDown(conn, dialect, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("откачено миграций: %d\n", n)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	version, err := db.SchemaVersion(conn)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("текущая версия схемы: %d\n", version)
+	return nil
+}
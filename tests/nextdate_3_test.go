@@ -53,6 +53,32 @@ func TestNextDate(t *testing.T) {
 		}
 	}
 	check()
+
+	// Правила повторения RFC 5545
+	tbl = []nextDate{
+		{"20240101", "FREQ=DAILY;INTERVAL=10", "20240131"},
+		{"20240101", "FREQ=WEEKLY;BYDAY=MO,FR", "20240129"},
+		{"20240101", "RRULE:FREQ=MONTHLY;BYDAY=2TU", "20240213"},
+		{"20240101", "FREQ=MONTHLY;BYDAY=-1FR", "20240223"},
+		{"20240101", "FREQ=MONTHLY;BYMONTHDAY=-1", "20240131"},
+		{"20240101", "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=15", "20240315"},
+		{"20240101", "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", "20240131"},
+		{"20230101", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29", "20240229"},
+		{"20240101", "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", "20241128"},
+		{"20240101", "FREQ=WEEKLY;COUNT=10", "20240129"},
+		{"20240301", "FREQ=MONTHLY;BYMONTHDAY=1", "20240401"},
+		{"20240101", "freq=weekly;byday=sa", "20240127"},
+		{"20240101", "FREQ=DAILY;COUNT=5", ""},
+		{"20240101", "FREQ=WEEKLY;UNTIL=20240120", ""},
+		{"20240101", "FREQ=HOURLY", ""},
+		{"20240101", "FREQ=DAILY;COUNT=3;UNTIL=20240301", ""},
+		{"20240101", "FREQ=MONTHLY;BYDAY=6MO", ""},
+		{"20240101", "FREQ=DAILY;BYWEEKNO=3", ""},
+		{"20240101", "FREQ=WEEKLY;BYDAY=XX", ""},
+		{"20240101", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", ""},
+	}
+	check()
+
 	if !FullNextDate {
 		return
 	}
@@ -70,6 +96,10 @@ func TestNextDate(t *testing.T) {
 		{"20240222", "m -2,-3", ""},
 		{"20240326", "m -1,-2", "20240330"},
 		{"20240201", "m -1,18", "20240218"},
+		{"20240101", "m w2:2", "20240213"},
+		{"20240101", "m w5:-1", "20240223"},
+		{"20240101", "m w4:-1 11", "20241128"},
+		{"20240101", "m w2:6", ""},
 		{"20240125", "w 1,2,3", "20240129"},
 		{"20240126", "w 7", "20240128"},
 		{"20230126", "w 4,5", "20240201"},
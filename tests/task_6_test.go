@@ -28,16 +28,17 @@ func TestTask(t *testing.T) {
 
 	body, err := requestJSON("api/task", nil, http.MethodGet)
 	assert.NoError(t, err)
-	var m map[string]string
-	err = json.Unmarshal(body, &m)
+	var resp map[string]any
+	err = json.Unmarshal(body, &resp)
 	assert.NoError(t, err)
 
-	e, ok := m["error"]
+	e, ok := resp["error"]
 	assert.False(t, !ok || len(fmt.Sprint(e)) == 0,
 		"Ожидается ошибка для вызова /api/task")
 
 	body, err = requestJSON("api/task?id="+todo, nil, http.MethodGet)
 	assert.NoError(t, err)
+	var m map[string]any
 	err = json.Unmarshal(body, &m)
 	assert.NoError(t, err)
 